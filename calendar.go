@@ -0,0 +1,351 @@
+package main
+
+import (
+	"encoding/json"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+// business day conventions, how a date that falls on a holiday or weekend is moved
+const (
+	conventionUnadjusted        = "Unadjusted"        // date is kept as given
+	conventionFollowing         = "Following"         // next business day
+	conventionModifiedFollowing = "ModifiedFollowing" // next business day unless that is in the next month, then previous
+	conventionPreceding         = "Preceding"         // previous business day
+)
+
+var conventions = []string{conventionUnadjusted, conventionFollowing, conventionModifiedFollowing, conventionPreceding}
+
+// markets with a calendar created by Init, more can be added by the Admin
+var defaultMarkets = []string{"US", "UK", "TARGET"}
+
+// Calendar is the holiday calendar of one market. Saturdays and Sundays are never business days.
+type Calendar struct {
+	Market    string
+	Holidays  []string // YYYY-MM-DD, sorted
+	UpdatedBy string
+	UpdatedAt string
+}
+
+func calendarKey(market string) string {
+	return "calendar_" + market
+}
+
+func getCalendar(stub shim.ChaincodeStubInterface, market string) (Calendar, error) {
+	var cal Calendar
+	b, err := stub.GetState(calendarKey(market))
+	if err != nil {
+		return cal, newError(errLedger, "Error while getting calendar "+market+" from ledger")
+	}
+	if len(b) == 0 {
+		return cal, newError(errNotFound, "Calendar not found", "market", market)
+	}
+	err = json.Unmarshal(b, &cal)
+	if err != nil {
+		return cal, newError(errLedger, "Error while unmarshalling calendar "+market)
+	}
+	return cal, nil
+}
+
+func putCalendar(stub shim.ChaincodeStubInterface, cal Calendar) error {
+	b, err := json.Marshal(cal)
+	if err != nil {
+		return newError(errLedger, "Error while marshalling calendar")
+	}
+	err = stub.PutState(calendarKey(cal.Market), b)
+	if err != nil {
+		return newError(errLedger, "Error while writing calendar to ledger")
+	}
+	markets, err := getCalendarList(stub)
+	if err != nil {
+		return err
+	}
+	for _, m := range markets {
+		if m == cal.Market {
+			return nil
+		}
+	}
+	markets = append(markets, cal.Market)
+	b, err = json.Marshal(markets)
+	if err != nil {
+		return newError(errLedger, "Error while marshalling calendar list")
+	}
+	err = stub.PutState("calendarList", b)
+	if err != nil {
+		return newError(errLedger, "Error while writing calendar list to ledger")
+	}
+	return nil
+}
+
+func getCalendarList(stub shim.ChaincodeStubInterface) ([]string, error) {
+	var markets []string
+	b, err := stub.GetState("calendarList")
+	if err != nil {
+		return nil, newError(errLedger, "Error while getting calendar list from ledger")
+	}
+	if len(b) == 0 {
+		return markets, nil
+	}
+	err = json.Unmarshal(b, &markets)
+	if err != nil {
+		return nil, newError(errLedger, "Error while unmarshalling calendar list")
+	}
+	return markets, nil
+}
+
+// instrumentCalendar is the calendar the instrument's dates roll on, an instrument without a market only skips weekends
+func instrumentCalendar(stub shim.ChaincodeStubInterface, inst Instrument) (Calendar, error) {
+	if inst.Calendar == "" {
+		return Calendar{}, nil
+	}
+	return getCalendar(stub, inst.Calendar)
+}
+
+func (cal Calendar) isBusinessDay(d time.Time) bool {
+	if d.Weekday() == time.Saturday || d.Weekday() == time.Sunday {
+		return false
+	}
+	day := formatDate(d)
+	i := sort.SearchStrings(cal.Holidays, day)
+	return i == len(cal.Holidays) || cal.Holidays[i] != day
+}
+
+// roll moves d to a business day according to the convention
+func (cal Calendar) roll(d time.Time, convention string) time.Time {
+	switch convention {
+	case conventionFollowing:
+		return cal.step(d, 1)
+	case conventionModifiedFollowing:
+		next := cal.step(d, 1)
+		if next.Month() != d.Month() {
+			return cal.step(d, -1)
+		}
+		return next
+	case conventionPreceding:
+		return cal.step(d, -1)
+	}
+	return d
+}
+
+// step moves d a day at a time in direction until it lands on a business day
+func (cal Calendar) step(d time.Time, direction int) time.Time {
+	for !cal.isBusinessDay(d) {
+		d = d.AddDate(0, 0, direction)
+	}
+	return d
+}
+
+// addBusinessDays is the date n business days after d
+func (cal Calendar) addBusinessDays(d time.Time, n int) time.Time {
+	for n > 0 {
+		d = d.AddDate(0, 0, 1)
+		if cal.isBusinessDay(d) {
+			n--
+		}
+	}
+	return d
+}
+
+// rollInstrumentDate returns the stored date rolled on the instrument's calendar and convention
+func rollInstrumentDate(stub shim.ChaincodeStubInterface, inst Instrument, date string) (string, error) {
+	d, err := parseDate(date)
+	if err != nil {
+		return "", newError(errInvalidArgument, "Invalid date "+date+", expecting YYYY-MM-DD", "date", date)
+	}
+	cal, err := instrumentCalendar(stub, inst)
+	if err != nil {
+		return "", err
+	}
+	return formatDate(cal.roll(d, inst.BusinessDayConvention)), nil
+}
+
+// tradeSettlementDate is T+N business days on the instrument's calendar
+func tradeSettlementDate(stub shim.ChaincodeStubInterface, inst Instrument, tradeDate time.Time) (string, error) {
+	cal, err := instrumentCalendar(stub, inst)
+	if err != nil {
+		return "", err
+	}
+	return formatDate(cal.addBusinessDays(tradeDate, inst.SettlementDays)), nil
+}
+
+/*	setCalendar - Admin replaces the holidays of a market, creating the calendar if it is new
+		args 0	:	Caller (Admin)
+		args 1	:	Market
+		args 2	:	Holiday (YYYY-MM-DD)
+		...		:	further holidays
+*/
+func (t *SimpleChaincode) setCalendar(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	if len(args) < 2 {
+		return nil, newError(errArgumentCount, "Incorrect number of arguments")
+	}
+	caller, err := getEntityState(stub, args[0])
+	if err != nil {
+		return nil, err
+	}
+	if caller.EntityType != "Admin" {
+		return nil, newError(errNotAuthorized, "Only the Admin can maintain calendars")
+	}
+	timeStamp, err := txTimeStamp(stub)
+	if err != nil {
+		return nil, err
+	}
+	cal := Calendar{Market: args[1], UpdatedBy: caller.EntityID, UpdatedAt: timeStamp}
+	seen := make(map[string]bool)
+	for _, h := range args[2:] {
+		day, err := normalizeDate(h)
+		if err != nil {
+			return nil, err
+		}
+		if !seen[day] {
+			seen[day] = true
+			cal.Holidays = append(cal.Holidays, day)
+		}
+	}
+	sort.Strings(cal.Holidays)
+	err = putCalendar(stub, cal)
+	if err != nil {
+		return nil, err
+	}
+	return nil, nil
+}
+
+/*	setInstrumentCalendar - how the dates of an instrument roll and when its trades settle
+		args 0	:	Caller (Issuer of the instrument)
+		args 1	:	Symbol
+		args 2	:	Market of the holiday calendar
+		args 3	:	Business day convention (Unadjusted/Following/ModifiedFollowing/Preceding)
+		args 4	:	Settlement days, trades settle T+N business days
+*/
+func (t *SimpleChaincode) setInstrumentCalendar(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	if len(args) != 5 {
+		return nil, newError(errArgumentCount, "Incorrect number of arguments")
+	}
+	inst, err := getInstrumentState(stub, args[1])
+	if err != nil {
+		return nil, err
+	}
+	if args[0] != inst.Issuer {
+		return nil, newError(errNotAuthorized, "Only the Issuer can change the calendar of "+inst.Symbol)
+	}
+	settlementDays, err := strconv.Atoi(args[4])
+	if err != nil || settlementDays < 0 {
+		return nil, newError(errInvalidArgument, "Invalid settlement days "+args[4])
+	}
+	err = applyInstrumentCalendar(stub, &inst, args[2], args[3], settlementDays)
+	if err != nil {
+		return nil, err
+	}
+	err = putInstrumentState(stub, inst)
+	if err != nil {
+		return nil, err
+	}
+	return nil, nil
+}
+
+// unadjustedDate is the date as agreed, dates stored before unadjusted dates were kept are taken as agreed
+func unadjustedDate(unadjusted string, date string) string {
+	if unadjusted != "" {
+		return unadjusted
+	}
+	return date
+}
+
+// applyInstrumentCalendar sets the date rules of the instrument and rolls its dates on them. Dates are always rolled
+// from the agreed dates, so changing the calendar again does not roll an already rolled date further. Notices given
+// for a put move with its date.
+func applyInstrumentCalendar(stub shim.ChaincodeStubInterface, inst *Instrument, market string, convention string, settlementDays int) error {
+	_, err := getCalendar(stub, market)
+	if err != nil {
+		return err
+	}
+	inst.Calendar = market
+	inst.BusinessDayConvention = convention
+	inst.SettlementDays = settlementDays
+
+	inst.UnadjustedSettlementDate = unadjustedDate(inst.UnadjustedSettlementDate, inst.SettlementDate)
+	inst.SettlementDate, err = rollInstrumentDate(stub, *inst, inst.UnadjustedSettlementDate)
+	if err != nil {
+		return err
+	}
+	inst.UnadjustedIssueDate = unadjustedDate(inst.UnadjustedIssueDate, inst.IssueDate)
+	inst.IssueDate, err = rollInstrumentDate(stub, *inst, inst.UnadjustedIssueDate)
+	if err != nil {
+		return err
+	}
+	for i := range inst.RedemptionSchedule {
+		r := &inst.RedemptionSchedule[i]
+		if r.Status != "Scheduled" {
+			continue
+		}
+		r.UnadjustedDate = unadjustedDate(r.UnadjustedDate, r.Date)
+		r.Date, err = rollInstrumentDate(stub, *inst, r.UnadjustedDate)
+		if err != nil {
+			return err
+		}
+	}
+	for i := range inst.CouponSchedule {
+		c := &inst.CouponSchedule[i]
+		if c.Status != "Scheduled" {
+			continue
+		}
+		c.UnadjustedDate = unadjustedDate(c.UnadjustedDate, c.Date)
+		c.Date, err = rollInstrumentDate(stub, *inst, c.UnadjustedDate)
+		if err != nil {
+			return err
+		}
+	}
+	moved := make(map[string]string)
+	for i := range inst.PutSchedule {
+		put := &inst.PutSchedule[i]
+		if put.Status != "Open" {
+			continue
+		}
+		put.UnadjustedDate = unadjustedDate(put.UnadjustedDate, put.Date)
+		date, err := rollInstrumentDate(stub, *inst, put.UnadjustedDate)
+		if err != nil {
+			return err
+		}
+		moved[put.Date] = date
+		put.Date = date
+	}
+	for i := range inst.PutExercises {
+		e := &inst.PutExercises[i]
+		if date, ok := moved[e.PutDate]; ok && e.Status == "Pending" {
+			e.PutDate = date
+		}
+	}
+	return nil
+}
+
+/*	getCalendar
+		args 0	:	Market (optional, every calendar when omitted)
+*/
+func (t *SimpleChaincode) getCalendar(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	if len(args) > 1 {
+		return nil, newError(errArgumentCount, "Incorrect number of arguments")
+	}
+	markets := args
+	if len(args) == 0 {
+		var err error
+		markets, err = getCalendarList(stub)
+		if err != nil {
+			return nil, err
+		}
+	}
+	calendars := make([]Calendar, len(markets))
+	for i, market := range markets {
+		cal, err := getCalendar(stub, market)
+		if err != nil {
+			return nil, err
+		}
+		calendars[i] = cal
+	}
+	b, err := json.Marshal(calendars)
+	if err != nil {
+		return nil, newError(errLedger, "Error while marshalling calendars")
+	}
+	return b, nil
+}
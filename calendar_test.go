@@ -0,0 +1,64 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestRoll(t *testing.T) {
+	cal := Calendar{Market: "US", Holidays: []string{"2017-03-31", "2017-04-03"}}
+	friday, _ := parseDate("2017-03-31")
+	cases := []struct {
+		convention string
+		want       string
+	}{
+		{conventionUnadjusted, "2017-03-31"},
+		{conventionFollowing, "2017-04-04"},
+		{conventionModifiedFollowing, "2017-03-30"}, // following is in April, so it goes back
+		{conventionPreceding, "2017-03-30"},
+	}
+	for _, c := range cases {
+		if got := formatDate(cal.roll(friday, c.convention)); got != c.want {
+			t.Errorf("%s rolls %s to %s, expected %s", c.convention, "2017-03-31", got, c.want)
+		}
+	}
+	thursday, _ := parseDate("2017-03-30")
+	if got := formatDate(cal.addBusinessDays(thursday, 2)); got != "2017-04-05" {
+		t.Errorf("T+2 from 2017-03-30 is %s, expected 2017-04-05", got)
+	}
+	if cal.isBusinessDay(thursday.Add(48 * time.Hour)) {
+		t.Error("Saturday is a business day")
+	}
+}
+
+func TestInstrumentCalendarRollsFromAgreedDates(t *testing.T) {
+	s := putFixture(t)
+	s.mustInvoke(t, entity7, "exercisePut", entity7, "INST2001", "2017-03-10", "100")
+	s.mustInvoke(t, entity11, "setCalendar", entity11, "US", "2017-03-10")
+
+	s.mustInvoke(t, entity1, "setInstrumentCalendar", entity1, "INST2001", "US", conventionFollowing, "2")
+	inst := s.instrument(t, "INST2001")
+	if inst.PutSchedule[0].Date != "2017-03-13" || inst.PutSchedule[0].UnadjustedDate != "2017-03-10" {
+		t.Fatalf("put date not rolled from the agreed date: %+v", inst.PutSchedule[0])
+	}
+	if inst.PutExercises[0].PutDate != "2017-03-13" {
+		t.Errorf("pending notice did not move with its put: %+v", inst.PutExercises[0])
+	}
+
+	// rolled again from the agreed date, not from the date rolled before
+	s.mustInvoke(t, entity1, "setInstrumentCalendar", entity1, "INST2001", "US", conventionPreceding, "2")
+	inst = s.instrument(t, "INST2001")
+	if inst.PutSchedule[0].Date != "2017-03-09" || inst.PutExercises[0].PutDate != "2017-03-09" {
+		t.Fatalf("expected put and notice on 2017-03-09: %+v %+v", inst.PutSchedule[0], inst.PutExercises[0])
+	}
+	if inst.SettlementDate != "2018-03-01" || inst.UnadjustedSettlementDate != "2018-03-01" {
+		t.Errorf("maturity on a business day should not move: %s", inst.SettlementDate)
+	}
+
+	// the notice settles on the rolled date
+	s.setTime(t, "2017-03-09T10:00:00Z")
+	s.mustInvoke(t, entity1, "settlePuts", entity1, "INST2001")
+	if s.instrument(t, "INST2001").PutExercises[0].Status != "Settled" {
+		t.Error("notice not settled with its put")
+	}
+}
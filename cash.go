@@ -0,0 +1,190 @@
+package main
+
+import (
+	"encoding/json"
+	"strconv"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+// CashLedger is the cash movement history of one entity, used for reconciliation with the payment bank
+type CashLedger struct {
+	EntityID         string
+	Balance          float64
+	TotalDeposits    float64
+	TotalWithdrawals float64
+	Movements        []Transaction
+}
+
+/*	depositCash - settlement agent attests that funds arrived at the payment bank
+		args 0	:	Caller (SettlementAgent)
+		args 1	:	Entity ID
+		args 2	:	Amount
+		args 3	:	External reference of the payment
+*/
+func (t *SimpleChaincode) depositCash(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	return t.moveCash(stub, args, "Cash Deposit")
+}
+
+/*	withdrawCash - settlement agent attests that funds were paid out by the payment bank
+		args 0	:	Caller (SettlementAgent)
+		args 1	:	Entity ID
+		args 2	:	Amount
+		args 3	:	External reference of the payment
+*/
+func (t *SimpleChaincode) withdrawCash(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	return t.moveCash(stub, args, "Cash Withdrawal")
+}
+
+func (t *SimpleChaincode) moveCash(stub shim.ChaincodeStubInterface, args []string, movementType string) ([]byte, error) {
+	if len(args) != 4 {
+		return nil, newError(errArgumentCount, "Incorrect number of arguments")
+	}
+	caller := args[0]
+	entityID := args[1]
+	externalRef := args[3]
+	agent, err := getEntityState(stub, caller)
+	if err != nil {
+		return nil, err
+	}
+	if agent.EntityType != "SettlementAgent" {
+		return nil, newError(errNotAuthorized, "Only the Settlement Agent can move cash")
+	}
+	amount, err := strconv.ParseFloat(args[2], 64)
+	if err != nil || amount <= 0 {
+		return nil, newError(errInvalidArgument, "Invalid amount " + args[2])
+	}
+	if externalRef == "" {
+		return nil, newError(errInvalidArgument, "External reference is required")
+	}
+
+	// a payment is only ever booked once
+	refKey := "extref_" + caller + "_" + externalRef
+	refByte, err := stub.GetState(refKey)
+	if err != nil {
+		return nil, newError(errLedger, "Error while getting external reference from ledger")
+	}
+	if len(refByte) != 0 {
+		return nil, newError(errAlreadyExists, "External reference " + externalRef + " already booked as " + string(refByte))
+	}
+
+	entity, err := getEntityState(stub, entityID)
+	if err != nil {
+		return nil, err
+	}
+	if entity.EntityType == "SettlementAgent" || entity.EntityType == "RegBody" {
+		return nil, newError(errInvalidArgument, "Entity " + entityID + " does not hold cash", "entity", entityID)
+	}
+
+	transactionID, err := nextTransactionID(stub)
+	if err != nil {
+		return nil, err
+	}
+	if movementType == "Cash Withdrawal" {
+		_, err = postJournalEntry(stub, accountPaymentRail, entityID, amount, movementType, transactionID)
+	} else {
+		_, err = postJournalEntry(stub, entityID, accountPaymentRail, amount, movementType, transactionID)
+	}
+	if err != nil {
+		return nil, err
+	}
+	tr := Transaction{
+		TransactionID:   transactionID,
+		TransactionType: movementType,
+		FromUser:        caller,
+		ToUser:          entityID,
+		Amount:          amount,
+		ExternalRef:     externalRef,
+		Status:          "Success",
+	}
+	err = recordTransaction(stub, tr)
+	if err != nil {
+		return nil, err
+	}
+	err = stub.PutState(refKey, []byte(transactionID))
+	if err != nil {
+		return nil, newError(errLedger, "Error while writing external reference to ledger")
+	}
+
+	err = addToIndex(stub, indexEntityCash, entityID, transactionID)
+	if err != nil {
+		return nil, err
+	}
+	eventType := eventCashDeposited
+	if movementType == "Cash Withdrawal" {
+		eventType = eventCashWithdrawn
+	}
+	emitEvent(stub, MarketEvent{Type: eventType, TransactionID: transactionID, Amount: amount, Parties: []string{entityID, caller}})
+	return []byte(transactionID), nil
+}
+
+/*	getCashLedger
+		args 0	:	Caller (the entity itself, SettlementAgent or RegBody)
+		args 1	:	Entity ID (optional, every entity when omitted)
+*/
+func (t *SimpleChaincode) getCashLedger(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	if len(args) < 1 || len(args) > 2 {
+		return nil, newError(errArgumentCount, "Incorrect number of arguments")
+	}
+	caller, err := getEntityState(stub, args[0])
+	if err != nil {
+		return nil, err
+	}
+	privileged := caller.EntityType == "SettlementAgent" || caller.EntityType == "RegBody"
+
+	var entityIDs []string
+	if len(args) == 2 {
+		entityIDs = []string{args[1]}
+	} else {
+		listByte, err := stub.GetState("entityList")
+		if err != nil {
+			return nil, newError(errLedger, "Error while getting entity list from ledger")
+		}
+		err = json.Unmarshal(listByte, &entityIDs)
+		if err != nil {
+			return nil, newError(errLedger, "Error while unmarshalling entity list")
+		}
+	}
+
+	var ledgers []CashLedger
+	for _, id := range entityIDs {
+		if id != caller.EntityID && !privileged {
+			if len(args) == 2 {
+				return nil, newError(errNotAuthorized, "Not authorised to read the cash ledger of " + id)
+			}
+			continue
+		}
+		entity, err := getEntityState(stub, id)
+		if err != nil {
+			return nil, err
+		}
+		movements, err := getIndexIDs(stub, indexEntityCash, id)
+		if err != nil {
+			return nil, err
+		}
+		ledger := CashLedger{EntityID: id, Balance: entity.Balance}
+		for _, transactionID := range movements {
+			b, err := stub.GetState(transactionID)
+			if err != nil {
+				return nil, newError(errLedger, "Error while getting transaction " + transactionID)
+			}
+			var tr Transaction
+			err = json.Unmarshal(b, &tr)
+			if err != nil {
+				return nil, newError(errLedger, "Error while unmarshalling transaction " + transactionID)
+			}
+			if tr.TransactionType == "Cash Withdrawal" {
+				ledger.TotalWithdrawals = ledger.TotalWithdrawals + tr.Amount
+			} else {
+				ledger.TotalDeposits = ledger.TotalDeposits + tr.Amount
+			}
+			ledger.Movements = append(ledger.Movements, tr)
+		}
+		ledgers = append(ledgers, ledger)
+	}
+	b, err := json.Marshal(ledgers)
+	if err != nil {
+		return nil, newError(errLedger, "Error while marshalling cash ledger")
+	}
+	return b, nil
+}
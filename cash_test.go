@@ -0,0 +1,13 @@
+package main
+
+import "testing"
+
+func TestCashOnlyForHolders(t *testing.T) {
+	s := newTestStub(t, "2017-03-01T10:00:00Z")
+	_, err := s.invoke(entity10, "depositCash", entity10, entity9, "1000", "REF1")
+	assertCode(t, err, errInvalidArgument)
+	_, err = s.invoke(entity10, "withdrawCash", entity10, entity10, "1000", "REF2")
+	assertCode(t, err, errInvalidArgument)
+	_, err = s.invoke(entity7, "depositCash", entity7, entity7, "1000", "REF3")
+	assertCode(t, err, errNotAuthorized)
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"encoding/json"
+	"strconv"
+)
+
+// error categories clients can branch on
+const (
+	categoryValidation        = "validation"
+	categoryAuthorization     = "authorization"
+	categoryNotFound          = "not-found"
+	categoryConflict          = "conflict"
+	categoryInsufficientFunds = "insufficient-funds"
+	categoryInternal          = "internal"
+)
+
+// error codes, these are part of the client contract and must not be renamed
+const (
+	errArgumentCount     = "ARGUMENT_COUNT"
+	errInvalidArgument   = "INVALID_ARGUMENT"
+	errUnknownFunction   = "UNKNOWN_FUNCTION"
+	errNotAuthorized     = "NOT_AUTHORIZED"
+	errNotFound          = "NOT_FOUND"
+	errInvalidState      = "INVALID_STATE"
+	errAlreadyExists     = "ALREADY_EXISTS"
+	errInsufficientFunds = "INSUFFICIENT_FUNDS"
+	errLimitExceeded     = "LIMIT_EXCEEDED"
+	errMandateBreach     = "MANDATE_BREACH"
+	errLedger            = "LEDGER_ERROR"
+	errInternal          = "INTERNAL_ERROR"
+)
+
+var errorCatalog = map[string]string{
+	errArgumentCount:     categoryValidation,
+	errInvalidArgument:   categoryValidation,
+	errUnknownFunction:   categoryValidation,
+	errNotAuthorized:     categoryAuthorization,
+	errNotFound:          categoryNotFound,
+	errInvalidState:      categoryConflict,
+	errAlreadyExists:     categoryConflict,
+	errInsufficientFunds: categoryInsufficientFunds,
+	errLimitExceeded:     categoryConflict,
+	errMandateBreach:     categoryConflict,
+	errLedger:            categoryInternal,
+	errInternal:          categoryInternal,
+}
+
+//==============================================================================================================================
+//	 ChaincodeError - Every error returned by Invoke and Query. Error() is the JSON form, which is what the client
+//					  receives as the chaincode response.
+//==============================================================================================================================
+type ChaincodeError struct {
+	Code     string
+	Category string
+	Message  string
+	Details  map[string]string `json:",omitempty"`
+}
+
+func (e *ChaincodeError) Error() string {
+	b, err := json.Marshal(e)
+	if err != nil {
+		return `{"Code":"` + errInternal + `","Category":"` + categoryInternal + `","Message":"Error while marshalling error"}`
+	}
+	return string(b)
+}
+
+// newError builds a catalogued error, details are given as key, value pairs
+func newError(code string, message string, details ...string) error {
+	category, ok := errorCatalog[code]
+	if !ok {
+		code = errInternal
+		category = categoryInternal
+	}
+	e := &ChaincodeError{Code: code, Category: category, Message: message}
+	if len(details) > 1 {
+		e.Details = make(map[string]string)
+		for i := 0; i+1 < len(details); i = i + 2 {
+			e.Details[details[i]] = details[i+1]
+		}
+	}
+	return e
+}
+
+// insufficientFunds reports the amount that was needed against what the entity had available
+func insufficientFunds(entityID string, required float64, available float64) error {
+	return newError(errInsufficientFunds, "Inssufficient Balance for Entity"+entityID,
+		"entity", entityID,
+		"required", strconv.FormatFloat(required, 'f', 2, 64),
+		"available", strconv.FormatFloat(available, 'f', 2, 64))
+}
+
+// toChaincodeError makes sure anything leaving the chaincode is a catalogued error
+func toChaincodeError(err error) error {
+	if err == nil {
+		return nil
+	}
+	if _, ok := err.(*ChaincodeError); ok {
+		return err
+	}
+	return newError(errInternal, err.Error())
+}
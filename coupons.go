@@ -0,0 +1,90 @@
+package main
+
+import (
+	"sort"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+// payingInstrument loads an instrument the caller pays on, the issuer itself or the Settlement Agent on its behalf.
+// Anyone else triggering a payment could run the issuer's cash down and push it into default.
+func payingInstrument(stub shim.ChaincodeStubInterface, callerID string, symbol string) (Instrument, error) {
+	inst, err := getInstrumentState(stub, symbol)
+	if err != nil {
+		return inst, err
+	}
+	caller, err := getEntityState(stub, callerID)
+	if err != nil {
+		return inst, err
+	}
+	if caller.EntityID != inst.Issuer && caller.EntityType != "SettlementAgent" {
+		return inst, newError(errNotAuthorized, "Only the Issuer or the Settlement Agent can pay on "+inst.Symbol)
+	}
+	if inst.Status == "Expired" || inst.Status == "Redeemed" {
+		return inst, newError(errInvalidState, "Instrument "+inst.Symbol+" is no longer outstanding")
+	}
+	return inst, nil
+}
+
+/*	setCouponSchedule - sets the coupon dates still to be paid, periods already paid or missed are kept
+		args 0	:	Caller (Issuer of the instrument)
+		args 1	:	Symbol
+		args 2	:	Coupon date (YYYY-MM-DD)
+		...		:	further coupon dates
+*/
+func (t *SimpleChaincode) setCouponSchedule(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	if len(args) < 3 {
+		return nil, newError(errArgumentCount, "Incorrect number of arguments")
+	}
+	inst, err := getInstrumentState(stub, args[1])
+	if err != nil {
+		return nil, err
+	}
+	if args[0] != inst.Issuer {
+		return nil, newError(errNotAuthorized, "Only the Issuer can set the coupon schedule")
+	}
+	if inst.Status == "Expired" || inst.Status == "Redeemed" || inst.Status == "Defaulted" {
+		return nil, newError(errInvalidState, "Instrument "+inst.Symbol+" is no longer outstanding")
+	}
+
+	var schedule []CouponPayment
+	settled := make(map[string]bool)
+	for _, c := range inst.CouponSchedule {
+		if c.Status != "Scheduled" {
+			schedule = append(schedule, c)
+			settled[c.Date] = true
+		}
+	}
+	seen := make(map[string]bool)
+	for _, d := range args[2:] {
+		unadjusted, err := normalizeDate(d)
+		if err != nil {
+			return nil, err
+		}
+		date, err := rollInstrumentDate(stub, inst, unadjusted)
+		if err != nil {
+			return nil, err
+		}
+		if settled[date] {
+			return nil, newError(errInvalidState, "Coupon of "+date+" has been settled already", "date", date)
+		}
+		if !seen[date] {
+			seen[date] = true
+			schedule = append(schedule, CouponPayment{Date: date, UnadjustedDate: unadjusted, Status: "Scheduled"})
+		}
+	}
+	sort.Sort(byCouponDate(schedule))
+
+	inst.CouponSchedule = schedule
+	err = putInstrumentState(stub, inst)
+	if err != nil {
+		return nil, err
+	}
+	return nil, nil
+}
+
+type byCouponDate []CouponPayment
+
+func (c byCouponDate) Len() int           { return len(c) }
+func (c byCouponDate) Swap(i, j int)      { c[i], c[j] = c[j], c[i] }
+func (c byCouponDate) Less(i, j int) bool { return c[i].Date < c[j].Date }
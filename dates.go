@@ -0,0 +1,174 @@
+package main
+
+import (
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+// Business dates (issue, maturity, schedule dates) are calendar dates without a time of day and are stored as
+// ISO-8601 dates. Timestamps are instants and are stored as ISO-8601 in UTC.
+const dateLayout = "2006-01-02"
+const timeStampLayout = time.RFC3339
+
+// formats written before dates were typed, still accepted from callers and read from older records
+const legacyDateLayout = "01/02/2006"               // MM/DD/YYYY
+const legacyTimeStampLayout = "2006-01-02 15:04:05" // peer local time without zone, read as UTC
+
+// parseDate reads a business date in either the ISO or the legacy format
+func parseDate(s string) (time.Time, error) {
+	d, err := time.Parse(dateLayout, s)
+	if err == nil {
+		return d, nil
+	}
+	return time.Parse(legacyDateLayout, s)
+}
+
+// normalizeDate returns the date in the stored ISO format
+func normalizeDate(s string) (string, error) {
+	d, err := parseDate(s)
+	if err != nil {
+		return "", newError(errInvalidArgument, "Invalid date "+s+", expecting YYYY-MM-DD", "date", s)
+	}
+	return formatDate(d), nil
+}
+
+func formatDate(d time.Time) string {
+	return d.Format(dateLayout)
+}
+
+func parseTimeStamp(s string) (time.Time, error) {
+	ts, err := time.Parse(timeStampLayout, s)
+	if err == nil {
+		return ts, nil
+	}
+	return time.Parse(legacyTimeStampLayout, s)
+}
+
+func formatTimeStamp(ts time.Time) string {
+	return ts.UTC().Format(timeStampLayout)
+}
+
+// dateOf is the business date of a stored timestamp, empty when it cannot be read
+func dateOf(timeStamp string) string {
+	ts, err := parseTimeStamp(timeStamp)
+	if err != nil {
+		return ""
+	}
+	return formatDate(ts.UTC())
+}
+
+// txTime is the timestamp of the transaction proposal. Every endorsing peer sees the same value, so it is the only
+// clock chaincode may read: the peer's own clock differs between endorsers and their read-write sets would diverge.
+func txTime(stub shim.ChaincodeStubInterface) (time.Time, error) {
+	ts, err := stub.GetTxTimestamp()
+	if err != nil || ts == nil {
+		return time.Time{}, newError(errLedger, "Error while getting transaction timestamp")
+	}
+	return time.Unix(ts.Seconds, int64(ts.Nanos)).UTC(), nil
+}
+
+// txTimeStamp is the timestamp put on records written by this invocation
+func txTimeStamp(stub shim.ChaincodeStubInterface) (string, error) {
+	now, err := txTime(stub)
+	if err != nil {
+		return "", err
+	}
+	return formatTimeStamp(now), nil
+}
+
+// dateRange is an inclusive range of business dates, a zero bound leaves that side open
+type dateRange struct {
+	From time.Time
+	To   time.Time
+}
+
+// parseDateRange reads the optional from and to arguments at args[i] and args[i+1]
+func parseDateRange(args []string, i int) (dateRange, error) {
+	var r dateRange
+	var err error
+	if len(args) > i && args[i] != "" {
+		r.From, err = parseDate(args[i])
+		if err != nil {
+			return r, newError(errInvalidArgument, "Invalid from date "+args[i]+", expecting YYYY-MM-DD")
+		}
+	}
+	if len(args) > i+1 && args[i+1] != "" {
+		r.To, err = parseDate(args[i+1])
+		if err != nil {
+			return r, newError(errInvalidArgument, "Invalid to date "+args[i+1]+", expecting YYYY-MM-DD")
+		}
+	}
+	return r, nil
+}
+
+// contains reports whether the instant falls on a day within the range
+func (r dateRange) contains(t time.Time) bool {
+	if !r.From.IsZero() && t.Before(r.From) {
+		return false
+	}
+	if !r.To.IsZero() && !t.Before(r.To.AddDate(0, 0, 1)) {
+		return false
+	}
+	return true
+}
+
+// containsDate is contains for a stored business date, records without a readable date fall outside any bounded range
+func (r dateRange) containsDate(s string) bool {
+	d, err := parseDate(s)
+	if err != nil {
+		return r.From.IsZero() && r.To.IsZero()
+	}
+	return r.contains(d)
+}
+
+// containsTimeStamp is contains for a stored timestamp
+func (r dateRange) containsTimeStamp(s string) bool {
+	ts, err := parseTimeStamp(s)
+	if err != nil {
+		return r.From.IsZero() && r.To.IsZero()
+	}
+	return r.contains(ts)
+}
+
+// upgradeInstrumentDates converts the dates of an instrument written before they were typed, it is the version 1
+// migration in schema.go so older records are converted on read and by migrate
+func upgradeInstrumentDates(inst *Instrument) {
+	inst.SettlementDate = upgradeDate(inst.SettlementDate)
+	inst.IssueDate = upgradeDate(inst.IssueDate)
+	for i := range inst.RedemptionSchedule {
+		inst.RedemptionSchedule[i].Date = upgradeDate(inst.RedemptionSchedule[i].Date)
+	}
+	for i := range inst.PutSchedule {
+		inst.PutSchedule[i].Date = upgradeDate(inst.PutSchedule[i].Date)
+	}
+	for i := range inst.PutExercises {
+		inst.PutExercises[i].PutDate = upgradeDate(inst.PutExercises[i].PutDate)
+	}
+}
+
+func upgradeTransactionDates(tr *Transaction) {
+	tr.TimeStamp = upgradeTimeStamp(tr.TimeStamp)
+	// SettlementDate used to be a time.Time that was never set
+	if _, err := parseDate(tr.SettlementDate); err != nil {
+		tr.SettlementDate = ""
+	}
+	tr.SettlementDate = upgradeDate(tr.SettlementDate)
+}
+
+// upgradeDate converts a stored date, values that cannot be read are kept so no data is lost
+func upgradeDate(s string) string {
+	d, err := parseDate(s)
+	if err != nil {
+		return s
+	}
+	return formatDate(d)
+}
+
+func upgradeTimeStamp(s string) string {
+	ts, err := parseTimeStamp(s)
+	if err != nil {
+		return s
+	}
+	return formatTimeStamp(ts)
+}
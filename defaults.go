@@ -0,0 +1,304 @@
+package main
+
+import (
+	"encoding/json"
+	"strconv"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+// DefaultExposure is what a single holder stands to lose on a defaulted issue
+type DefaultExposure struct {
+	Symbol    string
+	Issuer    string
+	Holder    string
+	Quantity  int
+	Exposure  float64 // par value of the position
+	Recovered float64
+	Net       float64
+}
+
+//==============================================================================================================================
+//	 markDefault - Records a credit event for a missed payment and moves the instrument to "Defaulted". The instrument
+//				   passed in is written back, so callers can hand over any changes they made before the miss.
+//==============================================================================================================================
+func markDefault(stub shim.ChaincodeStubInterface, inst Instrument, eventType string, amountDue float64, balance float64) (string, error) {
+	ctidByte, err := stub.GetState("currentCreditEventNum")
+	if err != nil {
+		return "", newError(errLedger, "Error while getting currentCreditEventNum from ledger")
+	}
+	num, err := strconv.Atoi(string(ctidByte))
+	if err != nil {
+		return "", newError(errLedger, "Error while converting ctidByte to integer")
+	}
+	num = num + 1
+	timeStamp, err := txTimeStamp(stub)
+	if err != nil {
+		return "", err
+	}
+	event := CreditEvent{
+		EventID:       "CE" + strconv.Itoa(num),
+		Symbol:        inst.Symbol,
+		Issuer:        inst.Issuer,
+		EventType:     eventType,
+		AmountDue:     amountDue,
+		IssuerBalance: balance,
+		TimeStamp:     timeStamp,
+	}
+	b, err := json.Marshal(event)
+	if err != nil {
+		return "", newError(errLedger, "Error while marshalling credit event")
+	}
+	err = stub.PutState(event.EventID, b)
+	if err != nil {
+		return "", newError(errLedger, "Error while writing credit event to ledger")
+	}
+	err = stub.PutState("currentCreditEventNum", []byte(strconv.Itoa(num)))
+	if err != nil {
+		return "", newError(errLedger, "Error while writing currentCreditEventNum to ledger")
+	}
+	emitEvent(stub, MarketEvent{Type: eventCreditEvent, Symbol: inst.Symbol, Status: eventType, PreviousStatus: inst.Status, Amount: amountDue, Parties: []string{inst.Issuer, inst.Owner}})
+
+	inst.Status = "Defaulted"
+	inst.CreditEvents = append(inst.CreditEvents, event.EventID)
+	err = putInstrumentState(stub, inst)
+	if err != nil {
+		return "", err
+	}
+
+	defaulted, err := getDefaultedList(stub)
+	if err != nil {
+		return "", err
+	}
+	for _, v := range defaulted {
+		if v == inst.Symbol {
+			return event.EventID, nil
+		}
+	}
+	defaulted = append(defaulted, inst.Symbol)
+	b, err = json.Marshal(defaulted)
+	if err != nil {
+		return "", newError(errLedger, "Error while marshalling defaulted list")
+	}
+	err = stub.PutState("defaultedList", b)
+	if err != nil {
+		return "", newError(errLedger, "Error while writing defaulted list to ledger")
+	}
+	return event.EventID, nil
+}
+
+func getDefaultedList(stub shim.ChaincodeStubInterface) ([]string, error) {
+	var defaulted []string
+	b, err := stub.GetState("defaultedList")
+	if err != nil {
+		return nil, newError(errLedger, "Error while getting defaulted list from ledger")
+	}
+	if len(b) == 0 {
+		return defaulted, nil
+	}
+	err = json.Unmarshal(b, &defaulted)
+	if err != nil {
+		return nil, newError(errLedger, "Error while unmarshalling defaulted list")
+	}
+	return defaulted, nil
+}
+
+/*	distributeRecovery - Issuer pays a recovery amount on a defaulted issue, split pro rata across holders
+		args 0	:	Caller (Issuer)
+		args 1	:	Symbol
+		args 2	:	Amount
+*/
+func (t *SimpleChaincode) distributeRecovery(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	if len(args) != 3 {
+		return nil, newError(errArgumentCount, "Incorrect number of arguments")
+	}
+	caller := args[0]
+	inst, err := getInstrumentState(stub, args[1])
+	if err != nil {
+		return nil, err
+	}
+	if inst.Status != "Defaulted" {
+		return nil, newError(errInvalidState, "Instrument " + inst.Symbol + " has not defaulted")
+	}
+	if caller != inst.Issuer {
+		return nil, newError(errNotAuthorized, "Only the Issuer can distribute recoveries")
+	}
+	amount, err := strconv.ParseFloat(args[2], 64)
+	if err != nil || amount <= 0 {
+		return nil, newError(errInvalidArgument, "Invalid recovery amount " + args[2])
+	}
+
+	holdings, err := getHoldings(stub, inst)
+	if err != nil {
+		return nil, err
+	}
+	total := 0
+	for _, h := range holdings {
+		total = total + h.Quantity
+	}
+	if total == 0 {
+		return nil, newError(errInvalidState, "No holders to distribute recovery to")
+	}
+	issuer, err := getEntityState(stub, inst.Issuer)
+	if err != nil {
+		return nil, err
+	}
+	// cash held for open orders is not the issuer's to pay out
+	available := issuer.Balance - issuer.HeldBalance
+	if available < amount {
+		return nil, insufficientFunds(inst.Issuer, amount, available)
+	}
+
+	paid := 0.0
+	var transactions []string
+	for i, h := range holdings {
+		share := amount * float64(h.Quantity) / float64(total)
+		if i == len(holdings)-1 {
+			share = amount - paid // last holder takes the rounding difference
+		}
+		paid = paid + share
+		transactionID, err := nextTransactionID(stub)
+		if err != nil {
+			return nil, err
+		}
+		_, err = postJournalEntry(stub, h.EntityID, inst.Issuer, share, "Default Recovery", transactionID)
+		if err != nil {
+			return nil, err
+		}
+		tr := Transaction{
+			TransactionID:   transactionID,
+			TransactionType: "Recovery",
+			FromUser:        inst.Issuer,
+			ToUser:          h.EntityID,
+			Symbol:          inst.Symbol,
+			Quantity:        h.Quantity,
+			InstrumentPrice: share / float64(h.Quantity),
+			Amount:          share,
+			Status:          "Success",
+		}
+		err = recordTransaction(stub, tr)
+		if err != nil {
+			return nil, err
+		}
+		inst.Recoveries = append(inst.Recoveries, Recovery{Holder: h.EntityID, Quantity: h.Quantity, Amount: share, TransactionID: transactionID})
+		transactions = append(transactions, transactionID)
+		emitEvent(stub, MarketEvent{Type: eventRecoveryPaid, Symbol: inst.Symbol, TransactionID: transactionID, Amount: share, Parties: []string{inst.Issuer, h.EntityID}})
+	}
+	err = putInstrumentState(stub, inst)
+	if err != nil {
+		return nil, err
+	}
+	b, err := json.Marshal(transactions)
+	if err != nil {
+		return nil, newError(errLedger, "Error while marshalling recovery transactions")
+	}
+	return b, nil
+}
+
+/*	getDefaultedIssues - RegBody only, every defaulted instrument with its credit events
+		args 0	:	Caller
+*/
+func (t *SimpleChaincode) getDefaultedIssues(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	if len(args) != 1 {
+		return nil, newError(errArgumentCount, "Incorrect number of arguments")
+	}
+	entity, err := getEntityState(stub, args[0])
+	if err != nil {
+		return nil, err
+	}
+	if entity.EntityType != "RegBody" {
+		return nil, newError(errNotAuthorized, "Error only Regulatory Body can access defaulted issues")
+	}
+	defaulted, err := getDefaultedList(stub)
+	if err != nil {
+		return nil, err
+	}
+	type defaultedIssue struct {
+		Instrument   Instrument
+		CreditEvents []CreditEvent
+	}
+	issues := make([]defaultedIssue, len(defaulted))
+	for i, symbol := range defaulted {
+		issues[i].Instrument, err = getInstrumentState(stub, symbol)
+		if err != nil {
+			return nil, err
+		}
+		for _, eventID := range issues[i].Instrument.CreditEvents {
+			b, err := stub.GetState(eventID)
+			if err != nil {
+				return nil, newError(errLedger, "Error while getting credit event " + eventID)
+			}
+			var event CreditEvent
+			err = json.Unmarshal(b, &event)
+			if err != nil {
+				return nil, newError(errLedger, "Error while unmarshalling credit event " + eventID)
+			}
+			issues[i].CreditEvents = append(issues[i].CreditEvents, event)
+		}
+	}
+	b, err := json.Marshal(issues)
+	if err != nil {
+		return nil, newError(errLedger, "Error while marshalling defaulted issues")
+	}
+	return b, nil
+}
+
+/*	getDefaultExposure - RegBody only, each holder's exposure to defaulted issues
+		args 0	:	Caller
+		args 1	:	Symbol (optional, all defaulted issues when omitted)
+*/
+func (t *SimpleChaincode) getDefaultExposure(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	if len(args) < 1 || len(args) > 2 {
+		return nil, newError(errArgumentCount, "Incorrect number of arguments")
+	}
+	entity, err := getEntityState(stub, args[0])
+	if err != nil {
+		return nil, err
+	}
+	if entity.EntityType != "RegBody" {
+		return nil, newError(errNotAuthorized, "Error only Regulatory Body can access default exposure")
+	}
+	symbols, err := getDefaultedList(stub)
+	if err != nil {
+		return nil, err
+	}
+	if len(args) == 2 {
+		symbols = []string{args[1]}
+	}
+
+	var exposures []DefaultExposure
+	for _, symbol := range symbols {
+		inst, err := getInstrumentState(stub, symbol)
+		if err != nil {
+			return nil, err
+		}
+		if inst.Status != "Defaulted" {
+			continue
+		}
+		holdings, err := getHoldings(stub, inst)
+		if err != nil {
+			return nil, err
+		}
+		for _, h := range holdings {
+			e := DefaultExposure{
+				Symbol:   inst.Symbol,
+				Issuer:   inst.Issuer,
+				Holder:   h.EntityID,
+				Quantity: h.Quantity,
+				Exposure: float64(h.Quantity) * inst.InstrumentPrice,
+			}
+			for _, r := range inst.Recoveries {
+				if r.Holder == h.EntityID {
+					e.Recovered = e.Recovered + r.Amount
+				}
+			}
+			e.Net = e.Exposure - e.Recovered
+			exposures = append(exposures, e)
+		}
+	}
+	b, err := json.Marshal(exposures)
+	if err != nil {
+		return nil, newError(errLedger, "Error while marshalling exposures")
+	}
+	return b, nil
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"testing"
+)
+
+func defaultedFixture(t *testing.T) *testStub {
+	s := newTestStub(t, "2017-03-01T10:00:00Z")
+	inst := testInstrument("INST2001")
+	inst.Status = "Defaulted"
+	s.issue(t, inst, map[string]int{entity7: 600, entity8: 400})
+	return s
+}
+
+func TestDistributeRecovery(t *testing.T) {
+	s := defaultedFixture(t)
+	issuer := s.entity(t, entity1).Balance
+	investor1 := s.entity(t, entity7).Balance
+	investor2 := s.entity(t, entity8).Balance
+
+	s.mustInvoke(t, entity1, "distributeRecovery", entity1, "INST2001", "50000")
+	assertBalance(t, s, entity7, investor1+30000)
+	assertBalance(t, s, entity8, investor2+20000)
+	assertBalance(t, s, entity1, issuer-50000)
+	inst := s.instrument(t, "INST2001")
+	if len(inst.Recoveries) != 2 || len(inst.TradeID) != 0 {
+		t.Errorf("recoveries belong to the instrument's recoveries, not its trade chain: %+v", inst)
+	}
+	s.assertJournalBalanced(t)
+}
+
+func TestDistributeRecoveryIssuerOnly(t *testing.T) {
+	s := defaultedFixture(t)
+	_, err := s.invoke(entity7, "distributeRecovery", entity7, "INST2001", "50000")
+	assertCode(t, err, errNotAuthorized)
+}
+
+func TestDistributeRecoveryHeldCash(t *testing.T) {
+	s := defaultedFixture(t)
+	s.hold(t, entity1, 10000)
+	_, err := s.invoke(entity1, "distributeRecovery", entity1, "INST2001", "50000")
+	assertCode(t, err, errInsufficientFunds)
+	if available := err.(*ChaincodeError).Details["available"]; available != "10000.00" {
+		t.Errorf("error should report the balance tested, got %s", available)
+	}
+}
+
+func TestPayCouponOncePerPeriod(t *testing.T) {
+	s := newTestStub(t, "2017-03-01T10:00:00Z")
+	s.issue(t, testInstrument("INST2001"), nil)
+	_, err := s.invoke(entity10, "payCoupon", entity10, "INST2001")
+	assertCode(t, err, errInvalidState)
+	_, err = s.invoke(entity3, "setCouponSchedule", entity3, "INST2001", "2017-03-01", "2017-09-01")
+	assertCode(t, err, errNotAuthorized)
+	s.mustInvoke(t, entity1, "setCouponSchedule", entity1, "INST2001", "2017-03-01", "2017-09-01")
+	owner := s.entity(t, entity3).Balance
+
+	// anyone but the issuer and the Settlement Agent could drain the issuer into default
+	_, err = s.invoke(entity7, "payCoupon", entity7, "INST2001")
+	assertCode(t, err, errNotAuthorized)
+	transactionID := string(s.mustInvoke(t, entity10, "payCoupon", entity10, "INST2001"))
+	assertBalance(t, s, entity3, owner+0.5)
+	_, err = s.invoke(entity1, "payCoupon", entity1, "INST2001")
+	assertCode(t, err, errInvalidState)
+	inst := s.instrument(t, "INST2001")
+	if inst.CouponSchedule[0].Status != "Paid" || inst.CouponSchedule[0].TransactionID != transactionID || inst.CouponSchedule[1].Status != "Scheduled" {
+		t.Errorf("coupon period not settled: %+v", inst.CouponSchedule)
+	}
+	if len(inst.TradeID) != 0 {
+		t.Errorf("coupon added to the trade chain: %v", inst.TradeID)
+	}
+	_, err = s.invoke(entity1, "setCouponSchedule", entity1, "INST2001", "2017-03-01")
+	assertCode(t, err, errInvalidState)
+	s.assertJournalBalanced(t)
+}
+
+func TestMissedCouponDefaults(t *testing.T) {
+	s := newTestStub(t, "2017-03-01T10:00:00Z")
+	s.issue(t, testInstrument("INST2001"), nil)
+	s.mustInvoke(t, entity1, "setCouponSchedule", entity1, "INST2001", "2017-03-01", "2017-09-01")
+	s.hold(t, entity1, 0.25)
+	s.mustInvoke(t, entity1, "payCoupon", entity1, "INST2001")
+	inst := s.instrument(t, "INST2001")
+	if inst.Status != "Defaulted" || inst.CouponSchedule[0].Status != "Missed" {
+		t.Fatalf("missed coupon did not default the issue: %+v", inst)
+	}
+	s.setTime(t, "2017-09-01T10:00:00Z")
+	_, err := s.invoke(entity1, "payCoupon", entity1, "INST2001")
+	assertCode(t, err, errInvalidState)
+}
+
+func TestIssueCalloutRules(t *testing.T) {
+	s := newTestStub(t, "2017-03-01T10:00:00Z")
+	s.issue(t, testInstrument("INST2001"), nil)
+	_, err := s.invoke(entity3, "issueCallout", entity3, "INST2001")
+	assertCode(t, err, errNotAuthorized)
+	s.mustInvoke(t, entity1, "issueCallout", entity1, "INST2001")
+	if s.instrument(t, "INST2001").Status != "Expired" {
+		t.Fatal("call not settled")
+	}
+	_, err = s.invoke(entity1, "issueCallout", entity1, "INST2001")
+	assertCode(t, err, errInvalidState)
+	_, err = s.invoke(entity1, "payCoupon", entity1, "INST2001")
+	assertCode(t, err, errInvalidState)
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"encoding/json"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+// A transaction can set a single chaincode event, so the business events of one invocation are collected by the
+// unit of work and published together once its writes are flushed. Listeners subscribe to eventName and read the
+// Version of the batch before decoding the events; fields are only ever added within a version.
+const eventName = "mktplace"
+const eventVersion = 1
+
+// business event types
+const (
+	eventIoiCreated              = "IoiCreated"
+	eventInstrumentCreated       = "InstrumentCreated"
+	eventInstrumentStatusChanged = "InstrumentStatusChanged" // published to a bank or investor, responded, expired
+	eventResponseReceived        = "ResponseReceived"
+	eventTradeExecuted           = "TradeExecuted" // carries the date the trade settles
+	eventTradeCancelled          = "TradeCancelled"
+	eventCouponPaid              = "CouponPaid"
+	eventCallIssued              = "CallIssued"
+	eventCreditEvent             = "CreditEvent"
+	eventRatingChanged           = "RatingChanged"     // Status is the new rating, PreviousStatus the old one
+	eventPrincipalRedeemed       = "PrincipalRedeemed" // one per holder paid on a scheduled redemption
+	eventPutSettled              = "PutSettled"        // one per exercise, Status is "Settled" or "Failed"
+	eventRecoveryPaid            = "RecoveryPaid"      // one per holder paid out of a recovery
+	eventUnitsRepurchased        = "UnitsRepurchased"  // one per tender into a sinking fund repurchase offer
+	eventCashDeposited           = "CashDeposited"
+	eventCashWithdrawn           = "CashWithdrawn"
+	eventHoldPlaced              = "HoldPlaced"   // TransactionID is the transaction the cash is held for
+	eventHoldReleased            = "HoldReleased" // Status is "Released" or "Expired"
+	eventHoldConsumed            = "HoldConsumed" // TransactionID is the settlement transaction
+)
+
+// MarketEvent is one state change. Parties are the entities it concerns, listeners route notifications on them.
+type MarketEvent struct {
+	Type           string
+	Symbol         string   `json:",omitempty"`
+	IoiID          string   `json:",omitempty"`
+	TradeID        string   `json:",omitempty"`
+	TransactionID  string   `json:",omitempty"`
+	HoldID         string   `json:",omitempty"`
+	Status         string   `json:",omitempty"`
+	PreviousStatus string   `json:",omitempty"`
+	Amount         float64  `json:",omitempty"`
+	SettlementDate string   `json:",omitempty"`
+	Parties        []string `json:",omitempty"`
+}
+
+// EventBatch is the payload of the chaincode event, the events in the order the invocation raised them
+type EventBatch struct {
+	Version   int
+	TxID      string
+	TimeStamp string
+	Events    []MarketEvent
+}
+
+// emitEvent queues the event on the invocation's unit of work. Queries have none and raise no events.
+func emitEvent(stub shim.ChaincodeStubInterface, event MarketEvent) {
+	u, ok := stub.(*unitOfWork)
+	if !ok {
+		return
+	}
+	u.events = append(u.events, event)
+}
+
+// publishEvents sets the chaincode event for the queued events, nothing is set when the invocation raised none
+func (u *unitOfWork) publishEvents() error {
+	if len(u.events) == 0 {
+		return nil
+	}
+	timeStamp, err := txTimeStamp(u)
+	if err != nil {
+		return err
+	}
+	b, err := json.Marshal(EventBatch{Version: eventVersion, TxID: u.GetTxID(), TimeStamp: timeStamp, Events: u.events})
+	if err != nil {
+		return newError(errLedger, "Error while marshalling events")
+	}
+	err = u.ChaincodeStubInterface.SetEvent(eventName, b)
+	if err != nil {
+		return newError(errLedger, "Error while setting chaincode event")
+	}
+	u.events = nil
+	return nil
+}
@@ -0,0 +1,49 @@
+package main
+
+import "testing"
+
+// eventTypes lists the types of the events the last invoke raised
+func (s *testStub) eventTypes() []string {
+	var types []string
+	if len(s.events) == 0 {
+		return types
+	}
+	for _, e := range s.events[len(s.events)-1].Events {
+		types = append(types, e.Type)
+	}
+	return types
+}
+
+func TestCashEvents(t *testing.T) {
+	s := newTestStub(t, "2017-03-01T10:00:00Z")
+	s.mustInvoke(t, entity10, "depositCash", entity10, entity7, "1000", "REF1")
+	batch := s.events[len(s.events)-1]
+	if len(batch.Events) != 1 || batch.Events[0].Type != eventCashDeposited || batch.TxID != s.lastTxID() {
+		t.Fatalf("deposit raised %+v", batch)
+	}
+	s.mustInvoke(t, entity10, "withdrawCash", entity10, entity7, "500", "REF2")
+	if types := s.eventTypes(); len(types) != 1 || types[0] != eventCashWithdrawn {
+		t.Errorf("withdrawal raised %v", types)
+	}
+
+	// a failed invoke publishes nothing
+	raised := len(s.events)
+	s.invoke(entity10, "withdrawCash", entity10, entity7, "1000000000", "REF3")
+	if len(s.events) != raised {
+		t.Errorf("failed withdrawal raised %v", s.eventTypes())
+	}
+}
+
+func TestHoldEvents(t *testing.T) {
+	s := newTestStub(t, "2017-03-01T10:00:00Z")
+	raised := len(s.events)
+	holdID := placeTestHold(t, s, entity7, 1000)
+	if len(s.events) != raised+1 || s.eventTypes()[0] != eventHoldPlaced {
+		t.Fatalf("placing a hold raised %v", s.eventTypes())
+	}
+	s.mustInvoke(t, entity7, "cancelHold", entity7, holdID)
+	batch := s.events[len(s.events)-1]
+	if len(batch.Events) != 1 || batch.Events[0].Type != eventHoldReleased || batch.Events[0].HoldID != holdID {
+		t.Errorf("cancelling the hold raised %+v", batch)
+	}
+}
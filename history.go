@@ -0,0 +1,268 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+// This chaincode API keeps no key history, so the unit of work writes a version record next to every business key
+// it changes. Versions are keyed by the transaction time so a partial key scan returns them oldest first. Keys
+// changed before the history existed start with the first version written after it.
+const indexKeyHistory = "key~history"
+
+// KeyVersion is one write of a key, Value is absent when the key was deleted
+type KeyVersion struct {
+	Key       string
+	TxID      string
+	TimeStamp string
+	Caller    string // enrollment ID of the invoker
+	Function  string
+	Deleted   bool            `json:",omitempty"`
+	Value     json.RawMessage `json:",omitempty"`
+	Changes   []FieldChange
+}
+
+// FieldChange is a top level field that differs from the previous version, the whole value for records that are
+// not JSON objects
+type FieldChange struct {
+	Field string
+	Old   json.RawMessage `json:",omitempty"`
+	New   json.RawMessage `json:",omitempty"`
+}
+
+// tracksHistory is false for indexes and counters, which change with every record and say nothing on their own
+func tracksHistory(key string) bool {
+	return !strings.HasPrefix(key, compositeKeySeparator) && !strings.HasPrefix(key, "current")
+}
+
+// recordHistory adds a version record for every buffered change of a tracked key to the buffer
+func (u *unitOfWork) recordHistory() error {
+	var keys []string
+	for key := range u.writes {
+		keys = append(keys, key)
+	}
+	for key := range u.deletes {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	var now string
+	var nanos int64
+	for _, key := range keys {
+		if !tracksHistory(key) {
+			continue
+		}
+		old, err := u.ChaincodeStubInterface.GetState(key)
+		if err != nil {
+			return newError(errLedger, "Error while getting "+key+" from ledger")
+		}
+		value, written := u.writes[key]
+		if written {
+			// versions keep the value as stored, so sealed values stay sealed in the history
+			opened, err := u.open(key, old)
+			if err != nil {
+				return err
+			}
+			if string(opened) == string(value) {
+				continue
+			}
+			value, err = u.seal(key, value)
+			if err != nil {
+				return err
+			}
+		}
+		if !written && len(old) == 0 {
+			continue
+		}
+		if now == "" {
+			ts, err := txTime(u)
+			if err != nil {
+				return err
+			}
+			now = formatTimeStamp(ts)
+			nanos = ts.UnixNano()
+		}
+		version := KeyVersion{
+			Key:       key,
+			TxID:      u.GetTxID(),
+			TimeStamp: now,
+			Caller:    u.caller,
+			Function:  u.function,
+			Deleted:   !written,
+			Changes:   diffFields(old, value),
+		}
+		if written {
+			version.Value = rawJSON(value)
+		}
+		b, err := json.Marshal(version)
+		if err != nil {
+			return newError(errLedger, "Error while marshalling history of "+key)
+		}
+		err = u.PutState(createCompositeKey(indexKeyHistory, []string{key, fmt.Sprintf("%020d", nanos), version.TxID}), b)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// rawJSON returns the value as JSON, values that are not JSON are quoted as a string
+func rawJSON(b []byte) json.RawMessage {
+	if len(b) == 0 {
+		return nil
+	}
+	var v interface{}
+	if json.Unmarshal(b, &v) == nil {
+		return json.RawMessage(b)
+	}
+	quoted, _ := json.Marshal(string(b))
+	return json.RawMessage(quoted)
+}
+
+// diffFields compares two versions field by field, in field order
+func diffFields(old []byte, value []byte) []FieldChange {
+	var oldFields, newFields map[string]json.RawMessage
+	oldErr := json.Unmarshal(old, &oldFields)
+	newErr := json.Unmarshal(value, &newFields)
+	if (len(old) > 0 && oldErr != nil) || (len(value) > 0 && newErr != nil) {
+		return []FieldChange{{Old: rawJSON(old), New: rawJSON(value)}}
+	}
+	var fields []string
+	for f := range oldFields {
+		fields = append(fields, f)
+	}
+	for f := range newFields {
+		if _, ok := oldFields[f]; !ok {
+			fields = append(fields, f)
+		}
+	}
+	sort.Strings(fields)
+	changes := []FieldChange{}
+	for _, f := range fields {
+		if string(oldFields[f]) == string(newFields[f]) {
+			continue
+		}
+		changes = append(changes, FieldChange{Field: f, Old: oldFields[f], New: newFields[f]})
+	}
+	return changes
+}
+
+// getKeyHistory returns the versions of the key, oldest first
+func getKeyHistory(stub shim.ChaincodeStubInterface, key string) ([]KeyVersion, error) {
+	startKey := createCompositeKey(indexKeyHistory, []string{key})
+	iter, err := stub.RangeQueryState(startKey, startKey+string(utf8.MaxRune))
+	if err != nil {
+		return nil, newError(errLedger, "Error while scanning history of "+key)
+	}
+	defer iter.Close()
+	versions := []KeyVersion{}
+	for iter.HasNext() {
+		_, b, err := iter.Next()
+		if err != nil {
+			return nil, newError(errLedger, "Error while scanning history of "+key)
+		}
+		var version KeyVersion
+		err = json.Unmarshal(b, &version)
+		if err != nil {
+			return nil, newError(errLedger, "Error while unmarshalling history of "+key)
+		}
+		versions = append(versions, version)
+	}
+	return versions, nil
+}
+
+// canSeeKey applies the entitlements of the object stored under the key to its latest value. The Regulatory Body
+// and the Admin see everything, other callers the records they are a party to and their own entity. Keys that are
+// none of these are reference data only they may audit.
+func canSeeKey(stub shim.ChaincodeStubInterface, caller Entity, key string, latest []byte) (bool, error) {
+	if seesAll(caller) {
+		return true, nil
+	}
+	if key == caller.EntityID {
+		return true, nil
+	}
+	if strings.HasPrefix(key, "INST") {
+		var inst Instrument
+		if json.Unmarshal(latest, &inst) != nil {
+			return false, nil
+		}
+		if caller.EntityID == inst.Issuer || caller.EntityID == inst.Owner || caller.EntityID == inst.Bank {
+			return true, nil
+		}
+		symbols, err := getIndexIDs(stub, indexEntityInstrument, caller.EntityID)
+		if err != nil {
+			return false, err
+		}
+		for _, s := range symbols {
+			if s == key {
+				return true, nil
+			}
+		}
+		return false, nil
+	}
+	if strings.HasPrefix(key, "IOI") {
+		var ioi Ioi
+		if json.Unmarshal(latest, &ioi) != nil {
+			return false, nil
+		}
+		return caller.EntityID == ioi.Owner || caller.EntityID == ioi.Bank, nil
+	}
+	if strings.HasPrefix(key, "trans") {
+		var tr Transaction
+		if json.Unmarshal(latest, &tr) != nil {
+			return false, nil
+		}
+		return caller.EntityID == tr.FromUser || caller.EntityID == tr.ToUser, nil
+	}
+	return false, nil
+}
+
+/*	getHistory - Every version of a key with the transaction that wrote it and the fields it changed, sealed values
+				 as stored
+		args 0	:	Caller
+		args 1	:	Key, e.g. an instrument symbol, entity ID, IOI ID or transaction ID
+*/
+func (t *SimpleChaincode) getHistory(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	if len(args) != 2 {
+		return nil, newError(errArgumentCount, "Incorrect number of arguments")
+	}
+	caller, err := getEntityState(stub, args[0])
+	if err != nil {
+		return nil, err
+	}
+	key := args[1]
+	if !tracksHistory(key) {
+		return nil, newError(errInvalidArgument, "Key "+key+" has no history", "key", key)
+	}
+	versions, err := getKeyHistory(stub, key)
+	if err != nil {
+		return nil, err
+	}
+	latest, err := stub.GetState(key)
+	if err != nil {
+		return nil, newError(errLedger, "Error while getting "+key+" from ledger")
+	}
+	// a deleted key is judged on its last written value
+	for i := len(versions) - 1; len(latest) == 0 && i >= 0; i-- {
+		latest = versions[i].Value
+	}
+	if len(latest) == 0 && len(versions) == 0 {
+		return nil, newError(errNotFound, "Key not found", "key", key)
+	}
+	allowed, err := canSeeKey(stub, caller, key, latest)
+	if err != nil {
+		return nil, err
+	}
+	if !allowed {
+		return nil, newError(errNotAuthorized, "Caller is not entitled to the history of "+key)
+	}
+	b, err := json.Marshal(versions)
+	if err != nil {
+		return nil, newError(errLedger, "Error while marshalling history")
+	}
+	return b, nil
+}
@@ -0,0 +1,373 @@
+package main
+
+import (
+	"encoding/json"
+	"strconv"
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+// holds not consumed or released within holdTimeout can be released by releaseExpiredHolds
+const holdTimeout = 48 * time.Hour
+
+// what a hold is placed for, Hold.Reference names the record
+const (
+	holdResponse    = "Issue Response"   // an investor's response, Reference is the response transaction
+	holdRepurchase  = "Repurchase Offer" // an issuer's sinking fund buyback offer, Reference is the symbol
+	holdPutExercise = "Put Exercise"     // a put the issuer owes, Reference is the exercise transaction
+)
+
+// Hold earmarks part of an entity's balance for a pending trade
+type Hold struct {
+	HoldID        string
+	EntityID      string
+	Amount        float64
+	Reason        string // holdResponse, holdRepurchase or holdPutExercise
+	Reference     string // record the cash is held for
+	Status        string // "Active" or "Consumed" or "Released" or "Expired"
+	CreatedAt     string
+	ExpiresAt     string
+	TransactionID string // settlement transaction when consumed
+}
+
+func getHold(stub shim.ChaincodeStubInterface, holdID string) (Hold, error) {
+	var hold Hold
+	b, err := stub.GetState(holdID)
+	if err != nil {
+		return hold, newError(errLedger, "Error while getting hold " + holdID)
+	}
+	err = json.Unmarshal(b, &hold)
+	if err != nil {
+		return hold, newError(errLedger, "Error while unmarshalling hold " + holdID)
+	}
+	return hold, nil
+}
+
+func putHold(stub shim.ChaincodeStubInterface, hold Hold) (error) {
+	b, err := json.Marshal(hold)
+	if err != nil {
+		return newError(errLedger, "Error while marshalling hold")
+	}
+	err = stub.PutState(hold.HoldID, b)
+	if err != nil {
+		return newError(errLedger, "Error while writing hold to ledger")
+	}
+	return nil
+}
+
+// placeHold earmarks amount of the entity's available balance for holdTimeout
+func placeHold(stub shim.ChaincodeStubInterface, entityID string, amount float64, reason string, reference string) (string, error) {
+	now, err := txTime(stub)
+	if err != nil {
+		return "", err
+	}
+	return placeHoldUntil(stub, entityID, amount, reason, reference, now.Add(holdTimeout))
+}
+
+// placeHoldUntil earmarks amount of the entity's available balance until expires
+func placeHoldUntil(stub shim.ChaincodeStubInterface, entityID string, amount float64, reason string, reference string, expires time.Time) (string, error) {
+	if amount <= 0 {
+		return "", newError(errInvalidArgument, "Hold amount should be positive")
+	}
+	entity, err := getEntityState(stub, entityID)
+	if err != nil {
+		return "", err
+	}
+	if entity.Balance-entity.HeldBalance < amount {
+		return "", insufficientFunds(entityID, amount, entity.Balance-entity.HeldBalance)
+	}
+
+	ctidByte, err := stub.GetState("currentHoldNum")
+	if err != nil {
+		return "", newError(errLedger, "Error while getting currentHoldNum from ledger")
+	}
+	num, err := strconv.Atoi(string(ctidByte))
+	if err != nil {
+		return "", newError(errLedger, "Error while converting ctidByte to integer")
+	}
+	num = num + 1
+	now, err := txTime(stub)
+	if err != nil {
+		return "", err
+	}
+	hold := Hold{
+		HoldID:    "HOLD" + strconv.Itoa(num),
+		EntityID:  entityID,
+		Amount:    amount,
+		Reason:    reason,
+		Reference: reference,
+		Status:    "Active",
+		CreatedAt: formatTimeStamp(now),
+		ExpiresAt: formatTimeStamp(expires),
+	}
+	err = putHold(stub, hold)
+	if err != nil {
+		return "", err
+	}
+	err = stub.PutState("currentHoldNum", []byte(strconv.Itoa(num)))
+	if err != nil {
+		return "", newError(errLedger, "Error while writing currentHoldNum to ledger")
+	}
+
+	err = addToIndex(stub, indexEntityHold, entityID, hold.HoldID)
+	if err != nil {
+		return "", err
+	}
+	entity.HeldBalance = entity.HeldBalance + amount
+	err = putEntityState(stub, entity)
+	if err != nil {
+		return "", err
+	}
+	emitEvent(stub, MarketEvent{Type: eventHoldPlaced, HoldID: hold.HoldID, TransactionID: reference, Status: hold.Status, Amount: amount, Parties: []string{entityID}})
+	return hold.HoldID, nil
+}
+
+// releaseHold gives the held cash back to the entity's available balance
+func releaseHold(stub shim.ChaincodeStubInterface, holdID string, status string) (error) {
+	hold, err := getHold(stub, holdID)
+	if err != nil {
+		return err
+	}
+	if hold.Status != "Active" {
+		return newError(errInvalidState, "Hold " + holdID + " is already " + hold.Status)
+	}
+	entity, err := getEntityState(stub, hold.EntityID)
+	if err != nil {
+		return err
+	}
+	entity.HeldBalance = entity.HeldBalance - hold.Amount
+	if entity.HeldBalance < 0 {
+		entity.HeldBalance = 0
+	}
+	err = putEntityState(stub, entity)
+	if err != nil {
+		return err
+	}
+	if status != "Consumed" {
+		emitEvent(stub, MarketEvent{Type: eventHoldReleased, HoldID: holdID, TransactionID: hold.Reference, Status: status, PreviousStatus: hold.Status, Amount: hold.Amount, Parties: []string{hold.EntityID}})
+	}
+	hold.Status = status
+	return putHold(stub, hold)
+}
+
+// consumeHold releases the hold and pays the held amount to the debit account
+func consumeHold(stub shim.ChaincodeStubInterface, holdID string, debitAccount string, reason string, transactionID string) (error) {
+	err := releaseHold(stub, holdID, "Consumed")
+	if err != nil {
+		return err
+	}
+	hold, err := getHold(stub, holdID)
+	if err != nil {
+		return err
+	}
+	_, err = postJournalEntry(stub, debitAccount, hold.EntityID, hold.Amount, reason, transactionID)
+	if err != nil {
+		return err
+	}
+	hold.TransactionID = transactionID
+	emitEvent(stub, MarketEvent{Type: eventHoldConsumed, HoldID: holdID, TransactionID: transactionID, Status: hold.Status, Amount: hold.Amount, Parties: []string{hold.EntityID, debitAccount}})
+	return putHold(stub, hold)
+}
+
+// drawOnHold pays amount out of the held cash to the debit account, the rest stays held. A draw of what is left
+// consumes the hold.
+func drawOnHold(stub shim.ChaincodeStubInterface, holdID string, amount float64, debitAccount string, reason string, transactionID string) (error) {
+	hold, err := getHold(stub, holdID)
+	if err != nil {
+		return err
+	}
+	if hold.Status != "Active" {
+		return newError(errInvalidState, "Hold " + holdID + " is already " + hold.Status)
+	}
+	if amount > hold.Amount + 0.005 {
+		return insufficientFunds(hold.EntityID, amount, hold.Amount)
+	}
+	if amount > hold.Amount - 0.005 {
+		return consumeHold(stub, holdID, debitAccount, reason, transactionID)
+	}
+	entity, err := getEntityState(stub, hold.EntityID)
+	if err != nil {
+		return err
+	}
+	entity.HeldBalance = entity.HeldBalance - amount
+	err = putEntityState(stub, entity)
+	if err != nil {
+		return err
+	}
+	_, err = postJournalEntry(stub, debitAccount, hold.EntityID, amount, reason, transactionID)
+	if err != nil {
+		return err
+	}
+	hold.Amount = hold.Amount - amount
+	emitEvent(stub, MarketEvent{Type: eventHoldConsumed, HoldID: holdID, TransactionID: transactionID, Status: hold.Status, Amount: amount, Parties: []string{hold.EntityID, debitAccount}})
+	return putHold(stub, hold)
+}
+
+// closeHeldFor ends what a released hold was placed for, it is no longer backed by cash: a response can no longer
+// be executed and a repurchase offer is withdrawn
+func closeHeldFor(stub shim.ChaincodeStubInterface, hold Hold, status string) (error) {
+	switch hold.Reason {
+	case holdResponse:
+		b, err := stub.GetState(hold.Reference)
+		if err != nil {
+			return newError(errLedger, "Error while getting " + hold.Reference + " from ledger")
+		}
+		var tr Transaction
+		err = json.Unmarshal(b, &tr)
+		if err != nil {
+			return newError(errLedger, "Error while unmarshalling response " + hold.Reference)
+		}
+		if tr.HoldID != hold.HoldID || tr.Status != "Success" {
+			return nil
+		}
+		tr.Status = status
+		b, err = json.Marshal(tr)
+		if err != nil {
+			return newError(errLedger, "Error while marshalling response " + hold.Reference)
+		}
+		err = stub.PutState(tr.TransactionID, b)
+		if err != nil {
+			return newError(errLedger, "Error while writing response " + hold.Reference + " to ledger")
+		}
+	case holdRepurchase:
+		inst, err := getInstrumentState(stub, hold.Reference)
+		if err != nil {
+			return err
+		}
+		if inst.RepurchaseOffer == nil || inst.RepurchaseOffer.HoldID != hold.HoldID {
+			return nil
+		}
+		inst.RepurchaseOffer = nil
+		return putInstrumentState(stub, inst)
+	}
+	return nil
+}
+
+/*	cancelHold - withdraws a response or a repurchase offer before it is taken up
+		args 0	:	Caller (entity the cash is held for)
+		args 1	:	Hold ID
+*/
+func (t *SimpleChaincode) cancelHold(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	if len(args) != 2 {
+		return nil, newError(errArgumentCount, "Incorrect number of arguments")
+	}
+	hold, err := getHold(stub, args[1])
+	if err != nil {
+		return nil, err
+	}
+	if hold.EntityID != args[0] {
+		return nil, newError(errNotAuthorized, "Only " + hold.EntityID + " can cancel hold " + hold.HoldID)
+	}
+	if hold.Reason == holdPutExercise {
+		return nil, newError(errInvalidState, "Hold " + hold.HoldID + " backs a put the issuer owes and cannot be cancelled")
+	}
+	err = releaseHold(stub, hold.HoldID, "Released")
+	if err != nil {
+		return nil, err
+	}
+	err = closeHeldFor(stub, hold, "Cancelled")
+	if err != nil {
+		return nil, err
+	}
+	return nil, nil
+}
+
+/*	releaseExpiredHolds - releases every active hold past its expiry
+		args 0	:	Caller
+*/
+func (t *SimpleChaincode) releaseExpiredHolds(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	if len(args) != 1 {
+		return nil, newError(errArgumentCount, "Incorrect number of arguments")
+	}
+	var allEntities []string
+	listByte, err := stub.GetState("entityList")
+	if err != nil {
+		return nil, newError(errLedger, "Error while getting entity list from ledger")
+	}
+	err = json.Unmarshal(listByte, &allEntities)
+	if err != nil {
+		return nil, newError(errLedger, "Error while unmarshalling entity list")
+	}
+	now, err := txTime(stub)
+	if err != nil {
+		return nil, err
+	}
+	var released []string
+	for _, id := range allEntities {
+		holdIDs, err := getIndexIDs(stub, indexEntityHold, id)
+		if err != nil {
+			return nil, err
+		}
+		for _, holdID := range holdIDs {
+			hold, err := getHold(stub, holdID)
+			if err != nil {
+				return nil, err
+			}
+			if hold.Status != "Active" {
+				continue
+			}
+			expires, err := parseTimeStamp(hold.ExpiresAt)
+			if err != nil {
+				return nil, newError(errLedger, "Invalid expiry on hold " + holdID)
+			}
+			if expires.After(now) {
+				continue
+			}
+			err = releaseHold(stub, holdID, "Expired")
+			if err != nil {
+				return nil, err
+			}
+			err = closeHeldFor(stub, hold, "Expired")
+			if err != nil {
+				return nil, err
+			}
+			released = append(released, holdID)
+		}
+	}
+	b, err := json.Marshal(released)
+	if err != nil {
+		return nil, newError(errLedger, "Error while marshalling released holds")
+	}
+	return b, nil
+}
+
+/*	getHolds
+		args 0	:	Caller (the entity itself, SettlementAgent or RegBody)
+		args 1	:	Entity ID (optional, the caller when omitted)
+*/
+func (t *SimpleChaincode) getHolds(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	if len(args) < 1 || len(args) > 2 {
+		return nil, newError(errArgumentCount, "Incorrect number of arguments")
+	}
+	caller, err := getEntityState(stub, args[0])
+	if err != nil {
+		return nil, err
+	}
+	entity := caller
+	if len(args) == 2 && args[1] != "" && args[1] != caller.EntityID {
+		if caller.EntityType != "SettlementAgent" && caller.EntityType != "RegBody" {
+			return nil, newError(errNotAuthorized, "Not authorised to read the holds of " + args[1])
+		}
+		entity, err = getEntityState(stub, args[1])
+		if err != nil {
+			return nil, err
+		}
+	}
+	holdIDs, err := getIndexIDs(stub, indexEntityHold, entity.EntityID)
+	if err != nil {
+		return nil, err
+	}
+	holds := make([]Hold, len(holdIDs))
+	for i, holdID := range holdIDs {
+		holds[i], err = getHold(stub, holdID)
+		if err != nil {
+			return nil, err
+		}
+	}
+	b, err := json.Marshal(holds)
+	if err != nil {
+		return nil, newError(errLedger, "Error while marshalling holds")
+	}
+	return b, nil
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+// placeTestHold holds amount of the entity's cash for trans0 and returns the hold ID
+func placeTestHold(t *testing.T, s *testStub, entityID string, amount float64) string {
+	var holdID string
+	s.setup(t, func(u *unitOfWork) error {
+		var err error
+		holdID, err = placeHold(u, entityID, amount, "Test", "trans0")
+		return err
+	})
+	return holdID
+}
+
+func TestPlaceHold(t *testing.T) {
+	s := newTestStub(t, "2017-03-01T10:00:00Z")
+	balance := s.entity(t, entity7).Balance
+	placeTestHold(t, s, entity7, 1000)
+	entity := s.entity(t, entity7)
+	if entity.Balance != balance || entity.HeldBalance != 1000 || entity.AvailableBalance != balance-1000 {
+		t.Errorf("hold should earmark cash without moving it: %+v", entity)
+	}
+	s.setup(t, func(u *unitOfWork) error {
+		_, err := placeHold(u, entity7, balance, "Test", "trans0")
+		assertCode(t, err, errInsufficientFunds)
+		return nil
+	})
+}
+
+func TestCancelHold(t *testing.T) {
+	s := newTestStub(t, "2017-03-01T10:00:00Z")
+	holdID := placeTestHold(t, s, entity7, 1000)
+	_, err := s.invoke(entity8, "cancelHold", entity8, holdID)
+	assertCode(t, err, errNotAuthorized)
+	s.mustInvoke(t, entity7, "cancelHold", entity7, holdID)
+	if s.entity(t, entity7).HeldBalance != 0 {
+		t.Error("cancelled hold still earmarks cash")
+	}
+	hold, _ := getHold(newUnitOfWork(s), holdID)
+	if hold.Status != "Released" {
+		t.Errorf("hold is %s, expected Released", hold.Status)
+	}
+	_, err = s.invoke(entity7, "cancelHold", entity7, holdID)
+	assertCode(t, err, errInvalidState)
+}
+
+func TestConsumeHold(t *testing.T) {
+	s := newTestStub(t, "2017-03-01T10:00:00Z")
+	payer := s.entity(t, entity7).Balance
+	payee := s.entity(t, entity1).Balance
+	holdID := placeTestHold(t, s, entity7, 1000)
+	s.setup(t, func(u *unitOfWork) error {
+		return consumeHold(u, holdID, entity1, "Trade Settlement", "trans1")
+	})
+	assertBalance(t, s, entity7, payer-1000)
+	assertBalance(t, s, entity1, payee+1000)
+	if s.entity(t, entity7).HeldBalance != 0 {
+		t.Error("consumed hold still earmarks cash")
+	}
+	hold, _ := getHold(newUnitOfWork(s), holdID)
+	if hold.Status != "Consumed" || hold.TransactionID != "trans1" {
+		t.Errorf("hold not consumed: %+v", hold)
+	}
+	s.assertJournalBalanced(t)
+}
+
+func TestReleaseExpiredHolds(t *testing.T) {
+	s := newTestStub(t, "2017-03-01T10:00:00Z")
+	holdID := placeTestHold(t, s, entity7, 1000)
+	s.setTime(t, "2017-03-02T10:00:00Z")
+	b := s.mustInvoke(t, entity10, "releaseExpiredHolds", entity10)
+	if string(b) != "null" && string(b) != "[]" {
+		t.Fatalf("released a hold before it expired: %s", b)
+	}
+	s.setTime(t, "2017-03-03T10:00:01Z")
+	b = s.mustInvoke(t, entity10, "releaseExpiredHolds", entity10)
+	var released []string
+	json.Unmarshal(b, &released)
+	if len(released) != 1 || released[0] != holdID || s.entity(t, entity7).HeldBalance != 0 {
+		t.Errorf("expired hold not released: %s", b)
+	}
+}
+
+func TestGetHoldsEntitlement(t *testing.T) {
+	s := newTestStub(t, "2017-03-01T10:00:00Z")
+	placeTestHold(t, s, entity7, 1000)
+	_, err := s.query("getHolds", entity8, entity7)
+	assertCode(t, err, errNotAuthorized)
+	for _, caller := range []string{entity7, entity9, entity10} {
+		b, err := s.query("getHolds", caller, entity7)
+		if err != nil {
+			t.Fatalf("%s cannot read the holds of %s: %s", caller, entity7, err)
+		}
+		var holds []Hold
+		json.Unmarshal(b, &holds)
+		if len(holds) != 1 {
+			t.Errorf("expected one hold, got %s", b)
+		}
+	}
+}
+
+// placeTestResponse records a response of entity7 to trade trans1 with its cash held, and returns the hold ID
+func placeTestResponse(t *testing.T, s *testStub) string {
+	var holdID string
+	s.setup(t, func(u *unitOfWork) error {
+		var err error
+		holdID, err = placeHold(u, entity7, 1000, holdResponse, "trans2")
+		if err != nil {
+			return err
+		}
+		return recordTransaction(u, Transaction{TransactionID: "trans2", TradeID: "trans1", TransactionType: "Response", FromUser: entity7, ToUser: entity3, Symbol: "INST2001", Quantity: 10, Status: "Success", HoldID: holdID})
+	})
+	return holdID
+}
+
+func TestCancelledResponseCannotBeExecuted(t *testing.T) {
+	s := newTestStub(t, "2017-03-01T10:00:00Z")
+	s.issue(t, testInstrument("INST2001"), nil)
+	holdID := placeTestResponse(t, s)
+	s.mustInvoke(t, entity7, "cancelHold", entity7, holdID)
+	var tr Transaction
+	b, _ := newUnitOfWork(s).GetState("trans2")
+	json.Unmarshal(b, &tr)
+	if tr.Status != "Cancelled" {
+		t.Fatalf("response is %s after its hold was cancelled", tr.Status)
+	}
+	_, err := s.invoke(entity3, "tradeExec", entity3, "trans1", "trans2", "yes")
+	assertCode(t, err, errInvalidState)
+}
+
+func TestExpiredResponseCannotBeExecuted(t *testing.T) {
+	s := newTestStub(t, "2017-03-01T10:00:00Z")
+	s.issue(t, testInstrument("INST2001"), nil)
+	placeTestResponse(t, s)
+	s.setTime(t, "2017-03-03T10:00:01Z")
+	s.mustInvoke(t, entity10, "releaseExpiredHolds", entity10)
+	_, err := s.invoke(entity3, "tradeExec", entity3, "trans1", "trans2", "yes")
+	assertCode(t, err, errInvalidState)
+}
+
+func TestRepurchaseOfferIsHeld(t *testing.T) {
+	s := newTestStub(t, "2017-02-01T10:00:00Z")
+	s.issue(t, testInstrument("INST2001"), map[string]int{entity7: 600, entity8: 400})
+	s.mustInvoke(t, entity1, "setRedemptionSchedule", entity1, "INST2001", "SinkingFund", "ProRata", "2017-03-01", "300")
+	s.mustInvoke(t, entity1, "offerRepurchase", entity1, "INST2001", "95", "200")
+	if s.entity(t, entity1).HeldBalance != 19000 {
+		t.Fatalf("offer holds %.2f, expected 19000", s.entity(t, entity1).HeldBalance)
+	}
+	s.mustInvoke(t, entity7, "tenderUnits", entity7, "INST2001", "150")
+	if s.entity(t, entity1).HeldBalance != 4750 {
+		t.Errorf("tender not drawn from the hold, %.2f held", s.entity(t, entity1).HeldBalance)
+	}
+
+	// cancelling the hold withdraws the offer
+	holdID := s.instrument(t, "INST2001").RepurchaseOffer.HoldID
+	s.mustInvoke(t, entity1, "cancelHold", entity1, holdID)
+	if s.instrument(t, "INST2001").RepurchaseOffer != nil || s.entity(t, entity1).HeldBalance != 0 {
+		t.Error("offer still open after its hold was cancelled")
+	}
+	_, err := s.invoke(entity8, "tenderUnits", entity8, "INST2001", "10")
+	assertCode(t, err, errInvalidState)
+
+	// an offer the issuer cannot fund is not made
+	s.hold(t, entity1, 1000)
+	_, err = s.invoke(entity1, "offerRepurchase", entity1, "INST2001", "95", "200")
+	assertCode(t, err, errInsufficientFunds)
+	s.assertJournalBalanced(t)
+}
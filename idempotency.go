@@ -0,0 +1,163 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"strings"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+// Any invocation may end with an argument idempotencyKey=<key>. The key is removed before the function sees its
+// arguments. The first successful invocation with a key stores its result for the certified caller making it, a retry
+// with the same key and arguments returns that result without running the function again. Failed invocations store nothing,
+// so they can be retried with the same key.
+const idempotencyKeyArg = "idempotencyKey="
+
+const indexEntityIdempotency = "entity~idempotency"
+
+// IdempotencyRecord is the stored outcome of the first invocation made with a key
+type IdempotencyRecord struct {
+	Key         string
+	Entity      string
+	Function    string
+	Fingerprint string // hash of the function and its arguments, a key cannot be reused for another request
+	RequestID   string // transaction that ran the function
+	Result      string
+	TimeStamp   string
+}
+
+// splitIdempotencyKey removes the key argument, when given, from the end of the arguments
+func splitIdempotencyKey(args []string) ([]string, string) {
+	if len(args) == 0 || !strings.HasPrefix(args[len(args)-1], idempotencyKeyArg) {
+		return args, ""
+	}
+	return args[:len(args)-1], strings.TrimPrefix(args[len(args)-1], idempotencyKeyArg)
+}
+
+func requestFingerprint(function string, args []string) string {
+	sum := sha256.Sum256([]byte(function + compositeKeySeparator + strings.Join(args, compositeKeySeparator)))
+	return hex.EncodeToString(sum[:])
+}
+
+func getIdempotencyRecord(stub shim.ChaincodeStubInterface, entityID string, key string) (IdempotencyRecord, bool, error) {
+	var record IdempotencyRecord
+	b, err := stub.GetState(createCompositeKey(indexEntityIdempotency, []string{entityID, key}))
+	if err != nil {
+		return record, false, newError(errLedger, "Error while getting idempotency key from ledger")
+	}
+	if len(b) == 0 {
+		return record, false, nil
+	}
+	err = json.Unmarshal(b, &record)
+	if err != nil {
+		return record, false, newError(errLedger, "Error while unmarshalling idempotency key")
+	}
+	return record, true, nil
+}
+
+func putIdempotencyRecord(stub shim.ChaincodeStubInterface, record IdempotencyRecord) error {
+	b, err := json.Marshal(record)
+	if err != nil {
+		return newError(errLedger, "Error while marshalling idempotency key")
+	}
+	err = stub.PutState(createCompositeKey(indexEntityIdempotency, []string{record.Entity, record.Key}), b)
+	if err != nil {
+		return newError(errLedger, "Error while writing idempotency key to ledger")
+	}
+	return nil
+}
+
+//==============================================================================================================================
+//	 invokeOnce - Runs the function unless the idempotency key was already used for the same request, in which case
+//				  the stored result is returned. Without a key the function simply runs.
+//==============================================================================================================================
+func (t *SimpleChaincode) invokeOnce(u *unitOfWork, function string, args []string, key string) ([]byte, error) {
+	if key == "" {
+		return t.invokeFunction(u, function, args)
+	}
+	// keys belong to the enrollment ID of the certificate, not to an entity named in the arguments
+	entityID := u.caller
+	if entityID == "" {
+		return nil, newError(errNotAuthorized, "Idempotency keys need a caller certificate", "key", key)
+	}
+	fingerprint := requestFingerprint(function, args)
+	record, found, err := getIdempotencyRecord(u, entityID, key)
+	if err != nil {
+		return nil, err
+	}
+	if found {
+		if record.Fingerprint != fingerprint {
+			return nil, newError(errAlreadyExists, "Idempotency key "+key+" was used for another request", "key", key, "requestID", record.RequestID)
+		}
+		return []byte(record.Result), nil
+	}
+	result, err := t.invokeFunction(u, function, args)
+	if err != nil {
+		return nil, err
+	}
+	timeStamp, err := txTimeStamp(u)
+	if err != nil {
+		return nil, err
+	}
+	err = putIdempotencyRecord(u, IdempotencyRecord{
+		Key:         key,
+		Entity:      entityID,
+		Function:    function,
+		Fingerprint: fingerprint,
+		RequestID:   u.GetTxID(),
+		Result:      string(result),
+		TimeStamp:   timeStamp,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result, nil
+}
+
+/*	getIdempotencyKeys - The keys an entity has used and the outcome stored for each
+		args 0	:	Caller
+		args 1	:	Entity (optional, the caller when omitted; others only for the Regulatory Body and the Admin)
+		args 2	:	List options (JSON, optional)
+*/
+func (t *SimpleChaincode) getIdempotencyKeys(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	if len(args) < 1 || len(args) > 3 {
+		return nil, newError(errArgumentCount, "Incorrect number of arguments")
+	}
+	caller, err := getEntityState(stub, args[0])
+	if err != nil {
+		return nil, err
+	}
+	entityID := caller.EntityID
+	if len(args) > 1 && args[1] != "" {
+		entityID = args[1]
+	}
+	if entityID != caller.EntityID && !seesAll(caller) {
+		return nil, newError(errNotAuthorized, "Only the Regulatory Body and the Admin can see the keys of another entity")
+	}
+	opts, err := parseListOptions(args, 2)
+	if err != nil {
+		return nil, err
+	}
+	keys, err := getIndexIDs(stub, indexEntityIdempotency, entityID)
+	if err != nil {
+		return nil, err
+	}
+	records := []IdempotencyRecord{}
+	bookmark, err := collectPage(keys, opts, func(key string) (bool, error) {
+		record, found, err := getIdempotencyRecord(stub, entityID, key)
+		if err != nil || !found {
+			return false, err
+		}
+		if !opts.period.containsTimeStamp(record.TimeStamp) {
+			return false, nil
+		}
+		records = append(records, record)
+		return true, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return listResponse(records, len(records), bookmark, opts)
+}
@@ -0,0 +1,35 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestIdempotentReplay(t *testing.T) {
+	s := newTestStub(t, "2017-03-01T10:00:00Z")
+	balance := s.entity(t, entity7).Balance
+	first := s.mustInvoke(t, entity10, "depositCash", entity10, entity7, "1000", "REF1", "idempotencyKey=k1")
+	again := s.mustInvoke(t, entity10, "depositCash", entity10, entity7, "1000", "REF1", "idempotencyKey=k1")
+	if string(first) != string(again) {
+		t.Errorf("replay returned %s, the first request returned %s", again, first)
+	}
+	assertBalance(t, s, entity7, balance+1000)
+
+	_, err := s.invoke(entity10, "depositCash", entity10, entity7, "2000", "REF2", "idempotencyKey=k1")
+	assertCode(t, err, errAlreadyExists)
+	s.assertJournalBalanced(t)
+}
+
+func TestIdempotencyKeysBelongToTheCaller(t *testing.T) {
+	s := newTestStub(t, "2017-03-01T10:00:00Z")
+	// the key is kept for the invoker's certificate, not for the caller named in the arguments
+	s.mustInvoke(t, entity7, "depositCash", entity10, entity7, "1000", "REF1", "idempotencyKey=k1")
+	u := newUnitOfWork(s)
+	if _, found, _ := getIdempotencyRecord(u, entity7, "k1"); !found {
+		t.Error("key not kept for the invoker")
+	}
+	if _, found, _ := getIdempotencyRecord(u, entity10, "k1"); found {
+		t.Error("key kept for the entity named in the arguments")
+	}
+	// the same key is free for another invoker
+	s.mustInvoke(t, entity10, "depositCash", entity10, entity8, "1000", "REF2", "idempotencyKey=k1")
+}
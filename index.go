@@ -0,0 +1,170 @@
+package main
+
+import (
+	"encoding/json"
+	"sort"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+// Relationships between an entity and its records are stored as one key per pair instead of lists inside the
+// entity document, so adding a trade does not rewrite the entity. This chaincode API has no composite keys, the
+// keys below follow the same layout so they can be moved to CreateCompositeKey unchanged.
+const (
+	indexEntityTrade      = "entity~trade"
+	indexEntityInstrument = "entity~instrument"
+	indexEntityIoi        = "entity~ioi"
+	indexEntityCash       = "entity~cash"
+	indexEntityJournal    = "entity~journal"
+	indexEntityHold       = "entity~hold"
+)
+
+const compositeKeySeparator = "\x00"
+
+// index entries carry no data, the key is the record
+var indexValue = []byte{0x00}
+
+func createCompositeKey(objectType string, attributes []string) string {
+	key := compositeKeySeparator + objectType + compositeKeySeparator
+	for _, a := range attributes {
+		key = key + a + compositeKeySeparator
+	}
+	return key
+}
+
+func splitCompositeKey(key string) (string, []string) {
+	parts := strings.Split(strings.Trim(key, compositeKeySeparator), compositeKeySeparator)
+	return parts[0], parts[1:]
+}
+
+// addToIndex links id to the entity, adding an existing link again is harmless
+func addToIndex(stub shim.ChaincodeStubInterface, index string, entityID string, id string) error {
+	err := stub.PutState(createCompositeKey(index, []string{entityID, id}), indexValue)
+	if err != nil {
+		return newError(errLedger, "Error while writing "+index+" index to ledger")
+	}
+	return nil
+}
+
+// getIndexIDs returns the ids linked to the entity with a partial key range scan, oldest first
+func getIndexIDs(stub shim.ChaincodeStubInterface, index string, entityID string) ([]string, error) {
+	startKey := createCompositeKey(index, []string{entityID})
+	iter, err := stub.RangeQueryState(startKey, startKey+string(utf8.MaxRune))
+	if err != nil {
+		return nil, newError(errLedger, "Error while scanning "+index+" index")
+	}
+	defer iter.Close()
+	var ids []string
+	for iter.HasNext() {
+		key, _, err := iter.Next()
+		if err != nil {
+			return nil, newError(errLedger, "Error while scanning "+index+" index")
+		}
+		_, attributes := splitCompositeKey(key)
+		if len(attributes) == 2 {
+			ids = append(ids, attributes[1])
+		}
+	}
+	sort.Sort(byCounter(ids))
+	return ids, nil
+}
+
+// byCounter orders ids made of a prefix and a counter, as strings trans10000 would sort before trans9999
+type byCounter []string
+
+func (s byCounter) Len() int      { return len(s) }
+func (s byCounter) Swap(i, j int) { s[i], s[j] = s[j], s[i] }
+func (s byCounter) Less(i, j int) bool {
+	if len(s[i]) != len(s[j]) {
+		return len(s[i]) < len(s[j])
+	}
+	return s[i] < s[j]
+}
+
+type entityList struct {
+	index string
+	ids   *[]string
+}
+
+// entityLists pairs each index with the list it replaces in the entity record
+func entityLists(entity *Entity) []entityList {
+	return []entityList{
+		{indexEntityTrade, &entity.TradeHistory},
+		{indexEntityInstrument, &entity.Instruments},
+		{indexEntityIoi, &entity.IoiList},
+		{indexEntityCash, &entity.CashLedger},
+		{indexEntityJournal, &entity.Journal},
+		{indexEntityHold, &entity.Holds},
+	}
+}
+
+// withIndexes fills in the entity's lists from the indexes, for responses that return the whole entity
+func withIndexes(stub shim.ChaincodeStubInterface, entity Entity) (Entity, error) {
+	var err error
+	for _, l := range entityLists(&entity) {
+		*l.ids, err = getIndexIDs(stub, l.index, entity.EntityID)
+		if err != nil {
+			return entity, err
+		}
+	}
+	return entity, nil
+}
+
+/*	upgradeIndexes - Moves the lists kept inside entity records before the indexes existed into index keys and
+					 empties them. Entities already moved have empty lists, so running it twice is harmless.
+		args 0	:	Caller (Admin)
+*/
+func (t *SimpleChaincode) upgradeIndexes(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	if len(args) != 1 {
+		return nil, newError(errArgumentCount, "Incorrect number of arguments")
+	}
+	caller, err := getEntityState(stub, args[0])
+	if err != nil {
+		return nil, err
+	}
+	if caller.EntityType != "Admin" {
+		return nil, newError(errNotAuthorized, "Only the Admin can upgrade the ledger")
+	}
+	var allEntities []string
+	listByte, err := stub.GetState("entityList")
+	if err != nil {
+		return nil, newError(errLedger, "Error while getting entity list from ledger")
+	}
+	err = json.Unmarshal(listByte, &allEntities)
+	if err != nil {
+		return nil, newError(errLedger, "Error while unmarshalling entity list")
+	}
+	var upgraded []string
+	for _, id := range allEntities {
+		entity, err := getEntityState(stub, id)
+		if err != nil {
+			return nil, err
+		}
+		moved := false
+		for _, l := range entityLists(&entity) {
+			for _, recordID := range *l.ids {
+				err = addToIndex(stub, l.index, id, recordID)
+				if err != nil {
+					return nil, err
+				}
+				moved = true
+			}
+			*l.ids = nil
+		}
+		if !moved {
+			continue
+		}
+		err = putEntityState(stub, entity)
+		if err != nil {
+			return nil, err
+		}
+		upgraded = append(upgraded, id)
+	}
+	b, err := json.Marshal(upgraded)
+	if err != nil {
+		return nil, newError(errLedger, "Error while marshalling upgraded entities")
+	}
+	return b, nil
+}
@@ -0,0 +1,292 @@
+package main
+
+import (
+	"encoding/json"
+	"strconv"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+// accounts outside the marketplace, every other account is an entity's cash account
+const accountPaymentRail = "PaymentRail"       // funds held at the payment bank
+const accountOpeningBalance = "OpeningBalance" // balances seeded by Init
+
+// JournalEntry moves Amount from CreditAccount to DebitAccount. The debit side is the cash account whose
+// balance goes up, the credit side the one whose balance goes down.
+type JournalEntry struct {
+	EntryID       string
+	TransactionID string
+	DebitAccount  string
+	CreditAccount string
+	Amount        float64
+	Reason        string
+	TimeStamp     string
+}
+
+// Statement is an entity's journal for a date range
+type Statement struct {
+	EntityID       string
+	From           string
+	To             string
+	OpeningBalance float64
+	ClosingBalance float64
+	LedgerBalance  float64 // Entity.Balance as stored, equals the journal balance when in balance
+	Entries        []JournalEntry
+}
+
+func isEntityAccount(account string) bool {
+	return account != accountPaymentRail && account != accountOpeningBalance
+}
+
+//==============================================================================================================================
+//	 postJournalEntry - The only place an entity balance changes. Pays amount from the credit account to the debit
+//						account, fails when the paying entity's available balance would go negative, and stores the
+//						balanced entry.
+//==============================================================================================================================
+func postJournalEntry(stub shim.ChaincodeStubInterface, debitAccount string, creditAccount string, amount float64, reason string, transactionID string) (string, error) {
+	if amount <= 0 {
+		return "", newError(errInvalidArgument, "Journal amount should be positive")
+	}
+	if debitAccount == creditAccount {
+		return "", newError(errLedger, "Journal debit and credit account are the same :" + debitAccount)
+	}
+	if isEntityAccount(creditAccount) {
+		entity, err := getEntityState(stub, creditAccount)
+		if err != nil {
+			return "", err
+		}
+		entity.Balance = entity.Balance - amount
+		if entity.Balance < entity.HeldBalance {
+			// cash held for pending trades cannot be spent elsewhere
+			return "", insufficientFunds(creditAccount, amount, entity.Balance+amount-entity.HeldBalance)
+		}
+		err = putEntityState(stub, entity)
+		if err != nil {
+			return "", err
+		}
+	}
+	if isEntityAccount(debitAccount) {
+		entity, err := getEntityState(stub, debitAccount)
+		if err != nil {
+			return "", err
+		}
+		entity.Balance = entity.Balance + amount
+		err = putEntityState(stub, entity)
+		if err != nil {
+			return "", err
+		}
+	}
+	return writeJournalEntry(stub, JournalEntry{
+		TransactionID: transactionID,
+		DebitAccount:  debitAccount,
+		CreditAccount: creditAccount,
+		Amount:        amount,
+		Reason:        reason,
+	})
+}
+
+// writeJournalEntry stamps and stores the entry and links it to the entity accounts on both sides. Balances are not touched.
+func writeJournalEntry(stub shim.ChaincodeStubInterface, entry JournalEntry) (string, error) {
+	var err error
+	if entry.TimeStamp == "" {
+		entry.TimeStamp, err = txTimeStamp(stub)
+		if err != nil {
+			return "", err
+		}
+	}
+	ctidByte, err := stub.GetState("currentJournalNum")
+	if err != nil {
+		return "", newError(errLedger, "Error while getting currentJournalNum from ledger")
+	}
+	num, err := strconv.Atoi(string(ctidByte))
+	if err != nil {
+		return "", newError(errLedger, "Error while converting ctidByte to integer")
+	}
+	num = num + 1
+	entry.EntryID = "JE" + strconv.Itoa(num)
+	b, err := json.Marshal(entry)
+	if err != nil {
+		return "", newError(errLedger, "Error while marshalling journal entry")
+	}
+	err = stub.PutState(entry.EntryID, b)
+	if err != nil {
+		return "", newError(errLedger, "Error while writing journal entry to ledger")
+	}
+	err = stub.PutState("currentJournalNum", []byte(strconv.Itoa(num)))
+	if err != nil {
+		return "", newError(errLedger, "Error while writing currentJournalNum to ledger")
+	}
+	for _, account := range []string{entry.DebitAccount, entry.CreditAccount} {
+		if !isEntityAccount(account) {
+			continue
+		}
+		err = addToIndex(stub, indexEntityJournal, account, entry.EntryID)
+		if err != nil {
+			return "", err
+		}
+	}
+	return entry.EntryID, nil
+}
+
+// postOpeningBalance journals the part of the entity's balance the journal does not account for yet: the balance
+// it was created with, nothing when Init runs again, the whole balance on a ledger kept before the journal
+func postOpeningBalance(stub shim.ChaincodeStubInterface, entityID string) (error) {
+	entity, err := getEntityState(stub, entityID)
+	if err != nil {
+		return err
+	}
+	journaled, err := journalBalance(stub, entity)
+	if err != nil {
+		return err
+	}
+	entry := JournalEntry{
+		TransactionID: "Init",
+		DebitAccount:  entityID,
+		CreditAccount: accountOpeningBalance,
+		Amount:        entity.Balance - journaled,
+		Reason:        "Opening Balance",
+	}
+	if entry.Amount == 0 {
+		return nil
+	}
+	if entry.Amount < 0 {
+		entry.DebitAccount, entry.CreditAccount = accountOpeningBalance, entityID
+		entry.Amount = -1 * entry.Amount
+	}
+	_, err = writeJournalEntry(stub, entry)
+	return err
+}
+
+func getJournalEntries(stub shim.ChaincodeStubInterface, entity Entity) ([]JournalEntry, error) {
+	entryIDs, err := getIndexIDs(stub, indexEntityJournal, entity.EntityID)
+	if err != nil {
+		return nil, err
+	}
+	entries := make([]JournalEntry, len(entryIDs))
+	for i, entryID := range entryIDs {
+		b, err := stub.GetState(entryID)
+		if err != nil {
+			return nil, newError(errLedger, "Error while getting journal entry " + entryID)
+		}
+		err = json.Unmarshal(b, &entries[i])
+		if err != nil {
+			return nil, newError(errLedger, "Error while unmarshalling journal entry " + entryID)
+		}
+	}
+	return entries, nil
+}
+
+// signedAmount is the effect of the entry on the entity's balance
+func signedAmount(entry JournalEntry, entityID string) float64 {
+	if entry.DebitAccount == entityID {
+		return entry.Amount
+	}
+	if entry.CreditAccount == entityID {
+		return -1 * entry.Amount
+	}
+	return 0
+}
+
+// journalBalance is the entity's balance derived from its journal entries
+func journalBalance(stub shim.ChaincodeStubInterface, entity Entity) (float64, error) {
+	entries, err := getJournalEntries(stub, entity)
+	if err != nil {
+		return 0, err
+	}
+	derived := 0.0
+	for _, entry := range entries {
+		derived = derived + signedAmount(entry, entity.EntityID)
+	}
+	return derived, nil
+}
+
+/*	getStatement
+		args 0	:	Caller (the entity itself, SettlementAgent or RegBody)
+		args 1	:	Entity ID
+		args 2	:	From date (YYYY-MM-DD)
+		args 3	:	To date (YYYY-MM-DD), inclusive
+*/
+func (t *SimpleChaincode) getStatement(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	if len(args) != 4 {
+		return nil, newError(errArgumentCount, "Incorrect number of arguments")
+	}
+	caller, err := getEntityState(stub, args[0])
+	if err != nil {
+		return nil, err
+	}
+	if caller.EntityID != args[1] && caller.EntityType != "SettlementAgent" && caller.EntityType != "RegBody" {
+		return nil, newError(errNotAuthorized, "Not authorised to read the statement of " + args[1])
+	}
+	period, err := parseDateRange(args, 2)
+	if err != nil {
+		return nil, err
+	}
+
+	entity, err := getEntityState(stub, args[1])
+	if err != nil {
+		return nil, err
+	}
+	entries, err := getJournalEntries(stub, entity)
+	if err != nil {
+		return nil, err
+	}
+	statement := Statement{EntityID: entity.EntityID, From: formatDate(period.From), To: formatDate(period.To), LedgerBalance: entity.Balance}
+	for _, entry := range entries {
+		posted, err := parseTimeStamp(entry.TimeStamp)
+		if err != nil {
+			return nil, newError(errLedger, "Invalid journal timestamp on " + entry.EntryID)
+		}
+		amount := signedAmount(entry, entity.EntityID)
+		if posted.Before(period.From) {
+			statement.OpeningBalance = statement.OpeningBalance + amount
+		} else if period.contains(posted) {
+			statement.Entries = append(statement.Entries, entry)
+		}
+	}
+	statement.ClosingBalance = statement.OpeningBalance
+	for _, entry := range statement.Entries {
+		statement.ClosingBalance = statement.ClosingBalance + signedAmount(entry, entity.EntityID)
+	}
+	b, err := json.Marshal(statement)
+	if err != nil {
+		return nil, newError(errLedger, "Error while marshalling statement")
+	}
+	return b, nil
+}
+
+/*	getJournalBalance - balance derived from the journal next to the stored Entity.Balance
+		args 0	:	Caller (the entity itself, SettlementAgent or RegBody)
+		args 1	:	Entity ID (optional, the caller when omitted)
+*/
+func (t *SimpleChaincode) getJournalBalance(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	if len(args) < 1 || len(args) > 2 {
+		return nil, newError(errArgumentCount, "Incorrect number of arguments")
+	}
+	caller, err := getEntityState(stub, args[0])
+	if err != nil {
+		return nil, err
+	}
+	entity := caller
+	if len(args) == 2 && args[1] != "" && args[1] != caller.EntityID {
+		if caller.EntityType != "SettlementAgent" && caller.EntityType != "RegBody" {
+			return nil, newError(errNotAuthorized, "Not authorised to read the journal balance of " + args[1])
+		}
+		entity, err = getEntityState(stub, args[1])
+		if err != nil {
+			return nil, err
+		}
+	}
+	derived, err := journalBalance(stub, entity)
+	if err != nil {
+		return nil, err
+	}
+	b, err := json.Marshal(map[string]interface{}{
+		"EntityID":       entity.EntityID,
+		"Balance":        entity.Balance,
+		"JournalBalance": derived,
+	})
+	if err != nil {
+		return nil, newError(errLedger, "Error while marshalling journal balance")
+	}
+	return b, nil
+}
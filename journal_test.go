@@ -0,0 +1,58 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestCashMovementsAreJournaled(t *testing.T) {
+	s := newTestStub(t, "2017-03-01T10:00:00Z")
+	balance := s.entity(t, entity7).Balance
+	s.mustInvoke(t, entity10, "depositCash", entity10, entity7, "1000", "REF1")
+	s.mustInvoke(t, entity10, "withdrawCash", entity10, entity7, "250", "REF2")
+	assertBalance(t, s, entity7, balance+750)
+	_, err := s.invoke(entity10, "depositCash", entity10, entity7, "1000", "REF1")
+	assertCode(t, err, errAlreadyExists)
+	_, err = s.invoke(entity7, "depositCash", entity7, entity7, "1000", "REF3")
+	assertCode(t, err, errNotAuthorized)
+	s.assertJournalBalanced(t)
+}
+
+func TestInitAgainKeepsBalances(t *testing.T) {
+	s := newTestStub(t, "2017-03-01T10:00:00Z")
+	s.mustInvoke(t, entity10, "depositCash", entity10, entity7, "1000", "REF1")
+	balance := s.entity(t, entity7).Balance
+	entries, _ := getJournalEntries(newUnitOfWork(s), s.entity(t, entity7))
+
+	s.MockTransactionStart("init2")
+	_, err := s.cc.Init(s, "init", nil)
+	s.MockTransactionEnd("init2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	assertBalance(t, s, entity7, balance)
+	again, _ := getJournalEntries(newUnitOfWork(s), s.entity(t, entity7))
+	if len(again) != len(entries) {
+		t.Errorf("Init posted %d more journal entries", len(again)-len(entries))
+	}
+	s.assertJournalBalanced(t)
+}
+
+func TestGetJournalBalanceEntitlement(t *testing.T) {
+	s := newTestStub(t, "2017-03-01T10:00:00Z")
+	_, err := s.query("getJournalBalance", entity8, entity7)
+	assertCode(t, err, errNotAuthorized)
+	b, err := s.query("getJournalBalance", entity9, entity7)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var balance struct {
+		EntityID       string
+		Balance        float64
+		JournalBalance float64
+	}
+	json.Unmarshal(b, &balance)
+	if balance.EntityID != entity7 || balance.Balance != balance.JournalBalance {
+		t.Errorf("unexpected journal balance %s", b)
+	}
+}
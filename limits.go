@@ -0,0 +1,256 @@
+package main
+
+import (
+	"encoding/json"
+	"sort"
+	"strconv"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+// ExposureLimits are the limits the Risk function sets for a Bank or Investor. Exposure is the par value of the
+// positions in the entity's portfolio, a zero limit is not enforced.
+type ExposureLimits struct {
+	EntityID         string
+	MaxIssuer        float64 // notional per issuer
+	MaxInstrument    float64 // notional per instrument
+	MaxConcentration float64 // percent of the gross limit, or without one of the gross exposure, in a single issuer
+	MaxGross         float64 // notional across all positions
+	UpdatedBy        string
+	UpdatedAt        string
+}
+
+// exposure is an entity's par value per instrument and issuer
+type exposure struct {
+	Instruments map[string]float64
+	Issuers     map[string]float64
+	Gross       float64
+}
+
+// LimitUtilisation is one limit against the exposure it constrains
+type LimitUtilisation struct {
+	Limit       string // "Issuer", "Instrument", "Concentration" or "Gross"
+	Scope       string // issuer or symbol, empty for the gross limit
+	Exposure    float64
+	Max         float64
+	Utilisation float64 // percent of the limit used
+}
+
+// ExposureReport is the response of getExposure
+type ExposureReport struct {
+	EntityID string
+	Gross    float64
+	Limits   ExposureLimits
+	Lines    []LimitUtilisation
+}
+
+func limitsKey(entityID string) string {
+	return "limits_" + entityID
+}
+
+// getLimits returns the limits of the entity, found is false when none were set
+func getLimits(stub shim.ChaincodeStubInterface, entityID string) (ExposureLimits, bool, error) {
+	limits := ExposureLimits{EntityID: entityID}
+	b, err := stub.GetState(limitsKey(entityID))
+	if err != nil {
+		return limits, false, newError(errLedger, "Error while getting limits of "+entityID+" from ledger")
+	}
+	if len(b) == 0 {
+		return limits, false, nil
+	}
+	err = json.Unmarshal(b, &limits)
+	if err != nil {
+		return limits, false, newError(errLedger, "Error while unmarshalling limits of "+entityID)
+	}
+	return limits, true, nil
+}
+
+// getEntityExposure values the entity's portfolio at the instrument prices
+func getEntityExposure(stub shim.ChaincodeStubInterface, entity Entity) (exposure, error) {
+	e := exposure{Instruments: make(map[string]float64), Issuers: make(map[string]float64)}
+	held := positions(entity.Portfolio)
+	for symbol, quantity := range held {
+		if quantity <= 0 {
+			continue
+		}
+		inst, err := getInstrumentState(stub, symbol)
+		if err != nil {
+			return e, err
+		}
+		e.add(inst, quantity)
+	}
+	return e, nil
+}
+
+func (e *exposure) add(inst Instrument, quantity int) {
+	notional := float64(quantity) * inst.InstrumentPrice
+	e.Instruments[inst.Symbol] = e.Instruments[inst.Symbol] + notional
+	e.Issuers[inst.Issuer] = e.Issuers[inst.Issuer] + notional
+	e.Gross = e.Gross + notional
+}
+
+// utilisation lists every limit set against the exposure, in a stable order
+func (e exposure) utilisation(limits ExposureLimits) []LimitUtilisation {
+	lines := []LimitUtilisation{}
+	line := func(limit string, scope string, value float64, max float64) {
+		if max > 0 {
+			lines = append(lines, LimitUtilisation{Limit: limit, Scope: scope, Exposure: value, Max: max, Utilisation: value / max * 100})
+		}
+	}
+	var issuers, symbols []string
+	for issuer := range e.Issuers {
+		issuers = append(issuers, issuer)
+	}
+	for symbol := range e.Instruments {
+		symbols = append(symbols, symbol)
+	}
+	sort.Strings(issuers)
+	sort.Sort(byCounter(symbols))
+	for _, issuer := range issuers {
+		line("Issuer", issuer, e.Issuers[issuer], limits.MaxIssuer)
+	}
+	for _, symbol := range symbols {
+		line("Instrument", symbol, e.Instruments[symbol], limits.MaxInstrument)
+	}
+	// concentration is measured against the gross limit when there is one, so a book can be built up from its first
+	// position, which would otherwise always be 100% in one issuer
+	base := limits.MaxGross
+	if base == 0 {
+		base = e.Gross
+	}
+	if base > 0 {
+		for _, issuer := range issuers {
+			line("Concentration", issuer, e.Issuers[issuer]/base*100, limits.MaxConcentration)
+		}
+	}
+	line("Gross", "", e.Gross, limits.MaxGross)
+	return lines
+}
+
+//==============================================================================================================================
+//	 checkLimits - Rejects taking quantity more of the instrument onto the entity's book when the resulting exposure
+//				   would breach one of its limits. Entities without limits are not checked.
+//==============================================================================================================================
+func checkLimits(stub shim.ChaincodeStubInterface, entityID string, inst Instrument, quantity int) error {
+	limits, found, err := getLimits(stub, entityID)
+	if err != nil || !found {
+		return err
+	}
+	entity, err := getEntityState(stub, entityID)
+	if err != nil {
+		return err
+	}
+	e, err := getEntityExposure(stub, entity)
+	if err != nil {
+		return err
+	}
+	e.add(inst, quantity)
+	for _, u := range e.utilisation(limits) {
+		if u.Exposure > u.Max {
+			return newError(errLimitExceeded, u.Limit+" limit of "+entityID+" would be exceeded",
+				"entity", entityID,
+				"limit", u.Limit,
+				"scope", u.Scope,
+				"max", strconv.FormatFloat(u.Max, 'f', 2, 64),
+				"exposure", strconv.FormatFloat(u.Exposure, 'f', 2, 64))
+		}
+	}
+	return nil
+}
+
+/*	setExposureLimits - Risk sets the limits of a Bank or Investor, 0 removes a limit
+		args 0	:	Caller (Risk)
+		args 1	:	Entity
+		args 2	:	Max notional per issuer
+		args 3	:	Max notional per instrument
+		args 4	:	Max concentration in one issuer (percent)
+		args 5	:	Max gross exposure
+*/
+func (t *SimpleChaincode) setExposureLimits(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	if len(args) != 6 {
+		return nil, newError(errArgumentCount, "Incorrect number of arguments")
+	}
+	caller, err := getEntityState(stub, args[0])
+	if err != nil {
+		return nil, err
+	}
+	if caller.EntityType != "Risk" {
+		return nil, newError(errNotAuthorized, "Only the Risk function can set exposure limits")
+	}
+	entity, err := getEntityState(stub, args[1])
+	if err != nil {
+		return nil, err
+	}
+	if entity.EntityType != "Bank" && entity.EntityType != "Investor" {
+		return nil, newError(errInvalidArgument, "Limits apply to Banks and Investors only", "entity", entity.EntityID)
+	}
+	var values [4]float64
+	for i := range values {
+		values[i], err = strconv.ParseFloat(args[i+2], 64)
+		if err != nil || values[i] < 0 {
+			return nil, newError(errInvalidArgument, "Invalid limit "+args[i+2])
+		}
+	}
+	if values[2] > 100 {
+		return nil, newError(errInvalidArgument, "Concentration limit is a percentage, expecting at most 100")
+	}
+	timeStamp, err := txTimeStamp(stub)
+	if err != nil {
+		return nil, err
+	}
+	limits := ExposureLimits{
+		EntityID:         entity.EntityID,
+		MaxIssuer:        values[0],
+		MaxInstrument:    values[1],
+		MaxConcentration: values[2],
+		MaxGross:         values[3],
+		UpdatedBy:        caller.EntityID,
+		UpdatedAt:        timeStamp,
+	}
+	b, err := json.Marshal(limits)
+	if err != nil {
+		return nil, newError(errLedger, "Error while marshalling limits")
+	}
+	err = stub.PutState(limitsKey(entity.EntityID), b)
+	if err != nil {
+		return nil, newError(errLedger, "Error while writing limits to ledger")
+	}
+	return nil, nil
+}
+
+/*	getExposure - The entity's exposure and how much of each limit it uses
+		args 0	:	Caller (the entity itself, Risk or the Regulatory Body)
+		args 1	:	Entity (optional, the caller when omitted)
+*/
+func (t *SimpleChaincode) getExposure(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	if len(args) < 1 || len(args) > 2 {
+		return nil, newError(errArgumentCount, "Incorrect number of arguments")
+	}
+	caller, err := getEntityState(stub, args[0])
+	if err != nil {
+		return nil, err
+	}
+	entity := caller
+	if len(args) == 2 && args[1] != "" && args[1] != caller.EntityID {
+		if caller.EntityType != "Risk" && caller.EntityType != "RegBody" {
+			return nil, newError(errNotAuthorized, "Only Risk and the Regulatory Body can see the exposure of another entity")
+		}
+		entity, err = getEntityState(stub, args[1])
+		if err != nil {
+			return nil, err
+		}
+	}
+	limits, _, err := getLimits(stub, entity.EntityID)
+	if err != nil {
+		return nil, err
+	}
+	e, err := getEntityExposure(stub, entity)
+	if err != nil {
+		return nil, err
+	}
+	b, err := json.Marshal(ExposureReport{EntityID: entity.EntityID, Gross: e.Gross, Limits: limits, Lines: e.utilisation(limits)})
+	if err != nil {
+		return nil, newError(errLedger, "Error while marshalling exposure")
+	}
+	return b, nil
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"encoding/json"
+	"strconv"
+)
+
+// largest page a list query returns in one response
+const maxPageSize = 500
+
+// ListOptions is the optional last argument of the list queries, given as JSON. Filters a record has no field for
+// are ignored, so Counterparty does not narrow a list of entities.
+type ListOptions struct {
+	PageSize     int    // records per page, everything in one response when 0
+	Bookmark     string // Bookmark of the previous page, the page starts after this record
+	Status       string
+	From         string // YYYY-MM-DD, inclusive
+	To           string // YYYY-MM-DD, inclusive
+	Counterparty string
+	Symbol       string
+	EntityType   string // getEntities only
+	period       dateRange
+}
+
+// Page is the response of a list query called with a page size
+type Page struct {
+	Records  interface{}
+	Count    int
+	Bookmark string // pass on to get the next page, empty on the last page
+}
+
+// parseListOptions reads the options at args[i], a missing or empty argument means no options
+func parseListOptions(args []string, i int) (ListOptions, error) {
+	var opts ListOptions
+	if len(args) <= i || args[i] == "" {
+		return opts, nil
+	}
+	err := json.Unmarshal([]byte(args[i]), &opts)
+	if err != nil {
+		return opts, newError(errInvalidArgument, "List options should be a JSON object", "options", args[i])
+	}
+	if opts.PageSize < 0 || opts.PageSize > maxPageSize {
+		return opts, newError(errInvalidArgument, "Page size should be between 0 and "+strconv.Itoa(maxPageSize), "pageSize", strconv.Itoa(opts.PageSize))
+	}
+	opts.period, err = parseDateRange([]string{opts.From, opts.To}, 0)
+	if err != nil {
+		return opts, err
+	}
+	if !opts.period.From.IsZero() && !opts.period.To.IsZero() && opts.period.From.After(opts.period.To) {
+		return opts, newError(errInvalidArgument, "From date should not be after the to date")
+	}
+	return opts, nil
+}
+
+func (o ListOptions) matchStatus(status string) bool {
+	return o.Status == "" || o.Status == status
+}
+
+func (o ListOptions) matchSymbol(symbol string) bool {
+	return o.Symbol == "" || o.Symbol == symbol
+}
+
+// matchCounterparty is true when any of the parties is the counterparty asked for
+func (o ListOptions) matchCounterparty(parties ...string) bool {
+	if o.Counterparty == "" {
+		return true
+	}
+	for _, p := range parties {
+		if p == o.Counterparty {
+			return true
+		}
+	}
+	return false
+}
+
+//==============================================================================================================================
+//	 collectPage - Walks ids in order starting after the bookmark and hands each to load, which reports whether the
+//				   record passed the filters. Stops once a page is full and returns the bookmark of the next page.
+//==============================================================================================================================
+func collectPage(ids []string, opts ListOptions, load func(id string) (bool, error)) (string, error) {
+	start := 0
+	if opts.Bookmark != "" {
+		start = -1
+		for i, id := range ids {
+			if id == opts.Bookmark {
+				start = i + 1
+				break
+			}
+		}
+		if start < 0 {
+			return "", newError(errInvalidArgument, "Unknown bookmark "+opts.Bookmark, "bookmark", opts.Bookmark)
+		}
+	}
+	count := 0
+	for i := start; i < len(ids); i++ {
+		kept, err := load(ids[i])
+		if err != nil {
+			return "", err
+		}
+		if !kept {
+			continue
+		}
+		count++
+		if opts.PageSize > 0 && count == opts.PageSize {
+			if i+1 < len(ids) {
+				return ids[i], nil
+			}
+			return "", nil
+		}
+	}
+	return "", nil
+}
+
+// listResponse marshals the records as they always were, or as a Page when a page size was asked for
+func listResponse(records interface{}, count int, bookmark string, opts ListOptions) ([]byte, error) {
+	var b []byte
+	var err error
+	if opts.PageSize > 0 {
+		b, err = json.Marshal(Page{Records: records, Count: count, Bookmark: bookmark})
+	} else {
+		b, err = json.Marshal(records)
+	}
+	if err != nil {
+		return nil, newError(errLedger, "Error while marshalling list")
+	}
+	return b, nil
+}
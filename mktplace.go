@@ -2,12 +2,10 @@ package main
 import (
 	"fmt"
 	"github.com/hyperledger/fabric/core/chaincode/shim"
-	"errors"
 	"encoding/json"
 	"strconv"
 	"crypto/x509"
 	"strings"
-	"time"
 	//"encoding/pem"
 	//"net/url"
 	
@@ -24,8 +22,8 @@ type Instrument struct{
 	Quantity int
 	InstrumentPrice float64
 	Rate float64
-	SettlementDate string
-	IssueDate	string
+	SettlementDate string		// maturity, YYYY-MM-DD
+	IssueDate	string			// YYYY-MM-DD
 	Callable	string
 	TradeID []string
 	QuantityResponded int
@@ -33,16 +31,93 @@ type Instrument struct{
 	Owner string
 	Bank string
 	Issuer string
+	Redemption string			// "Bullet" or "Amortizing" or "SinkingFund"
+	RedemptionMethod string		// "ProRata" or "Lottery"
+	RedemptionSchedule []Redemption
+	CouponSchedule []CouponPayment
+	RepurchaseOffer *RepurchaseOffer	`json:",omitempty"`	// sinking fund, the issuer's standing offer to buy units back
+	Repurchased int				`json:",omitempty"`	// sinking fund units the issuer bought back, held apart until retired
+	PutSchedule []PutOption
+	PutExercises []PutExercise
+	CreditEvents []string		// credit event ids, set once the issuer misses a payment
+	CreditRating string			`json:",omitempty"`	// set by the RatingAgency, the issuer's rating applies when empty
+	Recoveries []Recovery
+	Calendar string				// market whose holidays the dates roll on, weekends only when empty
+	BusinessDayConvention string	// "Unadjusted" or "Following" or "ModifiedFollowing" or "Preceding"
+	SettlementDays int			// trades settle T+N business days
+	UnadjustedSettlementDate string	`json:",omitempty"`	// as agreed, SettlementDate is rolled from it
+	UnadjustedIssueDate string		`json:",omitempty"`
+}
+type Recovery struct{			// distribution paid to a holder after default
+	Holder string
+	Quantity int
+	Amount float64
+	TransactionID string
+}
+type CreditEvent struct{
+	EventID string
+	Symbol string
+	Issuer string
+	EventType string			// "Missed Coupon" or "Missed Principal" or "Missed Redemption" or "Missed Put"
+	AmountDue float64
+	IssuerBalance float64		// available balance, held cash excluded
+	TimeStamp string
+}
+type Redemption struct{			// scheduled partial repayment of principal
+	Date string
+	UnadjustedDate string		`json:",omitempty"`	// as scheduled, Date is rolled from it
+	Quantity int
+	Retired int					`json:",omitempty"`	// sinking fund, units met from repurchased units rather than drawn
+	Status string				// "Scheduled" or "Redeemed"
+	TransactionID []string		// one payment transaction per holder
+}
+type CouponPayment struct{		// a coupon period, paid once
+	Date string
+	UnadjustedDate string		`json:",omitempty"`	// as scheduled, Date is rolled from it
+	Status string				// "Scheduled" or "Paid" or "Missed"
+	TransactionID string		`json:",omitempty"`	// the payment
+}
+type RepurchaseOffer struct{	// holders tender units into it until Quantity is used up
+	Price float64
+	Quantity int
+	HoldID string				// issuer cash earmarked for the units still wanted, the offer lapses with it
+	TransactionID []string		// one repurchase transaction per tender
+}
+type PutOption struct{			// holder's right to sell back to the issuer
+	Date string
+	UnadjustedDate string		`json:",omitempty"`	// as scheduled, Date is rolled from it
+	Price float64
+	NoticeDays int				// exercise window opens this many days before Date
+	Status string				// "Open" or "Settled"
+}
+type PutExercise struct{
+	ExerciseID string			// transaction id of the exercise
+	Holder string
+	PutDate string
+	Quantity int
+	Price float64
+	Status string				// "Pending" or "Settled" or "Failed"
+	Reason string
+	SettlementID string			// transaction id of the put redemption
+	HoldID string				`json:",omitempty"`	// issuer cash earmarked for the put, when it had the cash at exercise
 }
 type Entity struct{
 	EntityID string				// enrollmentID
 	EntityName string
 	EntityType string
 	Portfolio []Stock
-	Instruments []string
-	TradeHistory []string		// list of tradeIDs
-	IoiList []string
+	// the lists below live in the entity~ indexes, they are only filled in by readEntity
+	Instruments []string		`json:",omitempty"`
+	TradeHistory []string		`json:",omitempty"`	// list of tradeIDs
+	IoiList []string			`json:",omitempty"`
+	CashLedger []string			`json:",omitempty"`	// cash deposit and withdrawal transaction ids
+	Journal []string			`json:",omitempty"`	// journal entry ids, Balance is the sum of these entries
+	Holds []string				`json:",omitempty"`	// cash hold ids
 	Balance float64
+	HeldBalance float64			// part of Balance earmarked for pending trades
+	AvailableBalance float64	// Balance - HeldBalance
+	CreditRating string			`json:",omitempty"`	// issuers, set by the RatingAgency
+	Mandate *Mandate			`json:",omitempty"`	// investors, restricts the issues they are offered and take up
 }
 
 type Transaction struct{		// ledger transactions
@@ -55,9 +130,14 @@ type Transaction struct{		// ledger transactions
 	Quantity int
 	InstrumentPrice float64
 	Rate float64	
-	SettlementDate time.Time	
-	Status string
+	SettlementDate string		// business date the transaction settled, YYYY-MM-DD
+	Status string				// "Success", responses become "Cancelled" or "Expired" when their hold is released
 	TimeStamp string
+	Amount float64				// cash movements only
+	ExternalRef string			// payment rail reference of a cash movement
+	HoldID string				// cash held against a response until the trade settles
+	Sealed string				`json:",omitempty"`	// encrypted InstrumentPrice and Rate, see sealing.go
+	SealedDigest string			`json:",omitempty"`	// keyed hash of the sealed values
 }
 
 type Trade struct				
@@ -80,6 +160,8 @@ type Ioi struct
 	Status string				// "New" or "Responded" 
 	Symbol string
 	Owner  string
+	Sealed string				`json:",omitempty"`	// encrypted Notional, see sealing.go
+	SealedDigest string			`json:",omitempty"`	// keyed hash of the sealed value
 }
 
 const entity1 = "user_type1_1" //issuer1
@@ -91,6 +173,14 @@ const entity8 = "user_type2_3"  //investor2
 
 const entity9 = "user_type2_4"  //regulator
 
+const entity10 = "user_type3_1" //settlement agent
+
+const entity11 = "user_type4_1" //market admin
+
+const entity12 = "user_type5_1" //risk officer
+
+const entity13 = "user_type6_1" //rating agency
+
 type SimpleChaincode struct {
 }
 func main() {
@@ -100,6 +190,20 @@ func main() {
     }
 }
 func (t *SimpleChaincode) Init(stub shim.ChaincodeStubInterface, function string, args []string) ([]byte, error) {
+	// Init writes through a unit of work as well, so the documents it creates are stamped with their schema version
+	uow := newUnitOfWork(stub)
+	uow.function = function
+	result, err := t.initLedger(uow, function, args)
+	if err != nil {
+		return nil, toChaincodeError(err)
+	}
+	err = uow.flush()
+	if err != nil {
+		return nil, toChaincodeError(err)
+	}
+	return result, nil
+}
+func (t *SimpleChaincode) initLedger(stub shim.ChaincodeStubInterface, function string, args []string) ([]byte, error) {
 	// initialize Instruent	
 	/*
 	instrument:= Instrument{		
@@ -153,7 +257,7 @@ func (t *SimpleChaincode) Init(stub shim.ChaincodeStubInterface, function string
 	//client.Instruments = append(client.Instruments,"ISU120DCALL")
 	b, err := json.Marshal(client)
 	if err == nil {
-        err = stub.PutState(client.EntityID,b)
+        err = putInitialEntity(stub, client.EntityID,b)
     } else {
 		return nil, err
 	}
@@ -166,7 +270,7 @@ func (t *SimpleChaincode) Init(stub shim.ChaincodeStubInterface, function string
 	}
 	b1, err := json.Marshal(client2)
 	if err == nil {
-        err = stub.PutState(client2.EntityID,b1)
+        err = putInitialEntity(stub, client2.EntityID,b1)
     } else {
 		return nil, err
 	}
@@ -179,7 +283,7 @@ func (t *SimpleChaincode) Init(stub shim.ChaincodeStubInterface, function string
 	}
 	b, err = json.Marshal(bank1)
 	if err == nil {
-        err = stub.PutState(bank1.EntityID,b)
+        err = putInitialEntity(stub, bank1.EntityID,b)
     } else {
 		return nil, err
 	}
@@ -191,7 +295,7 @@ func (t *SimpleChaincode) Init(stub shim.ChaincodeStubInterface, function string
 	}
 	b, err = json.Marshal(bank2)
 	if err == nil {
-		err = stub.PutState(bank2.EntityID,b)
+		err = putInitialEntity(stub, bank2.EntityID,b)
     } else {
 		return nil, err
 	}
@@ -203,7 +307,7 @@ func (t *SimpleChaincode) Init(stub shim.ChaincodeStubInterface, function string
 	}
 	b, err = json.Marshal(regBody)
 	if err == nil {
-		err = stub.PutState(regBody.EntityID,b)
+		err = putInitialEntity(stub, regBody.EntityID,b)
     } else {
 		return nil, err
 	}
@@ -216,7 +320,7 @@ func (t *SimpleChaincode) Init(stub shim.ChaincodeStubInterface, function string
 	}
 	b, err = json.Marshal(inv1)
 	if err == nil {
-		err = stub.PutState(inv1.EntityID,b)
+		err = putInitialEntity(stub, inv1.EntityID,b)
     } else {
 		return nil, err
 	}
@@ -229,12 +333,60 @@ func (t *SimpleChaincode) Init(stub shim.ChaincodeStubInterface, function string
 	}
 	b, err = json.Marshal(inv2)
 	if err == nil {
-		err = stub.PutState(inv2.EntityID,b)
+		err = putInitialEntity(stub, inv2.EntityID,b)
+    } else {
+		return nil, err
+	}
+	
+	agent:= Entity{
+		EntityID: entity10,
+		EntityName:	"Federal Reserve Bank of New York",
+		EntityType: "SettlementAgent",
+	}
+	b, err = json.Marshal(agent)
+	if err == nil {
+		err = putInitialEntity(stub, agent.EntityID,b)
+    } else {
+		return nil, err
+	}
+	
+	admin:= Entity{
+		EntityID: entity11,
+		EntityName:	"Marketplace Operations",
+		EntityType: "Admin",
+	}
+	b, err = json.Marshal(admin)
+	if err == nil {
+		err = putInitialEntity(stub, admin.EntityID,b)
     } else {
 		return nil, err
 	}
 	
-	EntityList := []string{entity1,entity2, entity3, entity4, entity7, entity8, entity9}
+	risk:= Entity{
+		EntityID: entity12,
+		EntityName:	"Marketplace Risk Management",
+		EntityType: "Risk",
+	}
+	b, err = json.Marshal(risk)
+	if err == nil {
+		err = putInitialEntity(stub, risk.EntityID,b)
+    } else {
+		return nil, err
+	}
+	
+	rater:= Entity{
+		EntityID: entity13,
+		EntityName:	"Marketplace Credit Ratings",
+		EntityType: "RatingAgency",
+	}
+	b, err = json.Marshal(rater)
+	if err == nil {
+		err = putInitialEntity(stub, rater.EntityID,b)
+    } else {
+		return nil, err
+	}
+	
+	EntityList := []string{entity1,entity2, entity3, entity4, entity7, entity8, entity9, entity10, entity11, entity12, entity13}
 
 	b, err = json.Marshal(EntityList)
 	if err == nil {
@@ -250,7 +402,7 @@ func (t *SimpleChaincode) Init(stub shim.ChaincodeStubInterface, function string
 	}
 	ctidByte,err := stub.GetState("currentTransactionNum")
 	if(err != nil){
-		return nil, errors.New("Error while getting currentTransactionNum from ledger")
+		return nil, newError(errLedger, "Error while getting currentTransactionNum from ledger")
 	}
 	
 	byteVal, err = stub.GetState("currentTradeNum")
@@ -259,7 +411,7 @@ func (t *SimpleChaincode) Init(stub shim.ChaincodeStubInterface, function string
 	}
 	ctidByte,err = stub.GetState("currentTradeNum")
 	if(err != nil){
-		return nil, errors.New("Error while getting currentTradeNum from ledger")
+		return nil, newError(errLedger, "Error while getting currentTradeNum from ledger")
 	}
 	
 	// initialize Ioi num
@@ -269,7 +421,7 @@ func (t *SimpleChaincode) Init(stub shim.ChaincodeStubInterface, function string
 	}
 	ctidByte,err = stub.GetState("currentIoiNum")
 	if(err != nil){
-		return nil, errors.New("Error while getting currentIoiNum from ledger")
+		return nil, newError(errLedger, "Error while getting currentIoiNum from ledger")
 	}
 	// initialize Instrument num 
 	byteVal, err = stub.GetState("currentInstrumentNum")
@@ -278,11 +430,57 @@ func (t *SimpleChaincode) Init(stub shim.ChaincodeStubInterface, function string
 	}
 	ctidByte,err = stub.GetState("currentInstrumentNum")
 	if(err != nil){
-		return nil, errors.New("Error while getting currentInstrumentNum from ledger")
+		return nil, newError(errLedger, "Error while getting currentInstrumentNum from ledger")
+	}
+	// initialize Credit Event num
+	byteVal, err = stub.GetState("currentCreditEventNum")
+	if len(byteVal) == 0 {
+		err = stub.PutState("currentCreditEventNum", []byte("1000"))
+	}
+	// initialize Hold num
+	byteVal, err = stub.GetState("currentHoldNum")
+	if len(byteVal) == 0 {
+		err = stub.PutState("currentHoldNum", []byte("1000"))
+	}
+	// initialize Journal num and journal the opening balances
+	byteVal, err = stub.GetState("currentJournalNum")
+	if len(byteVal) == 0 {
+		err = stub.PutState("currentJournalNum", []byte("1000"))
+	}
+	for _, entityID := range EntityList {
+		err = postOpeningBalance(stub, entityID)
+		if err != nil {
+			return nil, err
+		}
+	}
+	// initialize holiday calendars, the Admin maintains the holidays
+	for _, market := range defaultMarkets {
+		byteVal, err = stub.GetState(calendarKey(market))
+		if len(byteVal) == 0 {
+			err = putCalendar(stub, Calendar{Market: market, UpdatedBy: entity11})
+			if err != nil {
+				return nil, err
+			}
+		}
 	}
     return ctidByte, nil
 }
 
+//==============================================================================================================================
+//	 putInitialEntity - Writes an entity created by Init unless it is already on the ledger. Init runs again on every
+//						upgrade, an existing entity keeps its balance and journal.
+//==============================================================================================================================
+func putInitialEntity(stub shim.ChaincodeStubInterface, entityID string, b []byte) error {
+	existing, err := ledgerState(stub, entityID)
+	if err != nil {
+		return newError(errLedger, "Error while getting "+entityID+" from ledger")
+	}
+	if len(existing) > 0 {
+		return nil
+	}
+	return stub.PutState(entityID, b)
+}
+
 
 //==============================================================================================================================
 //	 General Functions
@@ -294,7 +492,7 @@ func (t *SimpleChaincode) get_ecert(stub shim.ChaincodeStubInterface, name strin
 
 	ecert, err := stub.GetState(name)
 
-	if err != nil { return nil, errors.New("Couldn't retrieve ecert for user " + name) }
+	if err != nil { return nil, newError(errLedger, "Couldn't retrieve ecert for user " + name) }
 
 	return ecert, nil
 }
@@ -309,7 +507,7 @@ func (t *SimpleChaincode) add_ecert(stub shim.ChaincodeStubInterface, name strin
 	err := stub.PutState(name, []byte(ecert))
 
 	if err == nil {
-		return nil, errors.New("Error storing eCert for user " + name + " identity: " + ecert)
+		return nil, newError(errLedger, "Error storing eCert for user " + name + " identity: " + ecert)
 	}
 
 	return nil, nil
@@ -324,7 +522,7 @@ func (t *SimpleChaincode) add_ecert(stub shim.ChaincodeStubInterface, name strin
 func (t *SimpleChaincode) get_username(stub shim.ChaincodeStubInterface) (string, error) {
 
     username, err := stub.ReadCertAttribute("enrollmentID");
-	if err != nil { return "", errors.New("Couldn't get attribute 'username'. Error: " + err.Error()) }
+	if err != nil { return "", newError(errNotAuthorized, "Couldn't get attribute 'username'. Error: " + err.Error()) }
 	return string(username), nil
 }
 //==============================================================================================================================
@@ -334,7 +532,7 @@ func (t *SimpleChaincode) get_username(stub shim.ChaincodeStubInterface) (string
 
 func (t *SimpleChaincode) check_affiliation(stub shim.ChaincodeStubInterface) (string, error) {
     affiliation, err := stub.ReadCertAttribute("enrollmentID");
-	if err != nil { return "", errors.New("Couldn't get attribute 'role'. Error: " + err.Error()) }
+	if err != nil { return "", newError(errNotAuthorized, "Couldn't get attribute 'role'. Error: " + err.Error()) }
 	return string(affiliation), nil
 
 }
@@ -365,11 +563,47 @@ func (t *SimpleChaincode) get_caller_data(stub shim.ChaincodeStubInterface) (str
 func (t *SimpleChaincode) Invoke(stub shim.ChaincodeStubInterface, function string, args []string) ([]byte, error) {
     
 	caller, err := t.get_username(stub)
-	fmt.Println("Caller Detail " + caller+" :"  +err.Error())
+	if err != nil {
+		fmt.Println("Caller Detail :" + err.Error())
+	} else {
+		fmt.Println("Caller Detail " + caller)
+	}
 	
+	// handlers write through the unit of work, nothing reaches the ledger unless the handler succeeds
+	uow := newUnitOfWork(stub)
+	uow.caller = caller
+	uow.function = function
+	uow.fieldKey, err = fieldKeyFromMetadata(stub)
+	if err != nil {
+		return nil, toChaincodeError(err)
+	}
+	args, idempotencyKey := splitIdempotencyKey(args)
+	result, err := t.invokeOnce(uow, function, args, idempotencyKey)
+	if err != nil {
+		return nil, toChaincodeError(err)
+	}
+	err = recordSuccess(uow, result)
+	if err != nil {
+		return nil, toChaincodeError(err)
+	}
+	err = uow.flush()
+	if err != nil {
+		return nil, toChaincodeError(err)
+	}
+	err = uow.publishEvents()
+	if err != nil {
+		return nil, toChaincodeError(err)
+	}
+	return result, nil
+}
+func (t *SimpleChaincode) invokeFunction(stub shim.ChaincodeStubInterface, function string, args []string) ([]byte, error) {
+	err := validateArgs(stub, invokeSpecs, function, args)
+	if err != nil {
+		return nil, err
+	}
 	// Handle different functions
     if function == "init" {
-        return t.Init(stub, "init", args)
+        return t.initLedger(stub, "init", args)
     } else if function == "createIssue" {
         return t.createIssue(stub, args)
     } else if function == "requestForIssue" {
@@ -388,11 +622,65 @@ func (t *SimpleChaincode) Invoke(stub shim.ChaincodeStubInterface, function stri
         return t.issueCallout(stub, args)
 	} else if function == "requestForInstrument" {
         return t.requestForInstrument(stub, args)
+	} else if function == "setRedemptionSchedule" {
+        return t.setRedemptionSchedule(stub, args)
+	} else if function == "setCouponSchedule" {
+        return t.setCouponSchedule(stub, args)
+	} else if function == "redeemPrincipal" {
+        return t.redeemPrincipal(stub, args)
+	} else if function == "offerRepurchase" {
+        return t.offerRepurchase(stub, args)
+	} else if function == "tenderUnits" {
+        return t.tenderUnits(stub, args)
+	} else if function == "setPutSchedule" {
+        return t.setPutSchedule(stub, args)
+	} else if function == "exercisePut" {
+        return t.exercisePut(stub, args)
+	} else if function == "settlePuts" {
+        return t.settlePuts(stub, args)
+	} else if function == "distributeRecovery" {
+        return t.distributeRecovery(stub, args)
+	} else if function == "depositCash" {
+        return t.depositCash(stub, args)
+	} else if function == "withdrawCash" {
+        return t.withdrawCash(stub, args)
+	} else if function == "cancelHold" {
+        return t.cancelHold(stub, args)
+	} else if function == "releaseExpiredHolds" {
+        return t.releaseExpiredHolds(stub, args)
+	} else if function == "setCalendar" {
+        return t.setCalendar(stub, args)
+	} else if function == "setInstrumentCalendar" {
+        return t.setInstrumentCalendar(stub, args)
+	} else if function == "migrate" {
+        return t.migrate(stub, args)
+	} else if function == "setExposureLimits" {
+        return t.setExposureLimits(stub, args)
+	} else if function == "setCreditRating" {
+        return t.setCreditRating(stub, args)
+	} else if function == "setMandate" {
+        return t.setMandate(stub, args)
+	} else if function == "upgradeIndexes" {
+        return t.upgradeIndexes(stub, args)
+	} else if function == "recordFailure" {
+        return t.recordFailure(stub, args)
     } 
     fmt.Println("invoke did not find func: " + function)
-    return nil, errors.New("Received unknown function invocation")
+    return nil, newError(errUnknownFunction, "Received unknown function invocation", "function", function)
 }
 func (t *SimpleChaincode) Query(stub shim.ChaincodeStubInterface, function string, args []string) ([]byte, error) {
+	// queries read through a unit of work too so older documents are upgraded on read, nothing is ever flushed
+	result, err := t.queryFunction(newUnitOfWork(stub), function, args)
+	if err != nil {
+		return nil, toChaincodeError(err)
+	}
+	return result, nil
+}
+func (t *SimpleChaincode) queryFunction(stub shim.ChaincodeStubInterface, function string, args []string) ([]byte, error) {
+	err := validateArgs(stub, querySpecs, function, args)
+	if err != nil {
+		return nil, err
+	}
     // Handle different functions
     if function == "readEntity" {
         return t.readEntity(stub, args)
@@ -426,57 +714,108 @@ func (t *SimpleChaincode) Query(stub shim.ChaincodeStubInterface, function strin
         return t.getAllInstrumentTrades(stub, args)
 	}	else if function == "getAllIoi" {
         return t.getAllIoi(stub, args)
+	}	else if function == "getDefaultedIssues" {
+        return t.getDefaultedIssues(stub, args)
+	}	else if function == "getDefaultExposure" {
+        return t.getDefaultExposure(stub, args)
+	}	else if function == "getCashLedger" {
+        return t.getCashLedger(stub, args)
+	}	else if function == "getStatement" {
+        return t.getStatement(stub, args)
+	}	else if function == "getJournalBalance" {
+        return t.getJournalBalance(stub, args)
+	}	else if function == "getHolds" {
+        return t.getHolds(stub, args)
+	}	else if function == "getCalendar" {
+        return t.getCalendar(stub, args)
+	}	else if function == "searchInstruments" {
+        return t.searchInstruments(stub, args)
+	}	else if function == "searchTransactions" {
+        return t.searchTransactions(stub, args)
+	}	else if function == "getHistory" {
+        return t.getHistory(stub, args)
+	}	else if function == "getIdempotencyKeys" {
+        return t.getIdempotencyKeys(stub, args)
+	}	else if function == "unsealRecord" {
+        return t.unsealRecord(stub, args)
+	}	else if function == "reportIssuance" {
+        return t.reportIssuance(stub, args)
+	}	else if function == "reportHoldings" {
+        return t.reportHoldings(stub, args)
+	}	else if function == "reportVolume" {
+        return t.reportVolume(stub, args)
+	}	else if function == "reportCommissions" {
+        return t.reportCommissions(stub, args)
+	}	else if function == "reportExceptions" {
+        return t.reportExceptions(stub, args)
+	}	else if function == "getExposure" {
+        return t.getExposure(stub, args)
+	}	else if function == "getEligibleInvestors" {
+        return t.getEligibleInvestors(stub, args)
     }
 	fmt.Println("query did not find func: " + function)
-    return nil, errors.New("Received unknown function query")
+    return nil, newError(errUnknownFunction, "Received unknown function query", "function", function)
 }
 func (t *SimpleChaincode) readEntity(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
     var jsonResp string
     var err error
 	var valAsbytes []byte
     if len(args) != 1 {
-        return nil, errors.New("Incorrect number of arguments. Expecting entity ID")
+        return nil, newError(errArgumentCount, "Incorrect number of arguments. Expecting entity ID")
     }
 	valAsbytes, err = stub.GetState(args[0])
     if err != nil {
         jsonResp = "{\"Error\":\"Failed to get state for " + args[0] + "\"}"
-        return nil, errors.New(jsonResp)
+        return nil, newError(errLedger, jsonResp)
     }
+	var entity Entity
+	err = json.Unmarshal(valAsbytes, &entity)
+	if err != nil {
+		return nil, newError(errLedger, "Error while unmarshalling entity data")
+	}
+	entity, err = withIndexes(stub, entity)
+	if err != nil {
+		return nil, err
+	}
+	valAsbytes, err = json.Marshal(entity)
+	if err != nil {
+		return nil, newError(errLedger, "Error while marshalling entity data")
+	}
     return valAsbytes, nil
 }
 func (t *SimpleChaincode) readTransaction(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
     var tid, jsonResp string
     var err error
     if len(args) != 1 {
-		return nil, errors.New("Incorrect number of arguments. Expecting transaction ID")
+		return nil, newError(errArgumentCount, "Incorrect number of arguments. Expecting transaction ID")
     }
     tid = args[0]
     valAsbytes, err := stub.GetState(tid)
 	if err != nil {
 		jsonResp = "{\"Error\":\"Failed to get state for " + tid + "\"}"
-		return nil, errors.New(jsonResp)
+		return nil, newError(errLedger, jsonResp)
     }
 	var tran Transaction
 	err = json.Unmarshal(valAsbytes, &tran)
 	if(err != nil){
-		return nil, errors.New("Error while unmarshalling transaction data")
+		return nil, newError(errLedger, "Error while unmarshalling transaction data")
 	}
 	return valAsbytes, nil
 	bytes, err := stub.GetCallerCertificate();
 	if(err != nil){
-		return nil, errors.New("Error while getting caller certificate")
+		return nil, newError(errLedger, "Error while getting caller certificate")
 	}
 	x509Cert, err := x509.ParseCertificate(bytes);
 	
 	// check entity type and accordingly allow transaction to be read
 	entityByte,err := stub.GetState(x509Cert.Subject.CommonName)
 	if(err != nil){
-		return nil, errors.New("Error while getting bank info from ledger")
+		return nil, newError(errLedger, "Error while getting bank info from ledger")
 	}
 	var entity Entity
 	err = json.Unmarshal(entityByte, &entity)
 	if(err != nil){
-		return nil, errors.New("Error while unmarshalling entity data")
+		return nil, newError(errLedger, "Error while unmarshalling entity data")
 	}
 	
 	switch entity.EntityType {
@@ -510,7 +849,7 @@ func (t *SimpleChaincode) requestForIssue(stub shim.ChaincodeStubInterface, args
 		var instr Instrument
 		err = json.Unmarshal(instbyte, &instr)
 		if(err != nil){
-			return nil, errors.New("Error while unmarshalling Instrument data:" +args[1])
+			return nil, newError(errLedger, "Error while unmarshalling Instrument data:" +args[1])
 		}
 		fmt.Println("Owner "+instr.Owner)
 		fmt.Println("Caller "+args[0])
@@ -525,35 +864,41 @@ func (t *SimpleChaincode) requestForIssue(stub shim.ChaincodeStubInterface, args
 		
 		ctidByte1,err1 := stub.GetState("currentTransactionNum")
 		if(err1 != nil){
-			return nil, errors.New("Error while getting currentTransactionNum from ledger")
+			return nil, newError(errLedger, "Error while getting currentTransactionNum from ledger")
 		}
 		tid,err := strconv.Atoi(string(ctidByte1))
 		if(err != nil){
-			return nil, errors.New("Error while converting ctidByte to integer")
+			return nil, newError(errLedger, "Error while converting ctidByte to integer")
 		}
 		tid = tid + 1
 		transactionID = "trans"+strconv.Itoa(tid)
 		
 		if(err != nil){
-			_ = updateTransactionStatus(stub, transactionID, "Error while converting ctidByte to integer")
-			return nil, nil
+			return nil, newError(errLedger, "Error while converting ctidByte to integer")
 		}
 
 		bytes, err := stub.GetCallerCertificate();
 		if err != nil {
-			_ = updateTransactionStatus(stub, transactionID, "Error while getting caller certificate")
-			return nil, nil
+			return nil, newError(errLedger, "Error while getting caller certificate")
 		}
 		// get client enrollmentID
 		x509Cert, err := x509.ParseCertificate(bytes);
 		if err != nil {
-			_ = updateTransactionStatus(stub, transactionID, "Error while parsing caller certificate")
-			return nil, nil
+			return nil, newError(errLedger, "Error while parsing caller certificate")
 		}
 		fmt.Println("x509Cert.Subject.CommonName :" +x509Cert.Subject.CommonName)
 		status := args[3]
+		// investors are only offered issues their mandate allows
+		err = checkMandate(stub, args[2], instr)
+		if err != nil {
+			return nil, err
+		}
 		//  Create Multiple Transactions with Each Bank as per selection in UI
 		//Transaction
+		timeStamp, err := txTimeStamp(stub)
+		if err != nil {
+			return nil, err
+		}
 		trn := Transaction{
 		TransactionID: transactionID,
 		TransactionType: status,
@@ -564,7 +909,7 @@ func (t *SimpleChaincode) requestForIssue(stub shim.ChaincodeStubInterface, args
 		InstrumentPrice: instr.InstrumentPrice,
 		Rate: instr.Rate,
 		Status: "Success",
-		TimeStamp : time.Now().Format("2006-01-02 15:04:05"),
+		TimeStamp : timeStamp,
 		}
 		fmt.Println("Transaction")
 		//clientID = trn.FromUser
@@ -574,63 +919,58 @@ func (t *SimpleChaincode) requestForIssue(stub shim.ChaincodeStubInterface, args
 		if err == nil {
 			err = stub.PutState(trn.TransactionID,b)
 			if err != nil {
-				_ = updateTransactionStatus(stub, transactionID, "Error while writing Transaction to ledger")
-				return nil, nil
+				return nil, newError(errLedger, "Error while writing Transaction to ledger")
 			}
 		} else {
-			_ = updateTransactionStatus(stub, transactionID, "Error while marshalling trade data")
-			return nil, nil
+			return nil, newError(errLedger, "Error while marshalling trade data")
 		}
 		fmt.Println("Transaction Updated")
 		bankByte, err := stub.GetState(args[2])
 		if err != nil {
-			return nil, errors.New("Unable to get Bank's data")
+			return nil, newError(errLedger, "Unable to get Bank's data")
 		}
 		var bank Entity
 		err = json.Unmarshal(bankByte,&bank)
 		if err != nil {
-			return nil, errors.New("Unable to unmarshal Bank's data")
+			return nil, newError(errLedger, "Unable to unmarshal Bank's data")
 		}
 		
 		// update currentTransactionNum
 		err = stub.PutState("currentTransactionNum", []byte(strconv.Itoa(tid)))
 		if err != nil {
-			_ = updateTransactionStatus(stub, transactionID, "Error while updating current transaction number")
-			return nil, nil
+			return nil, newError(errLedger, "Error while updating current transaction number")
 		}
 		
 		// add Transaction ID to entity's trade history
 		err = updateTradeHistory(stub, trn.ToUser, trn.TransactionID)
 		if err != nil {
-			_ = updateTransactionStatus(stub, transactionID, "Error while updating trade history")
-			return nil, nil
+			return nil, newError(errLedger, "Error while updating trade history")
 		}	
 		// add Transaction ID to entity's trade history
 		err = updateTradeHistory(stub, trn.FromUser, trn.TransactionID)
 		if err != nil {
-			_ = updateTransactionStatus(stub, transactionID, "Error while updating trade history for Issuer")
-			return nil, nil
+			return nil, newError(errLedger, "Error while updating trade history for Issuer")
 		}
 		fmt.Println("Instruent History" +trn.FromUser)
 		err = updateInstrumentHistory(stub, trn.ToUser, trn.Symbol)
 		if err != nil {
-			return nil, errors.New( "Error while updating Instrument History : Caller : "+trn.ToUser+" :"+trn.Symbol)
+			return nil, newError(errLedger,  "Error while updating Instrument History : Caller : "+trn.ToUser+" :"+trn.Symbol)
 		}
 		fmt.Println("Instruent History" +trn.FromUser)
 		err = t.updateInstrumentStatus(stub, trn.Symbol, args[2], status)
 		if err != nil {
-			return nil, errors.New( "Error while updating Instrument History : Caller : "+trn.ToUser+" :"+trn.Symbol)
+			return nil, newError(errLedger,  "Error while updating Instrument History : Caller : "+trn.ToUser+" :"+trn.Symbol)
 		}
 		fmt.Println("Instruent updateInstrumentStatus" +trn.TransactionID)
 		err = t.updateInstrumentTradeHistory(stub, trn.Symbol, trn.TransactionID)
 		if err != nil {
-			return nil, errors.New( "Error while updating Instrument Trade Histiry History : Caller : "+trn.TransactionID+" :"+trn.Symbol)
+			return nil, newError(errLedger,  "Error while updating Instrument Trade Histiry History : Caller : "+trn.TransactionID+" :"+trn.Symbol)
 		}
 		
 	 //} //For loop
 		return []byte(transactionID), nil
 	}
-	return nil, errors.New("Incorrect number of arguments")
+	return nil, newError(errArgumentCount, "Incorrect number of arguments")
 }
 /*			arg 0	:	Caller
 			arg 1	:	Instrument ID
@@ -645,36 +985,42 @@ func (t *SimpleChaincode) respondToIssue(stub shim.ChaincodeStubInterface, args
 		status := args[3]
 		instbyte, err := stub.GetState(symbol)
 		if err != nil {
-			return nil, errors.New("Instruent not found")
+			return nil, newError(errLedger, "Error while getting Instrument info from ledger")
+		}
+		if len(instbyte) == 0 {
+			return nil, newError(errNotFound, "Instruent not found", "symbol", symbol)
 		}
 		var inst Instrument
 		err= json.Unmarshal(instbyte, &inst)
 		if err != nil {
-			return nil, errors.New("Error in unmarshalling instruent ")
+			return nil, newError(errLedger, "Error in unmarshalling instruent ")
 		}
 		quoteID := inst.TradeID[len(inst.TradeID)-1]
 		
 		// get information from requestForIssue transaction
 		rfqbyte,err := stub.GetState(quoteID)												
 		if err != nil {
-			_ = updateTransactionStatus(stub, quoteID, "Error while reading quote request transaction from ledger")
-			return nil, nil
+			return nil, newError(errLedger, "Error while reading quote request transaction from ledger")
 		}
 		var rfq Transaction
 		err = json.Unmarshal(rfqbyte, &rfq)
 		if err != nil {
-			_ = updateTransactionStatus(stub, quoteID, "Error while unmarshalling quote request data")
-			return nil, nil
+			return nil, newError(errLedger, "Error while unmarshalling quote request data")
+		}
+		// the response copies the quoted price and rate
+		err = requireOpened(quoteID, rfq.Sealed)
+		if err != nil {
+			return nil, err
 		}
 
 		if response =="yes" {
 		ctidByte, err := stub.GetState("currentTransactionNum")
 		if(err != nil){
-			return nil, errors.New("Error while getting currentTransactionNum from ledger")
+			return nil, newError(errLedger, "Error while getting currentTransactionNum from ledger")
 		}
 		tid,err := strconv.Atoi(string(ctidByte))
 		if(err != nil){
-			return nil, errors.New("Error while converting ctidByte to integer")
+			return nil, newError(errLedger, "Error while converting ctidByte to integer")
 		}
 		tid = tid + 1
 		transactionID := "trans"+strconv.Itoa(tid)
@@ -682,13 +1028,11 @@ func (t *SimpleChaincode) respondToIssue(stub shim.ChaincodeStubInterface, args
 		// get bank's enrollment id
 		bytes, err := stub.GetCallerCertificate();
 		if err != nil {
-			_ = updateTransactionStatus(stub, transactionID, "Error while getting caller certificate")
-			return nil, nil
+			return nil, newError(errLedger, "Error while getting caller certificate")
 		}
 		x509Cert, err := x509.ParseCertificate(bytes);
 		if err != nil {
-			_ = updateTransactionStatus(stub, transactionID, "Error while parsing caller certificate")
-			return nil, nil
+			return nil, newError(errLedger, "Error while parsing caller certificate")
 		}		
 		fmt.Println("Respond to Issue : x509Cert"+x509Cert.Subject.CommonName)
 		caller1, err := t.get_username(stub)
@@ -696,8 +1040,7 @@ func (t *SimpleChaincode) respondToIssue(stub shim.ChaincodeStubInterface, args
 		
 		
 		if rfq.Symbol != symbol {
-			_ = updateTransactionStatus(stub, transactionID, "Error due to mismatch in tradeIDs")
-			return nil, nil
+			return nil, newError(errInvalidArgument, "Error due to mismatch in tradeIDs")
 		}		
 		fmt.Println("Respond to Issue : Quantity "+args[3])
 		
@@ -706,36 +1049,58 @@ func (t *SimpleChaincode) respondToIssue(stub shim.ChaincodeStubInterface, args
 		// check if required quantity is  under limit
 		instrumentByte,err := stub.GetState(rfq.Symbol)																											
 		if err != nil {
-			_ = updateTransactionStatus(stub, transactionID, "Error while getting Instrument info from ledger")
-			return nil, nil
+			return nil, newError(errLedger, "Error while getting Instrument info from ledger")
 		}
 		fmt.Println("Respond to Issue : Instrument Bytes"+string(instrumentByte))
 		var inst Instrument
 		err = json.Unmarshal(instrumentByte, &inst)
 		if err != nil {
-			_ = updateTransactionStatus(stub, transactionID, "Error while unmarshalling Instrument data")
-			return nil, nil
+			return nil, newError(errLedger, "Error while unmarshalling Instrument data")
 		}
 		fmt.Println("Respond to Issue : Instrument symbol"+inst.Symbol)
 		
 
 		fmt.Printf("Quantity Instrument :%g" ,inst.Quantity )
 		if quantity >inst.Quantity {
-		 return nil, errors.New("Response Quantity should be less or equal to requested")
+		 return nil, newError(errInvalidArgument, "Response Quantity should be less or equal to requested")
 		}
 		
 			
 		entityByte, err := stub.GetState(caller)
 		if err != nil {
-			return nil,errors.New("Instrument with this ID already Exists, Try a different Name")
+			return nil,newError(errLedger, "Error while getting entity info from ledger")
 			
 		}
+		if len(entityByte) == 0 {
+			return nil, newError(errNotFound, "Entity Not Found", "entity", caller)
+		}
 		var entity Entity
 		err = json.Unmarshal(entityByte, &entity)
 		if err != nil {
-			return nil, errors.New("Entity Not Found")
+			return nil, newError(errLedger, "Error while unmarshalling entity data")
 		}
 		
+		err = checkLimits(stub, caller, inst, quantity)
+		if err != nil {
+			return nil, err
+		}
+		err = checkMandate(stub, caller, inst)
+		if err != nil {
+			return nil, err
+		}
+		
+		// earmark the caller's cash, it moves when the trade is executed
+		commission := float64(quantity)*inst.InstrumentPrice*.001
+		price := float64(quantity)*inst.InstrumentPrice
+		holdID, err := placeHold(stub, caller, price - commission, holdResponse, transactionID)
+		if err != nil {
+				return nil, err
+		}
+		
+		timeStamp, err := txTimeStamp(stub)
+		if err != nil {
+			return nil, err
+		}
 		tr := Transaction {
 		TransactionID: transactionID,
 		TransactionType: status,
@@ -748,7 +1113,8 @@ func (t *SimpleChaincode) respondToIssue(stub shim.ChaincodeStubInterface, args
 		Rate: rfq.Rate,																// based on input
 		//SettlementDate: time.Date(year, month, day, 0, 0, 0, 0, time.UTC),				// based on input
 		Status: "Success",
-		TimeStamp : time.Now().Format("2006-01-02 15:04:05"),
+		TimeStamp : timeStamp,
+		HoldID: holdID,
 		}
 
 		// convert to JSON
@@ -758,67 +1124,54 @@ func (t *SimpleChaincode) respondToIssue(stub shim.ChaincodeStubInterface, args
 		if err == nil {
 			err = stub.PutState(tr.TransactionID,b)
 			if err != nil {
-				_ = updateTransactionStatus(stub, transactionID, "Error while writing Response transaction to ledger")
-				return nil, nil
+				return nil, newError(errLedger, "Error while writing Response transaction to ledger")
 			}
 		} else {
-			_ = updateTransactionStatus(stub, transactionID, "Error while marshalling transaction data")
-			return nil, nil
+			return nil, newError(errLedger, "Error while marshalling transaction data")
 		}
 		
 		err = stub.PutState("currentTransactionNum", []byte(strconv.Itoa(tid)))
 		if err != nil {
-			_ = updateTransactionStatus(stub, transactionID, "Error while writing current Transaction Number to ledger")
-			return nil, nil
+			return nil, newError(errLedger, "Error while writing current Transaction Number to ledger")
 		}
 		
 		inst.QuantityResponded = quantity
-		commission := float64(quantity)*inst.InstrumentPrice*.001
-		price := float64(quantity)*inst.InstrumentPrice
-		err = t.updateEntityBalance(stub,caller, -1*(price - commission))   //Caller
-		if err != nil {
-				return nil, errors.New(err.Error())
-		}
-		err = t.updateEntityBalance(stub,rfq.FromUser, (price - commission))   //Client
-		if err != nil {
-				return nil, errors.New(err.Error())
-		}
 		b, err = json.Marshal(inst)
 		err = stub.PutState(inst.Symbol,b)
 		if err != nil{
-			return nil, errors.New("Unable to update Instrument Responded Quantity "+err.Error())
+			return nil, newError(errLedger, "Unable to update Instrument Responded Quantity "+err.Error())
 		}
 		
 		err = t.updateInstrumentStatus(stub, args[1],rfq.ToUser,status)
 		if err != nil{
-		 return nil,errors.New("Unable to update Instruent Status")
+		 return nil,newError(errLedger, "Unable to update Instruent Status")
 		}
 		// add Transaction ID to entity's trade history
 		err = updateTradeHistory(stub, tr.ToUser, tr.TransactionID)
 		if err != nil {
-			_ = updateTransactionStatus(stub, transactionID, "Error while updating trade history")
-			return nil, nil
+			return nil, newError(errLedger, "Error while updating trade history")
 		}	
 		// add Transaction ID to entity's trade history
 		err = updateTradeHistory(stub, tr.FromUser, tr.TransactionID)
 		if err != nil {
-			_ = updateTransactionStatus(stub, transactionID, "Error while updating trade history for Issuer")
-			return nil, nil
+			return nil, newError(errLedger, "Error while updating trade history for Issuer")
 		}
 		fmt.Println("Instruent updateInstrumentStatus" +tr.TransactionID)
 		err = t.updateInstrumentTradeHistory(stub, tr.Symbol, tr.TransactionID)
 		if err != nil {
-			return nil, errors.New( "Error while updating Instrument Trade Histiry History : Caller : "+tr.TransactionID+" :"+tr.Symbol)
+			return nil, newError(errLedger,  "Error while updating Instrument Trade Histiry History : Caller : "+tr.TransactionID+" :"+tr.Symbol)
 		}
+		emitEvent(stub, MarketEvent{Type: eventResponseReceived, Symbol: tr.Symbol, TransactionID: tr.TransactionID, Status: status, Parties: []string{tr.FromUser, tr.ToUser}})
 		return nil, nil
 	}	else{  // not accepted
 		err := t.updateInstrumentStatus(stub, args[1],rfq.FromUser,status)
 		if err != nil{
-		 return nil,errors.New("Unable to update Instruent Status")
+		 return nil,newError(errLedger, "Unable to update Instruent Status")
 		}
+		return nil, nil
 	}
 	}
-	return nil, errors.New("Incorrect number of arguments")
+	return nil, newError(errArgumentCount, "Incorrect number of arguments")
 }
 /*			arg 0	:	TradeID
 			arg 1	:	Selected quote's TransactionID
@@ -829,11 +1182,11 @@ func (t *SimpleChaincode) tradeExec(stub shim.ChaincodeStubInterface, args []str
 		caller := args[0]
 		ctidByte, err := stub.GetState("currentTransactionNum")
 		if err != nil {
-			return nil, errors.New("Error while getting current Transaction Number from ledger")
+			return nil, newError(errLedger, "Error while getting current Transaction Number from ledger")
 		}		
 		tid,err := strconv.Atoi(string(ctidByte))
 		if err != nil {
-			return nil, errors.New("Error while converting ctidByte to integer")
+			return nil, newError(errLedger, "Error while converting ctidByte to integer")
 		}
 		tid = tid + 1
 		transactionID := "trans"+strconv.Itoa(tid)
@@ -846,32 +1199,36 @@ func (t *SimpleChaincode) tradeExec(stub shim.ChaincodeStubInterface, args []str
 		// get client's enrollment id
 		bytes, err := stub.GetCallerCertificate();
 		if err != nil {
-			_ = updateTransactionStatus(stub, transactionID, "Error while getting caller certificate")
-			return nil, nil
+			return nil, newError(errLedger, "Error while getting caller certificate")
 		}
 		x509Cert, err := x509.ParseCertificate(bytes);
 		if err != nil {
-			_ = updateTransactionStatus(stub, transactionID, "Error while parsing caller certificate :" + x509Cert.Subject.CommonName)
-			return nil, nil
+			return nil, newError(errLedger, "Error while parsing caller certificate")
 		}
 		fmt.Println("Current x509Cert No :"+x509Cert.Subject.CommonName + quoteId)
 		// get information from selected quote
 		quotebyte,err := stub.GetState(quoteId)
 		if err != nil {
-			_ = updateTransactionStatus(stub, transactionID, "Error while getting quote data")
-			return nil, nil
+			return nil, newError(errLedger, "Error while getting quote data")
 		}
 		fmt.Println("Quote  Id   :"+string(quotebyte))
 		var quote Transaction
 		err = json.Unmarshal(quotebyte, &quote)		
 		if err != nil {
-			_ = updateTransactionStatus(stub, transactionID, "Error while unmarshalling quote data")
-			return nil, nil
+			return nil, newError(errLedger, "Error while unmarshalling quote data")
 		}
 		fmt.Println("Trade  ID   :"+quote.TradeID +"-"+ tradeID)
 		if quote.TradeID != tradeID {
-			_ = updateTransactionStatus(stub, transactionID, "Error due to mismatch in tradeIDs")	
-			return nil, nil
+			return nil, newError(errInvalidArgument, "Error due to mismatch in tradeIDs")
+		}
+		// a response whose hold was cancelled or timed out has no cash behind it
+		if quote.Status == "Cancelled" || quote.Status == "Expired" {
+			return nil, newError(errInvalidState, "Response " + quoteId + " is " + quote.Status)
+		}
+		// executing or cancelling copies the quoted price and rate
+		err = requireOpened(quoteId, quote.Sealed)
+		if err != nil {
+			return nil, err
 		}
 		fmt.Println("Quote Trade Id   :"+tradeID)
 
@@ -880,22 +1237,31 @@ func (t *SimpleChaincode) tradeExec(stub shim.ChaincodeStubInterface, args []str
 		if strings.ToLower(args[3]) == "yes" {
 			tExec := quote
 			if tExec.TradeID != tradeID {
-				_ = updateTransactionStatus(stub, transactionID, "Error due to mismatch in tradeIDs")
-				return nil, nil
+				return nil, newError(errInvalidArgument, "Error due to mismatch in tradeIDs")
 			}
 			
 			instByte, err := stub.GetState(tExec.Symbol)
 			if err != nil {
-				return nil, errors.New("Error pulling Instrument state")
+				return nil, newError(errLedger, "Error pulling Instrument state")
 			}
 			var inst Instrument
 			err = json.Unmarshal(instByte, &inst)
 			if err != nil {
-				return nil, errors.New("Error unmarshalling Instrument state")
+				return nil, newError(errLedger, "Error unmarshalling Instrument state")
+			}
+
+			// the buyer takes the position onto its book
+			err = checkLimits(stub, tExec.ToUser, inst, tExec.Quantity)
+			if err != nil {
+				return nil, err
 			}
 
 			// check settlement date to see if instrument is still valid
 				
+				timeStamp, err := txTimeStamp(stub)
+				if err != nil {
+					return nil, err
+				}
 				t := Transaction{
 				TransactionID: transactionID,
 				TradeID: tradeID,							// based on input
@@ -908,6 +1274,15 @@ func (t *SimpleChaincode) tradeExec(stub shim.ChaincodeStubInterface, args []str
 				InstrumentPrice: tExec.InstrumentPrice,				// get from tradeExec transaction
 				Rate: tExec.Rate,					// get from tradeExec transaction
 				Status: "Success",
+				TimeStamp: timeStamp,
+				}
+				tradeDate, err := txTime(stub)
+				if err != nil {
+					return nil, err
+				}
+				t.SettlementDate, err = tradeSettlementDate(stub, inst, tradeDate)
+				if err != nil {
+					return nil, err
 				}
 				// convert to JSON
 				b1, err := json.Marshal(t)
@@ -915,38 +1290,32 @@ func (t *SimpleChaincode) tradeExec(stub shim.ChaincodeStubInterface, args []str
 				if err == nil {
 					err = stub.PutState(t.TransactionID,b1)
 					if err != nil {
-						_ = updateTransactionStatus(stub, transactionID, "Error while writing Response transaction to ledger")
-						return nil, nil
+						return nil, newError(errLedger, "Error while writing Response transaction to ledger")
 					}
 				} else {
-					_ = updateTransactionStatus(stub, transactionID, "Error while marshalling transaction data")
-					return nil, nil
+					return nil, newError(errLedger, "Error while marshalling transaction data")
 				}
 				
 						// update client entity's instruments
 		clientbyte,err := stub.GetState(t.FromUser)																										
 		if err != nil {
-			_ = updateTransactionStatus(stub, transactionID, "Error while getting client info from ledger")
-			return nil, nil
+			return nil, newError(errLedger, "Error while getting client info from ledger")
 		}
 		var client Entity
 		err = json.Unmarshal(clientbyte, &client)		
 		if err != nil {
-			_ = updateTransactionStatus(stub, transactionID, "Error while unmarshalling client data")
-			return nil, nil
+			return nil, newError(errLedger, "Error while unmarshalling client data")
 		}
 		
 		
 		bankbyte,err := stub.GetState(t.ToUser)																										
 		if err != nil {
-			_ = updateTransactionStatus(stub, transactionID, "Error while getting bank information from ledger")
-			return nil, nil
+			return nil, newError(errLedger, "Error while getting bank information from ledger")
 		}
 		var bank Entity
 		err = json.Unmarshal(bankbyte, &bank)		
 		if err != nil {
-			_ = updateTransactionStatus(stub, transactionID, "Error while unmarshalling bank data")
-			return nil, nil
+			return nil, newError(errLedger, "Error while unmarshalling bank data")
 		}
 		
 		fmt.Println("Bank and Client ID received")
@@ -992,8 +1361,7 @@ func (t *SimpleChaincode) tradeExec(stub shim.ChaincodeStubInterface, args []str
 				// updating trade state
 				err = updateTradeState(stub, t.TradeID, t.TransactionID,"Trade Executed")
 				if err != nil {
-					_ = updateTransactionStatus(stub, transactionID, "Error while updating trade state")
-					return nil, nil
+					return nil, newError(errLedger, "Error while updating trade state")
 				}
 				
 		// update client state
@@ -1001,37 +1369,73 @@ func (t *SimpleChaincode) tradeExec(stub shim.ChaincodeStubInterface, args []str
 		if err == nil {
 			err = stub.PutState(client.EntityID,b)
 		} else {
-			_ = updateTransactionStatus(stub, transactionID, "Error updating Client state")
-			return nil, nil
+			return nil, newError(errLedger, "Error updating Client state")
+		}
+		if err != nil {
+			return nil, newError(errLedger, "Error while writing Client state to ledger")
 		}
 		// update bank state
 		b, err = json.Marshal(bank)
 		if err == nil {
 			err = stub.PutState(bank.EntityID,b)
 		} else {
-			_ = updateTransactionStatus(stub, transactionID, "Error while updating Bank state")
-			return nil, nil
+			return nil, newError(errLedger, "Error while updating Bank state")
+		}
+		if err != nil {
+			return nil, newError(errLedger, "Error while writing Bank state to ledger")
+		}
+		// settle the cash earmarked by the response
+		if quote.HoldID != "" {
+			err = consumeHold(stub, quote.HoldID, quote.ToUser, "Trade Settlement", transactionID)
+			if err != nil {
+				return nil, err
+			}
 		}
 		// update transaction number
 		err = stub.PutState("currentTransactionNum", []byte(strconv.Itoa(tid)))
 		if err != nil {
-			_ = updateTransactionStatus(stub, transactionID, "Error while writing currentTransactionNum to ledger")
-			return nil, nil
+			return nil, newError(errLedger, "Error while writing currentTransactionNum to ledger")
 		}		
+		emitEvent(stub, MarketEvent{Type: eventTradeExecuted, Symbol: t.Symbol, TradeID: tradeID, TransactionID: transactionID, SettlementDate: t.SettlementDate, Parties: []string{t.FromUser, t.ToUser}})
 
 		} else {	// trade cancelled
-			_ = updateTransactionStatus(stub, transactionID, "")
+			if quote.HoldID != "" {
+				err = releaseHold(stub, quote.HoldID, "Released")
+				if err != nil {
+					return nil, err
+				}
+			}
+			// the cancellation is kept as a transaction so it shows in the regulator's reports
+			err = recordTransaction(stub, Transaction{
+				TransactionID: transactionID,
+				TradeID: tradeID,
+				TransactionType: "Cancel",
+				FromUser: caller,
+				ToUser: quote.FromUser,
+				Symbol: quote.Symbol,
+				Quantity: quote.Quantity,
+				InstrumentPrice: quote.InstrumentPrice,
+				Rate: quote.Rate,
+				Status: "Cancelled",
+			})
+			if err != nil {
+				return nil, err
+			}
+			err = stub.PutState("currentTransactionNum", []byte(strconv.Itoa(tid)))
+			if err != nil {
+				return nil, newError(errLedger, "Error while writing currentTransactionNum to ledger")
+			}
 			// updating trade state
 			err = updateTradeState(stub, tradeID,"" ,"Trade Cancelled")
 			if err != nil {
-				_ = updateTransactionStatus(stub, transactionID, "Error while updating trade state")
-				return nil, nil
+				return nil, newError(errLedger, "Error while updating trade state")
 			}
+			emitEvent(stub, MarketEvent{Type: eventTradeCancelled, Symbol: quote.Symbol, TradeID: tradeID, TransactionID: transactionID, Parties: []string{caller, quote.FromUser}})
 		}
 	
 		return nil, nil
 	}
-	return nil, errors.New("Incorrect number of arguments")
+	return nil, newError(errArgumentCount, "Incorrect number of arguments")
 }
 /*			arg 0	:	TradeID
 			arg 1	:	Yes/ No
@@ -1059,69 +1463,63 @@ return nil, nil
 		
 		bytes, err := stub.GetCallerCertificate();
 		if err != nil {
-			_ = updateTransactionStatus(stub, transactionID, "Error while getting caller certificate")
-			return nil, nil
+			return nil, errors.New("Error while getting caller certificate")
 		}
 		x509Cert, err := x509.ParseCertificate(bytes);
 		if err != nil {
-			_ = updateTransactionStatus(stub, transactionID, "Error while parsing caller certificate :" +x509Cert.Subject.CommonName)
-			return nil, nil
+			return nil, errors.New("Error while parsing caller certificate :" +x509Cert.Subject.CommonName)
 		}
 		clientID := caller //x509Cert.Subject.CommonName
 		
 		// update client entity's instruments
 		clientbyte,err := stub.GetState(clientID)																												
 		if err != nil {
-			_ = updateTransactionStatus(stub, transactionID, "Error while getting client info from ledger")
-			return nil, nil
+			return nil, errors.New("Error while getting client info from ledger")
 		}
 		var client Entity
 		err = json.Unmarshal(clientbyte, &client)		
 		if err != nil {
-			_ = updateTransactionStatus(stub, transactionID, "Error while unmarshalling client data")
-			return nil, nil
+			return nil, errors.New("Error while unmarshalling client data")
 		}
 		// remove instrument from clients data, check tradeID
 
 		// get transactionID from tradeID
 		tradebyte,err := stub.GetState(tradeID)
 		if err != nil {
-			_ = updateTransactionStatus(stub, transactionID, "Error while getting trade info from ledger")
-			return nil, nil
+			return nil, errors.New("Error while getting trade info from ledger")
 		}
 		fmt.Println (" Transaction ID :" + tExecId)
 		// get information from trade exec transaction
 		tbyte,err := stub.GetState(tExecId)												
 		if err != nil {
-			_ = updateTransactionStatus(stub, transactionID, "Error while getting tradeExec transaction from ledger")
-			return nil, nil
+			return nil, errors.New("Error while getting tradeExec transaction from ledger")
 		}
 		
 		var tExec Transaction
 		err = json.Unmarshal(tbyte, &tExec)		
 		if err != nil {
-			_ = updateTransactionStatus(stub, transactionID, "Error while unmarshalling tradeExec data")
-			return nil, nil
+			return nil, errors.New("Error while unmarshalling tradeExec data")
+		}
+		err = requireOpened(tExecId, tExec.Sealed)
+		if err != nil {
+			return nil, err
 		}
 		
 		// update bank entity's instruments
 		bankbyte,err := stub.GetState(tExec.ToUser)																											
 		if err != nil {
-			_ = updateTransactionStatus(stub, transactionID, "Error while getting bank info from ledger")
-			return nil, nil
+			return nil, errors.New("Error while getting bank info from ledger")
 		}
 		var bank Entity
 		err = json.Unmarshal(bankbyte, &bank)		
 		if err != nil {
-			_ = updateTransactionStatus(stub, transactionID, "Error while unmarshalling bank data")
-			return nil, nil
+			return nil, errors.New("Error while unmarshalling bank data")
 		}
 		// remove instrument from bank 
 
 		// check if trade has to be settled
 			if tExec.TradeID != tradeID {
-				_ = updateTransactionStatus(stub, transactionID, "Error due to mismatch in tradeIDs")
-				return nil, nil
+				return nil, errors.New("Error due to mismatch in tradeIDs")
 			}
 			fmt.Println (" Trade ID and Execution Trade Id "+ tExec.TradeID +"-"+ tradeID)
 			// check settlement date to see if instrument is still valid
@@ -1145,12 +1543,10 @@ return nil, nil
 				if err == nil {
 					err = stub.PutState(t.TransactionID,b1)
 					if err != nil {
-						_ = updateTransactionStatus(stub, transactionID, "Error while writing Response transaction to ledger")
-						return nil, nil
+						return nil, errors.New("Error while writing Response transaction to ledger")
 					}
 				} else {
-					_ = updateTransactionStatus(stub, transactionID, "Error while marshalling transaction data")
-					return nil, nil
+					return nil, errors.New("Error while marshalling transaction data")
 				}
 				
 				// add stock to clients portfolio, check if stock already exists if yes increase quantity else create new stock entry 		
@@ -1177,8 +1573,7 @@ return nil, nil
 				// updating trade state
 				err = updateTradeState(stub, t.TradeID, t.TransactionID,"Trade Settled")
 				if err != nil {
-					_ = updateTransactionStatus(stub, transactionID, "Error while updating trade state")
-					return nil, nil
+					return nil, errors.New("Error while updating trade state")
 				}
 				
 
@@ -1187,22 +1582,19 @@ return nil, nil
 		if err == nil {
 			err = stub.PutState(client.EntityID,b)
 		} else {
-			_ = updateTransactionStatus(stub, transactionID, "Error updating Client state")
-			return nil, nil
+			return nil, errors.New("Error updating Client state")
 		}
 		// update bank state
 		b, err = json.Marshal(bank)
 		if err == nil {
 			err = stub.PutState(bank.EntityID,b)
 		} else {
-			_ = updateTransactionStatus(stub, transactionID, "Error while updating Bank state")
-			return nil, nil
+			return nil, errors.New("Error while updating Bank state")
 		}
 		// update transaction number
 		err = stub.PutState("currentTransactionNum", []byte(strconv.Itoa(tid)))
 		if err != nil {
-			_ = updateTransactionStatus(stub, transactionID, "Error while writing currentTransactionNum to ledger")
-			return nil, nil
+			return nil, errors.New("Error while writing currentTransactionNum to ledger")
 		}
 		return nil, nil
 	}
@@ -1219,14 +1611,14 @@ func (t *SimpleChaincode) getUserID(stub shim.ChaincodeStubInterface, args []str
 func (t *SimpleChaincode) getcurrentTransactionNum(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
 	ctidByte,err := stub.GetState("currentTransactionNum")
 	if err != nil {
-		return nil, errors.New("Error retrieving currentTransactionNum")
+		return nil, newError(errLedger, "Error retrieving currentTransactionNum")
 	}
     return ctidByte, err
 }
 func (t *SimpleChaincode) getValue(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
 	byteVal,err := stub.GetState(args[0])
 	if err != nil {
-		return []byte(err.Error()), errors.New("Error retrieving key "+args[0])
+		return []byte(err.Error()), newError(errLedger, "Error retrieving key "+args[0])
 	}
 	if len(byteVal) == 0 {
 		return []byte("Len is zero"), nil
@@ -1239,77 +1631,34 @@ func (t *SimpleChaincode) readTradeIDsOfUser(stub shim.ChaincodeStubInterface, a
 		// read entity state
 		entitybyte,err := stub.GetState(args[0])																									
 		if err != nil {
-			return nil, errors.New("Error while getting entity info from ledger")
+			return nil, newError(errLedger, "Error while getting entity info from ledger")
 		}
 		var entity Entity
 		err = json.Unmarshal(entitybyte, &entity)		
 		if(err != nil){
-			return nil, errors.New("Error while unmarshalling entity data")
+			return nil, newError(errLedger, "Error while unmarshalling entity data")
+		}
+		tradeHistory, err := getIndexIDs(stub, indexEntityTrade, entity.EntityID)
+		if err != nil {
+			return nil, err
 		}
 
-		b, err := json.Marshal(entity.TradeHistory)
+		b, err := json.Marshal(tradeHistory)
 		if err != nil {
-			return nil, errors.New("Error while marshalling trade history")
+			return nil, newError(errLedger, "Error while marshalling trade history")
 		}
 		return b, nil
 	}
-	return nil, errors.New("Incorrect number of arguments")
+	return nil, newError(errArgumentCount, "Incorrect number of arguments")
 }
 func updateTradeHistory(stub shim.ChaincodeStubInterface, entityID string, tradeID string) (error) {
-	// read entity state
-	entitybyte,err := stub.GetState(entityID)																										
-	if err != nil {
-		return errors.New("Error while getting entity info from ledger")
-	}
-	var entity Entity
-	err = json.Unmarshal(entitybyte, &entity)		
-	if err != nil {
-		return errors.New("Error while unmarshalling entity data")
-	}
-	// add tradeID to history
-	entity.TradeHistory = append(entity.TradeHistory,tradeID)
-	// write entity state to ledger
-	b, err := json.Marshal(entity)
-	if err == nil {
-		err = stub.PutState(entity.EntityID,b)
-	} else {
-		return errors.New("Error while updating entity status")
-	}
-	return nil
+	// add tradeID to history, the entity record is not rewritten
+	return addToIndex(stub, indexEntityTrade, entityID, tradeID)
 }
 
 func updateInstrumentHistory(stub shim.ChaincodeStubInterface, entityID string, issueID string) (error) {
-	// read entity state
-	entitybyte,err := stub.GetState(entityID)																										
-	if err != nil {
-		return errors.New("Error while getting entity info from ledger")
-	}
-	
-	var entity Entity
-	err = json.Unmarshal(entitybyte, &entity)		
-	if err != nil {
-		return errors.New("Error while unmarshalling entity data")
-	}
-	// add tradeID to history
-	//entity.Instruments = append(entity.Instruments,issueID)
-	newInstrumeentList := []string{}
-	
-	for _, v := range entity.Instruments {
-			if v != issueID {
-		newInstrumeentList = append(newInstrumeentList, v)
-			}
-		}
-	newInstrumeentList = append(newInstrumeentList,issueID)
-	entity.Instruments = newInstrumeentList
-	
-	// write entity state to ledger
-	b, err := json.Marshal(entity)
-	if err == nil {
-		err = stub.PutState(entity.EntityID,b)
-	} else {
-		return errors.New("Error while updating entity status")
-	}
-	return nil
+	// add issueID to the entity's instruments, adding it twice keeps a single entry
+	return addToIndex(stub, indexEntityInstrument, entityID, issueID)
 }
 
 
@@ -1344,7 +1693,7 @@ func updateTradeState(stub shim.ChaincodeStubInterface, tradeID string, transact
 }
 
 func (t *SimpleChaincode) trial(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
-	return nil, errors.New("********* TRIAL ERROR *********")
+	return nil, newError(errLedger, "********* TRIAL ERROR *********")
 }
 
 /* error handling
@@ -1360,36 +1709,53 @@ dont increment transaction number or trade number
 dont include transaction in trade history
 */
 // read trades of a client
+/*
+	args 0 : Entity
+	args 1 : List options (JSON, optional)
+*/
 func (t *SimpleChaincode) readTrades(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
-	if len(args)== 1 {
+	if len(args) == 1 || len(args) == 2 {
 		// read entity state
 		entitybyte,err := stub.GetState(args[0])																									
 		if err != nil {
-			return nil, errors.New("Error while getting entity info from ledger")
+			return nil, newError(errLedger, "Error while getting entity info from ledger")
 		}
 		var entity Entity
 		err = json.Unmarshal(entitybyte, &entity)		
 		if(err != nil){
-			return nil, errors.New("Error while unmarshalling entity data")
+			return nil, newError(errLedger, "Error while unmarshalling entity data")
 		}
-		trades := make([]Trade,len(entity.TradeHistory))
-		for i:=0; i<len(entity.TradeHistory); i++ {
-			byteVal,err := stub.GetState(entity.TradeHistory[i])
+		opts, err := parseListOptions(args, 1)
+		if err != nil {
+			return nil, err
+		}
+		tradeHistory, err := getIndexIDs(stub, indexEntityTrade, entity.EntityID)
+		if err != nil {
+			return nil, err
+		}
+		trades := []Trade{}
+		bookmark, err := collectPage(tradeHistory, opts, func(tradeID string) (bool, error) {
+			byteVal,err := stub.GetState(tradeID)
 			if err != nil {
-				return nil, errors.New("Error while getting trades info from ledger")
+				return false, newError(errLedger, "Error while getting trades info from ledger")
 			}
-			err = json.Unmarshal(byteVal, &trades[i])	
+			var trade Trade
+			err = json.Unmarshal(byteVal, &trade)	
 			if err != nil {
-				return nil, errors.New("Error while unmarshalling trades")
-			}	
-		}
-		b, err := json.Marshal(trades)
+				return false, newError(errLedger, "Error while unmarshalling trades")
+			}
+			if !opts.matchStatus(trade.Status) || !opts.matchSymbol(trade.Symbol) {
+				return false, nil
+			}
+			trades = append(trades, trade)
+			return true, nil
+		})
 		if err != nil {
-			return nil, errors.New("Error while marshalling trades")
+			return nil, err
 		}
-		return b, nil
+		return listResponse(trades, len(trades), bookmark, opts)
 	}
-	return nil, errors.New("Incorrect number of arguments")
+	return nil, newError(errArgumentCount, "Incorrect number of arguments")
 }
 func (t *SimpleChaincode) readIssueRequests(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
   return nil, nil
@@ -1453,48 +1819,29 @@ func (t *SimpleChaincode) readIssueRequests(stub shim.ChaincodeStubInterface, ar
 	*/
 }
 
-func updateTransactionStatus(stub shim.ChaincodeStubInterface, transactionID string, status string) (error) {
-		//Transaction
-		t := Transaction{
-		TransactionID: transactionID,
-		Status: status,
-		}
-		// convert to Transaction to JSON
-		b, err := json.Marshal(t)
-		// write to ledger
-		if err == nil {
-			err = stub.PutState(t.TransactionID,b)
-			if(err != nil){
-				return errors.New("Error while writing Transaction to ledger")
-			}
-		} else {
-			return errors.New("Json Marshalling error")
-		}
-		return nil
-}
 func (t *SimpleChaincode) getEntityList(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
 	var allEntities []string
 	var entities []string
 	// get current Trade number
 	ctidByte, err := stub.GetState("entityList")
 	if(err != nil){
-		return nil, errors.New("Error while getting entity list from ledger")
+		return nil, newError(errLedger, "Error while getting entity list from ledger")
 	}
 	err = json.Unmarshal(ctidByte, &allEntities)		
 	if(err != nil){
-		return nil, errors.New("Error while unmarshalling entity data")
+		return nil, newError(errLedger, "Error while unmarshalling entity data")
 	}
 	// check all entities
 	for i:=0; i< len(allEntities); i++ {
 		// read trade state
 		entityByte,err := stub.GetState(allEntities[i])
 		if err != nil {
-			return nil, errors.New("Error while getting entity info from ledger")
+			return nil, newError(errLedger, "Error while getting entity info from ledger")
 		}
 		var entity Entity
 		err = json.Unmarshal(entityByte, &entity)		
 		if err != nil {
-			return nil, errors.New("Error while unmarshalling entity data")
+			return nil, newError(errLedger, "Error while unmarshalling entity data")
 		}
 		// check type
 		if entity.EntityType == "Issuer" || entity.EntityType == "Bank" || entity.EntityType == "Investor"{
@@ -1504,61 +1851,64 @@ func (t *SimpleChaincode) getEntityList(stub shim.ChaincodeStubInterface, args [
 	b, err := json.Marshal(entities)
 	return b, nil
 }
+/*
+	args 0 : List options (JSON, optional)
+*/
 func (t *SimpleChaincode) getEntities(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
 	var allEntities []string
+	opts, err := parseListOptions(args, 0)
+	if err != nil {
+		return nil, err
+	}
 	//var entities []string
 	// get current Trade number
 	ctidByte, err := stub.GetState("entityList")
 	if(err != nil){
-		return nil, errors.New("Error while getting entity list from ledger")
+		return nil, newError(errLedger, "Error while getting entity list from ledger")
 	}
 	err = json.Unmarshal(ctidByte, &allEntities)		
 	if(err != nil){
-		return nil, errors.New("Error while unmarshalling entity data")
+		return nil, newError(errLedger, "Error while unmarshalling entity data")
 	}
 	// check all entities
-	entities := make([]Entity,len(allEntities))
-
-	for i:=0; i< len(allEntities); i++ {
-		// read trade state
-		entityByte,err := stub.GetState(allEntities[i])
+	entities := []Entity{}
+	bookmark, err := collectPage(allEntities, opts, func(entityID string) (bool, error) {
+		entity, err := getEntityState(stub, entityID)
 		if err != nil {
-			return nil, errors.New("Error while getting entity info from ledger")
+			return false, err
 		}
-		//var entity Entity
-		err = json.Unmarshal(entityByte, &entities[i])		
-		if err != nil {
-			return nil, errors.New("Error while unmarshalling entity data")
+		if opts.EntityType != "" && entity.EntityType != opts.EntityType {
+			return false, nil
 		}
-		
+		entities = append(entities, entity)
+		return true, nil
+	})
+	if err != nil {
+		return nil, err
 	}
-	b, err := json.Marshal(entities)
-		if err != nil {
-			return nil, errors.New("Error while marshalling entities")
-		}
-	return b, nil
+	return listResponse(entities, len(entities), bookmark, opts)
 }
 func (t *SimpleChaincode) getAllTrades(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
 	// check entity type
 	entitybyte,err := stub.GetState(args[0])																									
 	if err != nil {
-		return nil, errors.New("Error while getting entity info from ledger")
+		return nil, newError(errLedger, "Error while getting entity info from ledger")
 	}
 	var entity Entity
 	err = json.Unmarshal(entitybyte, &entity)		
 	if err != nil {
-		return nil, errors.New("Error while unmarshalling entity data")
+		return nil, newError(errLedger, "Error while unmarshalling entity data")
 	}
 	if entity.EntityType == "RegBody" {		
 			var tradeList []string
 			// get current Trade number
 			ctidByte, err := stub.GetState("currentTradeNum")
 			if err != nil {
-				return nil, errors.New("Error while getting currentTradeNum from ledger")
+				return nil, newError(errLedger, "Error while getting currentTradeNum from ledger")
 			}
 			tradeNum,err := strconv.Atoi(string(ctidByte))
 			if err != nil {
-				return nil, errors.New("Error while converting ctidByte to integer")
+				return nil, newError(errLedger, "Error while converting ctidByte to integer")
 			}
 			for tradeNum > 1000 {
 					tradeList = append(tradeList,"trade"+strconv.Itoa(tradeNum))
@@ -1568,36 +1918,20 @@ func (t *SimpleChaincode) getAllTrades(stub shim.ChaincodeStubInterface, args []
 			for i:=0; i<len(tradeList); i++ {
 				byteVal,err := stub.GetState(tradeList[i])
 				if err != nil {
-					return nil, errors.New("Error while getting trades info from ledger")
+					return nil, newError(errLedger, "Error while getting trades info from ledger")
 				}
 				err = json.Unmarshal(byteVal, &trades[i])	
 				if err != nil {
-					return nil, errors.New("Error while unmarshalling trades")
+					return nil, newError(errLedger, "Error while unmarshalling trades")
 				}
 			}
 			b, err := json.Marshal(trades)
 			if err != nil {
-				return nil, errors.New("Error while marshalling trades")
+				return nil, newError(errLedger, "Error while marshalling trades")
 			}
 			return b, nil
 	} 
-	return nil, errors.New("Error only Regulatory Body can access all trades")
-}
-func (t *SimpleChaincode) getTransactionStatus(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
-		if len(args)== 1 {
-				transactionID := "trans"+args[0]
-				tbyte,err := stub.GetState(transactionID)
-				if err != nil {
-					return []byte("Error while getting transaction from ledger to get transaction status of "+transactionID), nil
-				}
-				var transaction Transaction
-				err = json.Unmarshal(tbyte, &transaction)
-				if err != nil {
-					return []byte("Error while unmarshalling transaction data to get transaction status of "+transactionID), nil
-				}
-				return []byte(transaction.Status),nil
-		}
-		return nil, errors.New("Incorrect number of arguments")
+	return nil, newError(errNotAuthorized, "Error only Regulatory Body can access all trades")
 }
 
 // User by Issuer to Create new Issue in the Ledger
@@ -1606,29 +1940,39 @@ func (t *SimpleChaincode) getTransactionStatus(stub shim.ChaincodeStubInterface,
 			arg 2	:	Coupon
 			arg 3 	:	Rate
 			arg 4	:	Price
-			arg 5	:	Maturity date
-			arg	6	:	Issue Date
+			arg 5	:	Maturity date (YYYY-MM-DD)
+			arg	6	:	Issue Date (YYYY-MM-DD)
 			arg 7	:	Callable
-
+			arg 8	:	Market of the holiday calendar (optional)
+			arg 9	:	Business day convention (optional, Unadjusted when omitted)
+			arg 10	:	Settlement days (optional, 0 when omitted)
 */
 func (t *SimpleChaincode) createIssue(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
 	//Need all parameters for the Bond Instrument
-	if len(args)== 8{
+	if len(args) >= 8 && len(args) <= 11 {
 		caller := args[0]
-		// Check if the IOI Id already exists
+		// Check if the IOI Id exists
 		Ioibyte, err := stub.GetState(args[1])
 		if err != nil {
-			return nil, errors.New("Instrument with this ID already Exists, Try a different Name")
+			return nil, newError(errLedger, "Error while getting IOI record from ledger")
+		}
+		if len(Ioibyte) == 0 {
+			return nil, newError(errNotFound, "IOI not found", "ioi", args[1])
 		}
 		var vioi Ioi
 		err = json.Unmarshal(Ioibyte, &vioi)
 		if err != nil{
-			return nil, errors.New("Error while unmarshalling IOI record")
+			return nil, newError(errLedger, "Error while unmarshalling IOI record")
+		}
+		// the quantity issued is worked out from the notional
+		err = requireOpened(vioi.IoiId, vioi.Sealed)
+		if err != nil {
+			return nil, err
 		}
 		
 		p,err := strconv.ParseFloat(args[4],64)  // Price
 		if err != nil {
-			return nil,errors.New( "Error while converting Price to integer")
+			return nil,newError(errLedger,  "Error while converting Price to integer")
 			
 		}
 		issuer := vioi.Owner
@@ -1637,17 +1981,25 @@ func (t *SimpleChaincode) createIssue(stub shim.ChaincodeStubInterface, args []s
 
 		r,err := strconv.ParseFloat(args[3],64)  // Rate
 		if err != nil {
-			return nil,errors.New( "Error while converting Rate to integer")
+			return nil,newError(errLedger,  "Error while converting Rate to integer")
 		
 		}
+		maturityDate, err := normalizeDate(args[5])
+		if err != nil {
+			return nil, err
+		}
+		issueDate, err := normalizeDate(args[6])
+		if err != nil {
+			return nil, err
+		}
 
 		ctidByte1,err1 := stub.GetState("currentInstrumentNum")
 		if(err1 != nil){
-			return nil, errors.New("Error while getting currentInstrumentNum from ledger")
+			return nil, newError(errLedger, "Error while getting currentInstrumentNum from ledger")
 		}
 		instid,err := strconv.Atoi(string(ctidByte1))
 		if(err != nil){
-			return nil, errors.New("Error while converting ctidByte to integer")
+			return nil, newError(errLedger, "Error while converting ctidByte to integer")
 		}
 		instid = instid + 1
 		instrumentID := "INST"+strconv.Itoa(instid)
@@ -1659,46 +2011,68 @@ func (t *SimpleChaincode) createIssue(stub shim.ChaincodeStubInterface, args []s
 		Quantity :int(quantity),
 		InstrumentPrice :p,
 		Rate :r,
-		SettlementDate :args[5],
-		IssueDate	:args[6],
+		SettlementDate :maturityDate,
+		IssueDate	:issueDate,
 		Callable	:args[7],
 		Status :"PublishedToBank",
 		Owner : caller,
 		Issuer : vioi.Owner,
 		}
-		
+		if len(args) > 8 && args[8] != "" {
+			convention := conventionUnadjusted
+			if len(args) > 9 && args[9] != "" {
+				convention = args[9]
+			}
+			settlementDays := 0
+			if len(args) > 10 && args[10] != "" {
+				settlementDays, err = strconv.Atoi(args[10])
+				if err != nil {
+					return nil, newError(errInvalidArgument, "Invalid settlement days "+args[10])
+				}
+			}
+			err = applyInstrumentCalendar(stub, &inst, args[8], convention, settlementDays)
+			if err != nil {
+				return nil, err
+			}
+		}
 		b, err := json.Marshal(inst)
 		// write to ledger
 		if err == nil {
 			err = stub.PutState(inst.Symbol,b)
 			if err != nil {
-				 return nil, errors.New("Error while create new Issue")
+				 return nil, newError(errLedger, "Error while create new Issue")
 				
 			}
-		} 
+		} else {
+			return nil, newError(errLedger, "Error while marshalling Instrument data")
+		}
 		
 		// add Symbol ID to entity's Instrument List
 		err = updateInstrumentHistory(stub, caller,inst.Symbol)
 		if err != nil {
-			return nil, errors.New( "Error while updating Instrument History : Caller : "+caller+" :"+inst.Symbol)
+			return nil, newError(errLedger,  "Error while updating Instrument History : Caller : "+caller+" :"+inst.Symbol)
 		}	
 		// add Symbol ID to entity's Instrument List
 		err = updateInstrumentHistory(stub, issuer,inst.Symbol)
 		if err != nil {
-			return nil, errors.New( "Error while updating Instrument History : Caller : "+caller+" :"+inst.Symbol)
+			return nil, newError(errLedger,  "Error while updating Instrument History : Caller : "+caller+" :"+inst.Symbol)
 		}
 		
 		ctidByte1,err1 = stub.GetState("currentTransactionNum")
 		if(err1 != nil){
-			return nil, errors.New("Error while getting currentTransactionNum from ledger")
+			return nil, newError(errLedger, "Error while getting currentTransactionNum from ledger")
 		}
 		tid,err := strconv.Atoi(string(ctidByte1))
 		if(err != nil){
-			return nil, errors.New("Error while converting ctidByte to integer")
+			return nil, newError(errLedger, "Error while converting ctidByte to integer")
 		}
 		tid = tid + 1
 		transactionID := "trans"+strconv.Itoa(tid)
 		
+		timeStamp, err := txTimeStamp(stub)
+		if err != nil {
+			return nil, err
+		}
 		tr := Transaction {
 		TransactionID: transactionID,
 		TransactionType: "Create Instrument",
@@ -1707,7 +2081,7 @@ func (t *SimpleChaincode) createIssue(stub shim.ChaincodeStubInterface, args []s
 		//SettlementDate: time.Date(year, month, day, 0, 0, 0, 0, time.UTC),				// based on input
 		Status: "Success",
 		Symbol:instrumentID,
-		TimeStamp : time.Now().Format("2006-01-02 15:04:05"),
+		TimeStamp : timeStamp,
 		}
 
 		// convert to JSON
@@ -1717,35 +2091,29 @@ func (t *SimpleChaincode) createIssue(stub shim.ChaincodeStubInterface, args []s
 		if err == nil {
 			err = stub.PutState(tr.TransactionID,b)
 			if err != nil {
-				_ = updateTransactionStatus(stub, transactionID, "Error while writing Response transaction to ledger")
-				return nil, nil
+				return nil, newError(errLedger, "Error while writing Response transaction to ledger")
 			}
 		} else {
-			_ = updateTransactionStatus(stub, transactionID, "Error while marshalling transaction data")
-			return nil, nil
+			return nil, newError(errLedger, "Error while marshalling transaction data")
 		}
 		
 		err = stub.PutState("currentInstrumentNum", []byte(strconv.Itoa(instid)))
 		if err != nil {
-			_ = updateTransactionStatus(stub, transactionID, "Error while writing current IOI Number to ledger")
-			return nil, nil
+			return nil, newError(errLedger, "Error while writing current IOI Number to ledger")
 		}
 		err = stub.PutState("currentTransactionNum", []byte(strconv.Itoa(tid)))
 		if err != nil {
-			_ = updateTransactionStatus(stub, transactionID, "Error while writing current Transaction Number to ledger")
-			return nil, nil
+			return nil, newError(errLedger, "Error while writing current Transaction Number to ledger")
 		}
 		// add Transaction ID to entity's trade history
 		err = updateTradeHistory(stub, tr.ToUser, tr.TransactionID)
 		if err != nil {
-			_ = updateTransactionStatus(stub, transactionID, "Error while updating trade history")
-			return nil, nil
+			return nil, newError(errLedger, "Error while updating trade history")
 		}	
 		// add Transaction ID to entity's trade history
 		err = updateTradeHistory(stub, tr.FromUser, tr.TransactionID)
 		if err != nil {
-			_ = updateTransactionStatus(stub, transactionID, "Error while updating trade history for Issuer")
-			return nil, nil
+			return nil, newError(errLedger, "Error while updating trade history for Issuer")
 		}
 		vioi.Status="Responded"
 		vioi.Symbol=instrumentID
@@ -1757,74 +2125,71 @@ func (t *SimpleChaincode) createIssue(stub shim.ChaincodeStubInterface, args []s
 		if err == nil {
 			err = stub.PutState(vioi.IoiId,b)
 			if err != nil {
-				_ = updateTransactionStatus(stub, transactionID, "Error while writing Response transaction to ledger")
-				return nil, nil
+				return nil, newError(errLedger, "Error while writing Response transaction to ledger")
 			}
 		} else {
-			_ = updateTransactionStatus(stub, transactionID, "Error while marshalling IOI data")
-			return nil, nil
+			return nil, newError(errLedger, "Error while marshalling IOI data")
 		}
 
 		commission := float64(quantity)*inst.InstrumentPrice*.001
 		price := float64(quantity)*inst.InstrumentPrice
-		err = t.updateEntityBalance(stub,caller, -1*(price - commission))   //Caller
+		_, err = postJournalEntry(stub, issuer, caller, price - commission, "Create Instrument", transactionID)   //Caller pays Client
 		if err != nil {
-				return nil, errors.New(err.Error())
-		}
-		err = t.updateEntityBalance(stub,issuer, (price - commission))   //Client
-		if err != nil {
-				return nil, errors.New(err.Error())
+				return nil, err
 		}
 		err =t.updateInstrumentTradeHistory(stub, inst.Symbol, transactionID)
 		if err != nil {
-				return nil, errors.New(err.Error())
+				return nil, err
 		}
+		emitEvent(stub, MarketEvent{Type: eventInstrumentCreated, Symbol: inst.Symbol, IoiID: vioi.IoiId, TransactionID: transactionID, Status: inst.Status, Parties: []string{issuer, caller}})
 
 		return []byte(inst.Symbol), nil
 	}
-	return nil, errors.New("Incorrect number of arguments")
+	return nil, newError(errArgumentCount, "Incorrect number of arguments")
 }
 
 func (t *SimpleChaincode) getInstrument(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
 		instbyte,err := stub.GetState(args[0])																									
 		if err != nil {
-			return nil, errors.New("Error while getting Instrument info from ledger")
+			return nil, newError(errLedger, "Error while getting Instrument info from ledger")
 		}
 		return instbyte, nil
 }
 func (t *SimpleChaincode) updateInstrumentStatus(stub shim.ChaincodeStubInterface, symbol string, possassion string, status string) (error) {
 		instbyte,err := stub.GetState(symbol)																									
 		if err != nil {
-			return  errors.New("Error while getting Instrument info from ledger")
+			return  newError(errLedger, "Error while getting Instrument info from ledger")
 		}
 		var inst Instrument
 		err = json.Unmarshal(instbyte, &inst)
 		if err != nil {
-			return  errors.New("Unable to Unmarshal Instrument")
+			return  newError(errLedger, "Unable to Unmarshal Instrument")
 		}
 		entityByte , err := stub.GetState(possassion)
 		if err != nil {
-		   return errors.New("Error in getting Entity")
+		   return newError(errLedger, "Error in getting Entity")
 		}
 		var entity Entity
 		err = json.Unmarshal(entityByte, &entity)
 		if err != nil {
-		   return  errors.New("Error in getting Entity Unarshal")
+		   return  newError(errLedger, "Error in getting Entity Unarshal")
 		}
 		if entity.EntityType =="Bank" {
 			inst.Bank = possassion
 		}
 		
+		previous := inst.Status
 		inst.Owner = possassion
 		inst.Status = status
 		b , err := json.Marshal(inst)
 		if err != nil {
-			return  errors.New("Unable to marshal Instrument")
+			return  newError(errLedger, "Unable to marshal Instrument")
 		}
 		err = stub.PutState(inst.Symbol,b)
 		if err != nil {
-			return  errors.New("Unable to update Instrument status")
+			return  newError(errLedger, "Unable to update Instrument status")
 		}
+		emitEvent(stub, MarketEvent{Type: eventInstrumentStatusChanged, Symbol: inst.Symbol, Status: status, PreviousStatus: previous, Parties: []string{inst.Issuer, possassion}})
 		return  nil
 }
 
@@ -1832,12 +2197,12 @@ func (t *SimpleChaincode) updateInstrumentStatus(stub shim.ChaincodeStubInterfac
 func (t *SimpleChaincode) updateInstrumentTradeHistory(stub shim.ChaincodeStubInterface, symbol string, TransactionID string) (error) {
 		instbyte,err := stub.GetState(symbol)																									
 		if err != nil {
-			return  errors.New("Error while getting Instrument info from ledger")
+			return  newError(errLedger, "Error while getting Instrument info from ledger")
 		}
 		var inst Instrument
 		err = json.Unmarshal(instbyte, &inst)
 		if err != nil {
-			return  errors.New("Unable to Unmarshal Instrument")
+			return  newError(errLedger, "Unable to Unmarshal Instrument")
 		}
 		newTradeList := []string{}
 		
@@ -1850,11 +2215,11 @@ func (t *SimpleChaincode) updateInstrumentTradeHistory(stub shim.ChaincodeStubIn
 		inst.TradeID = newTradeList
 		b , err := json.Marshal(inst)
 		if err != nil {
-			return  errors.New("Unable to marshal Instrument")
+			return  newError(errLedger, "Unable to marshal Instrument")
 		}
 		err = stub.PutState(inst.Symbol,b)
 		if err != nil {
-			return  errors.New("Unable to update Instrument Trade ")
+			return  newError(errLedger, "Unable to update Instrument Trade ")
 		}
 		return  nil
 }
@@ -1863,61 +2228,76 @@ func (t *SimpleChaincode) getAllInstruments(stub shim.ChaincodeStubInterface, ar
 /*
 	args 0 : Entity
 	args 1 : Status
+	args 2 : Issued from (YYYY-MM-DD, optional)
+	args 3 : Issued to (YYYY-MM-DD, optional)
+	args 4 : List options (JSON, optional), its date range also applies to the issue date
 */
 	// check entity type
 	entitybyte,err := stub.GetState(args[0])																									
 	if err != nil {
-		return nil, errors.New("Error while getting entity info from ledger")
+		return nil, newError(errLedger, "Error while getting entity info from ledger")
 	}
 	var entity Entity
 	err = json.Unmarshal(entitybyte, &entity)		
 	if err != nil {
-		return nil, errors.New("Error while unmarshalling entity data")
+		return nil, newError(errLedger, "Error while unmarshalling entity data")
 	}
 	fmt.Println("Status and Entity" + args[1])
 	status := args[1]
+	issued, err := parseDateRange(args, 2)
+	if err != nil {
+		return nil, err
+	}
+	opts, err := parseListOptions(args, 4)
+	if err != nil {
+		return nil, err
+	}
 	//if entity.EntityType == "RegBody" {		
-	instruments := make([]Instrument,len(entity.Instruments))
-	var instrumentArray []Instrument //:= make([]Instrument,1)
+	symbols, err := getIndexIDs(stub, indexEntityInstrument, entity.EntityID)
+	if err != nil {
+		return nil, err
+	}
+	instrumentArray := []Instrument{}
 
-		for i:=0; i<len(entity.Instruments); i++ {
-			byteVal,err := stub.GetState(entity.Instruments[i])
+	bookmark, err := collectPage(symbols, opts, func(symbol string) (bool, error) {
+			byteVal,err := stub.GetState(symbol)
 			if err != nil {
-				return nil, errors.New("Error while getting Instrument info from ledger")
+				return false, newError(errLedger, "Error while getting Instrument info from ledger")
 			}
 			fmt.Println("Bytevalue of Instruent and Entity" + string(byteVal))
 			
-			err = json.Unmarshal(byteVal, &instruments[i])	
+			var inst Instrument
+			err = json.Unmarshal(byteVal, &inst)	
 			if err != nil {
-				return nil, errors.New("Error while unmarshalling trades")
+				return false, newError(errLedger, "Error while unmarshalling trades")
 			}
-			if entity.EntityType =="Issuer" && status =="Outstanding" && instruments[i].Status != "New Issue"{
-			
-				instrumentArray = append(instrumentArray,instruments[i])
-				
-			}else if entity.EntityType =="Bank" && status =="Outstanding" && instruments[i].Status != "PublishToBank"{
-					instrumentArray = append(instrumentArray,instruments[i])
-					
-			}else if entity.EntityType =="Investor" && status =="Outstanding" && instruments[i].Status != "PublishToInvestor"{
-					instrumentArray = append(instrumentArray,instruments[i])
-					
-			}else if instruments[i].Status == status{
-			
-				instrumentArray = append(instrumentArray,instruments[i])
-			
+			matched := false
+			if status == "All" {
+				matched = true
+			}else if entity.EntityType =="Issuer" && status =="Outstanding" && inst.Status != "New Issue"{
+				matched = true
+			}else if entity.EntityType =="Bank" && status =="Outstanding" && inst.Status != "PublishToBank"{
+				matched = true
+			}else if entity.EntityType =="Investor" && status =="Outstanding" && inst.Status != "PublishToInvestor"{
+				matched = true
+			}else if inst.Status == status{
+				matched = true
 			}else {
-			 fmt.Println("status" + status + " -" +instruments[i].Status )
+			 fmt.Println("status" + status + " -" +inst.Status )
 			}
-		}
-		
-		if status == "All" {
-			instrumentArray = instruments
-		}
-		b, err := json.Marshal(instrumentArray)
+			if !matched || !issued.containsDate(inst.IssueDate) || !opts.period.containsDate(inst.IssueDate) {
+				return false, nil
+			}
+			if !opts.matchStatus(inst.Status) || !opts.matchSymbol(inst.Symbol) || !opts.matchCounterparty(inst.Issuer, inst.Owner, inst.Bank) {
+				return false, nil
+			}
+			instrumentArray = append(instrumentArray, inst)
+			return true, nil
+		})
 		if err != nil {
-			return nil, errors.New("Error while marshalling trades")
+			return nil, err
 		}
-		return b, nil
+		return listResponse(instrumentArray, len(instrumentArray), bookmark, opts)
 
 }
 
@@ -1925,231 +2305,239 @@ func (t *SimpleChaincode) getAllInstrumentTrades(stub shim.ChaincodeStubInterfac
 /*
 	args 0 : Entity
 	args 1 : Symbol
-
+	args 2 : From (YYYY-MM-DD, optional)
+	args 3 : To (YYYY-MM-DD, optional)
+	args 4 : List options (JSON, optional)
 */
 	// check entity type
 	entitybyte,err := stub.GetState(args[0])																									
 	if err != nil {
-		return nil, errors.New("Error while getting entity info from ledger")
+		return nil, newError(errLedger, "Error while getting entity info from ledger")
 	}
 	var entity Entity
 	err = json.Unmarshal(entitybyte, &entity)		
 	if err != nil {
-		return nil, errors.New("Error while unmarshalling entity data")
+		return nil, newError(errLedger, "Error while unmarshalling entity data")
 	}
 	
 	instbyte,err := stub.GetState(args[1])																									
 	if err != nil {
-		return nil, errors.New("Error while getting Instrument info from ledger")
+		return nil, newError(errLedger, "Error while getting Instrument info from ledger")
 	}
 	var instrument Instrument
 	fmt.Println("Instruent Byte "+string(instbyte))
 	err = json.Unmarshal(instbyte, &instrument)		
 	if err != nil {
-		return nil, errors.New("Error while unmarshalling Instrument data")
+		return nil, newError(errLedger, "Error while unmarshalling Instrument data")
 	}
 	
-	trades := make([]Transaction,len(instrument.TradeID))
-	var tradesArray  []Transaction
+	period, err := parseDateRange(args, 2)
+	if err != nil {
+		return nil, err
+	}
+	opts, err := parseListOptions(args, 4)
+	if err != nil {
+		return nil, err
+	}
+	fmt.Println("Entity Type "+entity.EntityType)
+	tradesArray := []Transaction{}
 	
-		for i:=0; i<len(instrument.TradeID); i++ {
-			byteVal,err := stub.GetState(instrument.TradeID[i])
+	bookmark, err := collectPage(instrument.TradeID, opts, func(transactionID string) (bool, error) {
+			byteVal,err := stub.GetState(transactionID)
 			if err != nil {
-				return nil, errors.New("Error while getting Transaction info from ledger")
+				return false, newError(errLedger, "Error while getting Transaction info from ledger")
 			}
-			err = json.Unmarshal(byteVal, &trades[i])	
+			var tr Transaction
+			err = json.Unmarshal(byteVal, &tr)	
 			if err != nil {
-				return nil, errors.New("Error while unmarshalling trades")
+				return false, newError(errLedger, "Error while unmarshalling trades")
 			}
-			if (trades[i].FromUser == args[0] ||trades[i].ToUser == args[0]){
-			tradesArray = append(tradesArray,trades[i])
-
+			// the Regulatory Body sees every trade, others only their own
+			if entity.EntityType != "RegBody" && tr.FromUser != args[0] && tr.ToUser != args[0] {
+				return false, nil
 			}
-		}
-		
-		fmt.Println("Entity Type "+entity.EntityType)
-		if entity.EntityType =="RegBody"{
-			tradesArray = trades
-		}
-		b, err := json.Marshal(tradesArray)
-		fmt.Println("Trades List "+string(b))
-		
+			if !period.containsTimeStamp(tr.TimeStamp) || !opts.period.containsTimeStamp(tr.TimeStamp) {
+				return false, nil
+			}
+			if !opts.matchStatus(tr.Status) || !opts.matchCounterparty(tr.FromUser, tr.ToUser) {
+				return false, nil
+			}
+			tradesArray = append(tradesArray, tr)
+			return true, nil
+		})
 		if err != nil {
-			return nil, errors.New("Error while marshalling Transacations")
+			return nil, err
 		}
-		return b, nil
+		return listResponse(tradesArray, len(tradesArray), bookmark, opts)
 
 }
 
 
-func (t *SimpleChaincode) updateEntityBalance(stub shim.ChaincodeStubInterface, entity string, Amount float64) (error) {
-
-// check entity type
-	entitybyte,err := stub.GetState(entity)																									
-	if err != nil {
-		return errors.New("Error while getting entity info from ledger")
-	}
-	var entity1 Entity
-	err = json.Unmarshal(entitybyte, &entity1)		
-	if err != nil {
-		return  errors.New("Error while unmarshalling entity data")
-	}
-	
-	entity1.Balance = entity1.Balance+ Amount
-	if entity1.Balance <0 {
-	  return  errors.New("Inssufficient Balance for Entity" + entity)
-	}
-
-	b, err := json.Marshal(entity1)
-	if err != nil {
-		return  errors.New("Error while marshal entity data")
-	}
-	err = stub.PutState(entity, b)
-	if err != nil {
-		return  errors.New("Error while updating entity data")
-	}
-	return  nil
-}
-
 func (t *SimpleChaincode) payCoupon(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
 			/*
-				args 0 : Symbol
+				args 0 : Caller (Issuer or Settlement Agent)
+				args 1 : Symbol
 			*/
-		instbyte , err := stub.GetState(args[0])
+		inst, err := payingInstrument(stub, args[0], args[1])
 		if err != nil {
-			 return nil, errors.New("Error in finding instrument")
+			return nil, err
 		}
-		var inst Instrument
-		err = json.Unmarshal(instbyte, &inst)		
+		if inst.Status == "Defaulted" {
+			return nil, newError(errInvalidState, "Instrument " + inst.Symbol + " has defaulted, coupons are suspended")
+		}
+		
+		// one scheduled period at a time, the earliest one due
+		now, err := txTime(stub)
 		if err != nil {
-			return  nil,errors.New("Error while unmarshalling instbyte data")
+			return nil, err
+		}
+		var period *CouponPayment
+		for i := range inst.CouponSchedule {
+			c := &inst.CouponSchedule[i]
+			if c.Status != "Scheduled" {
+				continue
+			}
+			due, err := parseDate(c.Date)
+			if err != nil {
+				return nil, newError(errLedger, "Invalid coupon date " + c.Date)
+			}
+			if !due.After(now) {
+				period = c
+				break
+			}
+		}
+		if period == nil {
+			return nil, newError(errInvalidState, "No coupon of " + inst.Symbol + " is due")
 		}
 		
 		owner  :=  inst.Owner
 		issuer  := inst.Issuer
-		coupon  := inst.Rate * float64(inst.Quantity)/100
+		// units the issuer bought back earn no coupon
+		coupon  := inst.Rate * float64(inst.Quantity - inst.Repurchased)/100
 		
-		entitybyte,err := stub.GetState(issuer)																									
-		if err != nil {
-			return nil,errors.New("Error while getting Issuer info from ledger")
-		}
-		var entity Entity
-		err = json.Unmarshal(entitybyte, &entity)		
+		entity, err := getEntityState(stub, issuer)
 		if err != nil {
-			return  nil,errors.New("Error while unmarshalling entity data")
+			return nil, err
 		}
 		
-		entity.Balance = entity.Balance- coupon
-		if entity.Balance <0 {
-		  return  nil,errors.New("Inssufficient Balance for Entity" + issuer)
-		}
-
-		b, err := json.Marshal(entity)
-		if err != nil {
-			return  nil,errors.New("Error while marshal entity data")
-		}
-		err = stub.PutState(issuer, b)
-		if err != nil {
-			return  nil,errors.New("Error while updating entity data")
+		// cash held for open orders is not the issuer's to pay out
+		if entity.Balance-entity.HeldBalance < coupon {
+			period.Status = "Missed"
+			eventID, err := markDefault(stub, inst, "Missed Coupon", coupon, entity.Balance-entity.HeldBalance)
+			if err != nil {
+				return nil, err
+			}
+			return []byte(eventID), nil
 		}
 		
-		entitybyte,err = stub.GetState(owner)																									
+		transactionID, err := nextTransactionID(stub)
 		if err != nil {
-			return nil,errors.New("Error while getting Banker/Investor info from ledger")
+			return nil, err
 		}
-
-		err = json.Unmarshal(entitybyte, &entity)		
+		_, err = postJournalEntry(stub, owner, issuer, coupon, "Coupon Payment", transactionID)
 		if err != nil {
-			return  nil,errors.New("Error while unmarshalling entity data")
+			return nil, err
 		}
-		
-		entity.Balance = entity.Balance+ coupon
-
-		b, err = json.Marshal(entity)
+		tr := Transaction{
+		TransactionID: transactionID,
+		TransactionType: "Coupon Payment",
+		FromUser: issuer,
+		ToUser: owner,
+		Symbol: inst.Symbol,
+		Quantity: inst.Quantity - inst.Repurchased,
+		Rate: inst.Rate,
+		Amount: coupon,
+		Status: "Success",
+		}
+		err = recordTransaction(stub, tr)
 		if err != nil {
-			return  nil,errors.New("Error while marshal Investor/Banker entity data")
+			return nil, err
 		}
-		err = stub.PutState(owner, b)
+		period.Status = "Paid"
+		period.TransactionID = transactionID
+		err = putInstrumentState(stub, inst)
 		if err != nil {
-			return  nil,errors.New("Error while updating entity data")
+			return nil, err
 		}
-		return  nil,nil
+		emitEvent(stub, MarketEvent{Type: eventCouponPaid, Symbol: inst.Symbol, TransactionID: transactionID, Amount: coupon, Parties: []string{issuer, owner}})
+		return  []byte(transactionID),nil
 }
 
 
 func (t *SimpleChaincode) issueCallout(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
 			/*
-				args 0 : Symbol
+				args 0 : Caller (Issuer or Settlement Agent)
+				args 1 : Symbol
 			*/
-		instbyte , err := stub.GetState(args[0])
+		inst, err := payingInstrument(stub, args[0], args[1])
 		if err != nil {
-			 return nil, errors.New("Error in finding instrument")
+			return nil, err
 		}
-		var inst Instrument
-		err = json.Unmarshal(instbyte, &inst)		
-		if err != nil {
-			return  nil,errors.New("Error while unmarshalling instbyte data")
+		
+		if inst.Status == "Defaulted" {
+			return nil, newError(errInvalidState, "Instrument " + inst.Symbol + " has defaulted and cannot be called")
 		}
 		
 		owner  :=  inst.Owner
 		issuer  := inst.Issuer
-		price  := inst.InstrumentPrice * float64(inst.Quantity)
-		inst.Status = "Expired"
-		inst.Owner = issuer
+		// units the issuer bought back are not paid for again
+		price  := inst.InstrumentPrice * float64(inst.Quantity - inst.Repurchased)
 		
 		entitybyte,err := stub.GetState(issuer)																									
 		if err != nil {
-			return nil,errors.New("Error while getting Issuer info from ledger")
+			return nil,newError(errLedger, "Error while getting Issuer info from ledger")
 		}
 		var entity Entity
 		err = json.Unmarshal(entitybyte, &entity)		
 		if err != nil {
-			return  nil,errors.New("Error while unmarshalling entity data")
+			return  nil,newError(errLedger, "Error while unmarshalling entity data")
 		}
 		
-		entity.Balance = entity.Balance- price
-		if entity.Balance <0 {
-		  return  nil,errors.New("Inssufficient Balance for Entity :" + issuer)
-		}
-
-		b, err := json.Marshal(entity)
-		if err != nil {
-			return  nil,errors.New("Error while marshal entity data")
-		}
-		err = stub.PutState(issuer, b)
-		if err != nil {
-			return  nil,errors.New("Error while updating entity data")
+		// cash held for open orders is not the issuer's to pay out
+		if entity.Balance-entity.HeldBalance < price {
+			eventID, err := markDefault(stub, inst, "Missed Principal", price, entity.Balance-entity.HeldBalance)
+			if err != nil {
+				return nil, err
+			}
+			return []byte(eventID), nil
 		}
+		inst.Status = "Expired"
+		inst.Owner = issuer
 		
-		entitybyte,err = stub.GetState(owner)																									
+		transactionID, err := nextTransactionID(stub)
 		if err != nil {
-			return nil,errors.New("Error while getting Banker/Investor info from ledger")
+			return nil, err
 		}
-
-		err = json.Unmarshal(entitybyte, &entity)		
+		_, err = postJournalEntry(stub, owner, issuer, price, "Call Redemption", transactionID)
 		if err != nil {
-			return  nil,errors.New("Error while unmarshalling entity data")
+			return nil, err
 		}
-		
-		entity.Balance = entity.Balance+ price
-
-		b, err = json.Marshal(entity)
-		if err != nil {
-			return  nil,errors.New("Error while marshal Investor/Banker entity data")
+		tr := Transaction{
+		TransactionID: transactionID,
+		TransactionType: "Call Redemption",
+		FromUser: issuer,
+		ToUser: owner,
+		Symbol: inst.Symbol,
+		Quantity: inst.Quantity,
+		InstrumentPrice: inst.InstrumentPrice,
+		Amount: price,
+		Status: "Success",
 		}
-		err = stub.PutState(owner, b)
+		err = recordTransaction(stub, tr)
 		if err != nil {
-			return  nil,errors.New("Error while updating entity data")
+			return nil, err
 		}
+		inst.TradeID = append(inst.TradeID, transactionID)
 		
-		b , err = json.Marshal(inst)
+		b , err := json.Marshal(inst)
 		if err != nil {
-			return  nil,errors.New("Error while marshal Instrument data")
+			return  nil,newError(errLedger, "Error while marshal Instrument data")
 		}
-		err = stub.PutState(args[0], b)
+		err = stub.PutState(inst.Symbol, b)
 		if err != nil {
-			return  nil,errors.New("Error while updating entity data")
+			return  nil,newError(errLedger, "Error while updating entity data")
 		}
+		emitEvent(stub, MarketEvent{Type: eventCallIssued, Symbol: inst.Symbol, TransactionID: transactionID, Status: inst.Status, Amount: price, Parties: []string{issuer, owner}})
 		return  nil,nil
 }
 
@@ -2164,27 +2552,30 @@ func (t *SimpleChaincode) requestForInstrument(stub shim.ChaincodeStubInterface,
 		caller := args[0]
 		bank := args[1]
 		notional, err := strconv.ParseFloat(args[2], 64)
+		if err != nil {
+			return nil, newError(errInvalidArgument, "Invalid notional "+args[2])
+		}
 		tenor := args[3]
 		
 		quoteByte, err := stub.GetState("currentIoiNum")
 		if err != nil {
-			return nil,errors.New("Unable to find current Rfq number")
+			return nil,newError(errLedger, "Unable to find current Rfq number")
 		}
 		
 		qtid,err := strconv.Atoi(string(quoteByte))
 		if(err != nil){
-			return nil, errors.New("Error while converting quoteByte to integer")
+			return nil, newError(errLedger, "Error while converting quoteByte to integer")
 		}
 		qtid = qtid + 1
 		IoiID := "IOI"+strconv.Itoa(qtid)
 		
 		ctidByte1,err1 := stub.GetState("currentTransactionNum")
 		if(err1 != nil){
-			return nil, errors.New("Error while getting currentTransactionNum from ledger")
+			return nil, newError(errLedger, "Error while getting currentTransactionNum from ledger")
 		}
 		tid,err := strconv.Atoi(string(ctidByte1))
 		if(err != nil){
-			return nil, errors.New("Error while converting ctidByte to integer")
+			return nil, newError(errLedger, "Error while converting ctidByte to integer")
 		}
 		tid = tid + 1
 		transactionID := "trans"+strconv.Itoa(tid)
@@ -2193,13 +2584,11 @@ func (t *SimpleChaincode) requestForInstrument(stub shim.ChaincodeStubInterface,
 		// get bank's enrollment id
 		bytes, err := stub.GetCallerCertificate();
 		if err != nil {
-			_ = updateTransactionStatus(stub, transactionID, "Error while getting caller certificate")
-			return nil, nil
+			return nil, newError(errLedger, "Error while getting caller certificate")
 		}
 		x509Cert, err := x509.ParseCertificate(bytes);
 		if err != nil {
-			_ = updateTransactionStatus(stub, transactionID, "Error while parsing caller certificate")
-			return nil, nil
+			return nil, newError(errLedger, "Error while parsing caller certificate")
 		}		
 		fmt.Println("Create IOI : x509Cert"+x509Cert.Subject.CommonName)
 		
@@ -2220,13 +2609,17 @@ func (t *SimpleChaincode) requestForInstrument(stub shim.ChaincodeStubInterface,
 		if err == nil {
 			err = stub.PutState(ioi.IoiId,d)
 			if err != nil {
-				return nil, errors.New("Error while writing Response transaction to ledger")
+				return nil, newError(errLedger, "Error while writing Response transaction to ledger")
 			}
 		} else {
-			return nil, errors.New("Error while unMarshalling Response Ioi to ledger")
+			return nil, newError(errLedger, "Error while unMarshalling Response Ioi to ledger")
 
 		}
 		
+		timeStamp, err := txTimeStamp(stub)
+		if err != nil {
+			return nil, err
+		}
 		tr := Transaction {
 		TransactionID: transactionID,
 		TransactionType: "New IOI",
@@ -2235,7 +2628,7 @@ func (t *SimpleChaincode) requestForInstrument(stub shim.ChaincodeStubInterface,
 		//SettlementDate: time.Date(year, month, day, 0, 0, 0, 0, time.UTC),				// based on input
 		Status: "Success",
 		Symbol:IoiID,
-		TimeStamp : time.Now().Format("2006-01-02 15:04:05"),
+		TimeStamp : timeStamp,
 		}
 
 		// convert to JSON
@@ -2245,117 +2638,219 @@ func (t *SimpleChaincode) requestForInstrument(stub shim.ChaincodeStubInterface,
 		if err == nil {
 			err = stub.PutState(tr.TransactionID,b)
 			if err != nil {
-				_ = updateTransactionStatus(stub, transactionID, "Error while writing Response transaction to ledger")
-				return nil, nil
+				return nil, newError(errLedger, "Error while writing Response transaction to ledger")
 			}
 		} else {
-			_ = updateTransactionStatus(stub, transactionID, "Error while marshalling transaction data")
-			return nil, nil
+			return nil, newError(errLedger, "Error while marshalling transaction data")
 		}
 		
 		err = stub.PutState("currentIoiNum", []byte(strconv.Itoa(qtid)))
 		if err != nil {
-			_ = updateTransactionStatus(stub, transactionID, "Error while writing current IOI Number to ledger")
-			return nil, nil
+			return nil, newError(errLedger, "Error while writing current IOI Number to ledger")
 		}
 		err = stub.PutState("currentTransactionNum", []byte(strconv.Itoa(tid)))
 		if err != nil {
-			_ = updateTransactionStatus(stub, transactionID, "Error while writing current Transaction Number to ledger")
-			return nil, nil
+			return nil, newError(errLedger, "Error while writing current Transaction Number to ledger")
 		}
 		
 		
 		// add Transaction ID to entity's trade history
 		err = updateTradeHistory(stub, tr.ToUser, tr.TransactionID)
 		if err != nil {
-			_ = updateTransactionStatus(stub, transactionID, "Error while updating trade history")
-			return nil, nil
+			return nil, newError(errLedger, "Error while updating trade history")
 		}	
 		// add Transaction ID to entity's trade history
 		err = updateTradeHistory(stub, tr.FromUser, tr.TransactionID)
 		if err != nil {
-			_ = updateTransactionStatus(stub, transactionID, "Error while updating trade history for Issuer")
-			return nil, nil
+			return nil, newError(errLedger, "Error while updating trade history for Issuer")
 		}
 		// add IOI ID to entity's  history
 		err = updateIOIHistory(stub, tr.ToUser, IoiID)
 		if err != nil {
-			_ = updateTransactionStatus(stub, transactionID, "Error while updating trade history")
-			return nil, nil
+			return nil, newError(errLedger, "Error while updating trade history")
 		}	
 		// add IOI ID to entity's  history
 		err = updateIOIHistory(stub, tr.FromUser, IoiID)
 		if err != nil {
-			_ = updateTransactionStatus(stub, transactionID, "Error while updating trade history")
-			return nil, nil
+			return nil, newError(errLedger, "Error while updating trade history")
 		}	
+		emitEvent(stub, MarketEvent{Type: eventIoiCreated, IoiID: IoiID, TransactionID: transactionID, Status: ioi.Status, Amount: notional, Parties: []string{caller, bank}})
 		
 		return nil, nil
 	}
-	return nil, errors.New("Incorrect number of arguments")
+	return nil, newError(errArgumentCount, "Incorrect number of arguments")
 }
 
 func updateIOIHistory(stub shim.ChaincodeStubInterface, entityID string, IOIID string) (error) {
-	// read entity state
-	entitybyte,err := stub.GetState(entityID)																										
-	if err != nil {
-		return errors.New("Error while getting entity info from ledger")
-	}
-	var entity Entity
-	err = json.Unmarshal(entitybyte, &entity)		
-	if err != nil {
-		return errors.New("Error while unmarshalling entity data")
-	}
 	// add IOIID to history
-	entity.IoiList = append(entity.IoiList,IOIID)
-	// write entity state to ledger
-	b, err := json.Marshal(entity)
-	if err == nil {
-		err = stub.PutState(entity.EntityID,b)
-	} else {
-		return errors.New("Error while updating entity status")
-	}
-	return nil
+	return addToIndex(stub, indexEntityIoi, entityID, IOIID)
 }
 
 func (t *SimpleChaincode) getAllIoi(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
 /*
 	args 0 : Entity
+	args 1 : List options (JSON, optional)
 */
 	// check entity type
 	entitybyte,err := stub.GetState(args[0])																									
 	if err != nil {
-		return nil, errors.New("Error while getting entity info from ledger")
+		return nil, newError(errLedger, "Error while getting entity info from ledger")
 	}
 	var entity Entity
 	err = json.Unmarshal(entitybyte, &entity)		
 	if err != nil {
-		return nil, errors.New("Error while unmarshalling entity data")
+		return nil, newError(errLedger, "Error while unmarshalling entity data")
+	}
+	opts, err := parseListOptions(args, 1)
+	if err != nil {
+		return nil, err
 	}
 
-	ioi := make([]Ioi,len(entity.IoiList))
-	var IoiArray []Ioi //:= make([]Ioi,1)
+	ioiList, err := getIndexIDs(stub, indexEntityIoi, entity.EntityID)
+	if err != nil {
+		return nil, err
+	}
+	IoiArray := []Ioi{}
 
-		for i:=0; i<len(entity.IoiList); i++ {
-			byteVal,err := stub.GetState(entity.IoiList[i])
+	bookmark, err := collectPage(ioiList, opts, func(ioiID string) (bool, error) {
+			byteVal,err := stub.GetState(ioiID)
 			if err != nil {
-				return nil, errors.New("Error while getting Instrument info from ledger")
+				return false, newError(errLedger, "Error while getting Instrument info from ledger")
 			}
 			fmt.Println("Bytevalue of Instruent and Entity" + string(byteVal))
 			
-			err = json.Unmarshal(byteVal, &ioi[i])	
+			var ioi Ioi
+			err = json.Unmarshal(byteVal, &ioi)	
 			if err != nil {
-				return nil, errors.New("Error while unmarshalling ioi")
+				return false, newError(errLedger, "Error while unmarshalling ioi")
 			}
-
+			if !opts.matchStatus(ioi.Status) || !opts.matchCounterparty(ioi.Bank) {
+				return false, nil
+			}
+			IoiArray = append(IoiArray, ioi)
+			return true, nil
+		})
+		if err != nil {
+			return nil, err
 		}
-		
-		IoiArray = ioi
+		return listResponse(IoiArray, len(IoiArray), bookmark, opts)
+
+}
+
+//==============================================================================================================================
+//	 State helpers - read and write the common ledger records
+//==============================================================================================================================
+func getEntityState(stub shim.ChaincodeStubInterface, entityID string) (Entity, error) {
+	var entity Entity
+	entitybyte, err := stub.GetState(entityID)
+	if ce, ok := err.(*ChaincodeError); ok {
+		// e.g. sealed values that do not match their digest
+		return entity, ce
+	}
+	if err != nil {
+		return entity, newError(errLedger, "Error while getting entity info from ledger :" + entityID)
+	}
+	if len(entitybyte) == 0 {
+		return entity, newError(errNotFound, "Entity Not Found", "entity", entityID)
+	}
+	err = json.Unmarshal(entitybyte, &entity)
+	if err != nil {
+		return entity, newError(errLedger, "Error while unmarshalling entity data :" + entityID)
+	}
+	return entity, nil
+}
 
-		b, err := json.Marshal(IoiArray)
+func putEntityState(stub shim.ChaincodeStubInterface, entity Entity) (error) {
+	entity.AvailableBalance = entity.Balance - entity.HeldBalance
+	b, err := json.Marshal(entity)
+	if err != nil {
+		return newError(errLedger, "Error while marshal entity data")
+	}
+	err = stub.PutState(entity.EntityID, b)
+	if err != nil {
+		return newError(errLedger, "Error while updating entity data")
+	}
+	return nil
+}
+
+func getInstrumentState(stub shim.ChaincodeStubInterface, symbol string) (Instrument, error) {
+	var inst Instrument
+	instbyte, err := stub.GetState(symbol)
+	if ce, ok := err.(*ChaincodeError); ok {
+		return inst, ce
+	}
+	if err != nil {
+		return inst, newError(errLedger, "Error while getting Instrument info from ledger :" + symbol)
+	}
+	if len(instbyte) == 0 {
+		return inst, newError(errNotFound, "Instruent not found", "symbol", symbol)
+	}
+	err = json.Unmarshal(instbyte, &inst)
+	if err != nil {
+		return inst, newError(errLedger, "Unable to Unmarshal Instrument :" + symbol)
+	}
+	return inst, nil
+}
+
+func putInstrumentState(stub shim.ChaincodeStubInterface, inst Instrument) (error) {
+	b, err := json.Marshal(inst)
+	if err != nil {
+		return newError(errLedger, "Unable to marshal Instrument")
+	}
+	err = stub.PutState(inst.Symbol, b)
+	if err != nil {
+		return newError(errLedger, "Unable to update Instrument " + inst.Symbol)
+	}
+	return nil
+}
+
+// nextTransactionID increments currentTransactionNum and returns the new transaction ID
+func nextTransactionID(stub shim.ChaincodeStubInterface) (string, error) {
+	ctidByte, err := stub.GetState("currentTransactionNum")
+	if err != nil {
+		return "", newError(errLedger, "Error while getting currentTransactionNum from ledger")
+	}
+	tid, err := strconv.Atoi(string(ctidByte))
+	if err != nil {
+		return "", newError(errLedger, "Error while converting ctidByte to integer")
+	}
+	tid = tid + 1
+	err = stub.PutState("currentTransactionNum", []byte(strconv.Itoa(tid)))
+	if err != nil {
+		return "", newError(errLedger, "Error while writing current Transaction Number to ledger")
+	}
+	return "trans" + strconv.Itoa(tid), nil
+}
+
+// recordTransaction writes the transaction and adds it to the trade history of both parties
+func recordTransaction(stub shim.ChaincodeStubInterface, tr Transaction) (error) {
+	var err error
+	if tr.TimeStamp == "" {
+		tr.TimeStamp, err = txTimeStamp(stub)
 		if err != nil {
-			return nil, errors.New("Error while marshalling trades")
+			return err
 		}
-		return b, nil
-
+	}
+	if tr.SettlementDate == "" && tr.Status == "Success" {
+		tr.SettlementDate = dateOf(tr.TimeStamp)	// payments settle when they are booked
+	}
+	b, err := json.Marshal(tr)
+	if err != nil {
+		return newError(errLedger, "Error while marshalling transaction data")
+	}
+	err = stub.PutState(tr.TransactionID, b)
+	if err != nil {
+		return newError(errLedger, "Error while writing Transaction to ledger")
+	}
+	err = updateTradeHistory(stub, tr.FromUser, tr.TransactionID)
+	if err != nil {
+		return err
+	}
+	if tr.ToUser != tr.FromUser {
+		err = updateTradeHistory(stub, tr.ToUser, tr.TransactionID)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
 }
@@ -0,0 +1,247 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/json"
+	"math"
+	"math/big"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+	gp "google/protobuf"
+)
+
+// testStub is the mock stub plus what the mock leaves empty: the proposal timestamp, the caller's enrollment ID,
+// certificate and metadata, and the chaincode events set
+type testStub struct {
+	*shim.MockStub
+	cc       *SimpleChaincode
+	now      time.Time
+	caller   string
+	fieldKey string // passed in the caller metadata when set
+	txNum    int
+	events   []EventBatch
+}
+
+// newTestStub runs Init on an empty ledger at the time given
+func newTestStub(t *testing.T, now string) *testStub {
+	cc := new(SimpleChaincode)
+	s := &testStub{MockStub: shim.NewMockStub("mktplace", cc), cc: cc}
+	s.setTime(t, now)
+	s.MockTransactionStart("init")
+	_, err := cc.Init(s, "init", nil)
+	s.MockTransactionEnd("init")
+	if err != nil {
+		t.Fatalf("Init failed: %s", err)
+	}
+	return s
+}
+
+func (s *testStub) setTime(t *testing.T, now string) {
+	ts, err := time.Parse(time.RFC3339, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.now = ts
+}
+
+func (s *testStub) GetTxTimestamp() (*gp.Timestamp, error) {
+	return &gp.Timestamp{Seconds: s.now.Unix(), Nanos: int32(s.now.Nanosecond())}, nil
+}
+
+func (s *testStub) ReadCertAttribute(attributeName string) ([]byte, error) {
+	return []byte(s.caller), nil
+}
+
+func (s *testStub) GetCallerCertificate() ([]byte, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	template := x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: s.caller},
+		NotBefore:    s.now,
+		NotAfter:     s.now.AddDate(1, 0, 0),
+	}
+	return x509.CreateCertificate(rand.Reader, &template, &template, &key.PublicKey, key)
+}
+
+func (s *testStub) GetCallerMetadata() ([]byte, error) {
+	if s.fieldKey == "" {
+		return nil, nil
+	}
+	return json.Marshal(CallerMetadata{FieldKey: s.fieldKey})
+}
+
+func (s *testStub) SetEvent(name string, payload []byte) error {
+	var batch EventBatch
+	err := json.Unmarshal(payload, &batch)
+	if err != nil {
+		return err
+	}
+	s.events = append(s.events, batch)
+	return nil
+}
+
+// invoke runs the function as a transaction of its own made by the caller
+func (s *testStub) invoke(caller string, function string, args ...string) ([]byte, error) {
+	s.txNum++
+	txID := "tx" + strconv.Itoa(s.txNum)
+	s.caller = caller
+	s.MockTransactionStart(txID)
+	defer s.MockTransactionEnd(txID)
+	return s.cc.Invoke(s, function, args)
+}
+
+// lastTxID is the ID of the transaction invoke ran last
+func (s *testStub) lastTxID() string {
+	return "tx" + strconv.Itoa(s.txNum)
+}
+
+func (s *testStub) mustInvoke(t *testing.T, caller string, function string, args ...string) []byte {
+	b, err := s.invoke(caller, function, args...)
+	if err != nil {
+		t.Fatalf("%s failed: %s", function, err)
+	}
+	return b
+}
+
+func (s *testStub) query(function string, args ...string) ([]byte, error) {
+	return s.cc.Query(s, function, args)
+}
+
+// setup runs f in a transaction of its own, writes what it changed and publishes its events, for state no invoke
+// creates directly
+func (s *testStub) setup(t *testing.T, f func(u *unitOfWork) error) {
+	s.MockTransactionStart("setup")
+	defer s.MockTransactionEnd("setup")
+	u := newUnitOfWork(s)
+	err := f(u)
+	if err == nil {
+		err = u.flush()
+	}
+	if err == nil {
+		err = u.publishEvents()
+	}
+	if err != nil {
+		t.Fatalf("setup failed: %s", err)
+	}
+}
+
+func (s *testStub) entity(t *testing.T, entityID string) Entity {
+	entity, err := getEntityState(newUnitOfWork(s), entityID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return entity
+}
+
+func (s *testStub) instrument(t *testing.T, symbol string) Instrument {
+	inst, err := getInstrumentState(newUnitOfWork(s), symbol)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return inst
+}
+
+// testInstrument is an issue of entity1 arranged by entity3, 1000 units at 100
+func testInstrument(symbol string) Instrument {
+	return Instrument{
+		Symbol:          symbol,
+		Coupon:          "BW",
+		Quantity:        1000,
+		InstrumentPrice: 100,
+		Rate:            0.05,
+		SettlementDate:  "2018-03-01",
+		IssueDate:       "2017-01-02",
+		Callable:        "No",
+		Status:          "PublishedToBank",
+		Owner:           entity3,
+		Bank:            entity3,
+		Issuer:          entity1,
+	}
+}
+
+// issue stores the instrument and gives each holder its position
+func (s *testStub) issue(t *testing.T, inst Instrument, positions map[string]int) {
+	s.setup(t, func(u *unitOfWork) error {
+		err := putInstrumentState(u, inst)
+		if err != nil {
+			return err
+		}
+		for entityID, quantity := range positions {
+			entity, err := getEntityState(u, entityID)
+			if err != nil {
+				return err
+			}
+			entity.Portfolio = append(entity.Portfolio, Stock{Symbol: inst.Symbol, Quantity: quantity})
+			err = putEntityState(u, entity)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// hold earmarks all but the available amount of the entity's balance
+func (s *testStub) hold(t *testing.T, entityID string, available float64) {
+	s.setup(t, func(u *unitOfWork) error {
+		entity, err := getEntityState(u, entityID)
+		if err != nil {
+			return err
+		}
+		_, err = placeHold(u, entityID, entity.Balance-entity.HeldBalance-available, "Test", "trans0")
+		return err
+	})
+}
+
+// position is the quantity of the symbol in the entity's portfolio
+func (s *testStub) position(t *testing.T, entityID string, symbol string) int {
+	return positions(s.entity(t, entityID).Portfolio)[symbol]
+}
+
+// assertJournalBalanced checks every entity's balance is what its journal entries add up to
+func (s *testStub) assertJournalBalanced(t *testing.T) {
+	u := newUnitOfWork(s)
+	var entityIDs []string
+	b, _ := u.GetState("entityList")
+	json.Unmarshal(b, &entityIDs)
+	for _, entityID := range entityIDs {
+		entity := s.entity(t, entityID)
+		journaled, err := journalBalance(u, entity)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if math.Abs(journaled-entity.Balance) > 0.005 {
+			t.Errorf("%s has balance %.2f but its journal adds up to %.2f", entityID, entity.Balance, journaled)
+		}
+	}
+}
+
+// errorCode is the catalogued code of an error returned by Invoke or Query
+func errorCode(err error) string {
+	if ce, ok := err.(*ChaincodeError); ok {
+		return ce.Code
+	}
+	return ""
+}
+
+func assertCode(t *testing.T, err error, code string) {
+	if errorCode(err) != code {
+		t.Fatalf("expected %s, got %v", code, err)
+	}
+}
+
+func assertBalance(t *testing.T, s *testStub, entityID string, want float64) {
+	if got := s.entity(t, entityID).Balance; math.Abs(got-want) > 0.005 {
+		t.Errorf("balance of %s is %.2f, expected %.2f", entityID, got, want)
+	}
+}
@@ -0,0 +1,348 @@
+package main
+
+import (
+	"encoding/json"
+	"sort"
+	"strconv"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+/*	setPutSchedule - adds puts to the schedule, or changes the price and notice of puts nobody has exercised yet.
+					 Puts already exercised or settled are kept as they are.
+		args 0	:	Caller (Issuer of the instrument)
+		args 1	:	Symbol
+		args 2	:	Notice period in days before each put date
+		args 3	:	Put date (YYYY-MM-DD)
+		args 4	:	Put price per unit
+		...		:	further date / price pairs
+*/
+func (t *SimpleChaincode) setPutSchedule(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	if len(args) < 5 || len(args)%2 != 1 {
+		return nil, newError(errArgumentCount, "Incorrect number of arguments")
+	}
+	caller := args[0]
+	inst, err := getInstrumentState(stub, args[1])
+	if err != nil {
+		return nil, err
+	}
+	// a put is the issuer's promise to buy back, a holder setting one could name its own price
+	if caller != inst.Issuer {
+		return nil, newError(errNotAuthorized, "Only the Issuer can set the put schedule")
+	}
+	notice, err := strconv.Atoi(args[2])
+	if err != nil || notice < 0 {
+		return nil, newError(errInvalidArgument, "Invalid notice period " + args[2])
+	}
+
+	exercised := make(map[string]bool)
+	for _, e := range inst.PutExercises {
+		exercised[e.PutDate] = true
+	}
+	schedule := inst.PutSchedule
+	for i := 3; i < len(args); i = i + 2 {
+		unadjusted, err := normalizeDate(args[i])
+		if err != nil {
+			return nil, err
+		}
+		date, err := rollInstrumentDate(stub, inst, unadjusted)
+		if err != nil {
+			return nil, err
+		}
+		p, err := strconv.ParseFloat(args[i+1], 64)
+		if err != nil || p <= 0 {
+			return nil, newError(errInvalidArgument, "Invalid put price " + args[i+1])
+		}
+		put := PutOption{Date: date, UnadjustedDate: unadjusted, Price: p, NoticeDays: notice, Status: "Open"}
+		changed := false
+		for j := range schedule {
+			if schedule[j].Date != date {
+				continue
+			}
+			if schedule[j].Status != "Open" || exercised[date] {
+				return nil, newError(errInvalidState, "Put on " + date + " has been exercised and cannot be changed")
+			}
+			schedule[j] = put
+			changed = true
+		}
+		if !changed {
+			schedule = append(schedule, put)
+		}
+	}
+	sort.Sort(byPutDate(schedule))
+
+	inst.PutSchedule = schedule
+	err = putInstrumentState(stub, inst)
+	if err != nil {
+		return nil, err
+	}
+	return nil, nil
+}
+
+/*	exercisePut - holder gives notice to sell back on a put date
+		args 0	:	Caller (holder)
+		args 1	:	Symbol
+		args 2	:	Put date (YYYY-MM-DD)
+		args 3	:	Quantity
+*/
+func (t *SimpleChaincode) exercisePut(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	if len(args) != 4 {
+		return nil, newError(errArgumentCount, "Incorrect number of arguments")
+	}
+	caller := args[0]
+	inst, err := getInstrumentState(stub, args[1])
+	if err != nil {
+		return nil, err
+	}
+	if inst.Status == "Expired" || inst.Status == "Redeemed" || inst.Status == "Defaulted" {
+		return nil, newError(errInvalidState, "Instrument " + inst.Symbol + " is no longer outstanding")
+	}
+	quantity, err := strconv.Atoi(args[3])
+	if err != nil || quantity <= 0 {
+		return nil, newError(errInvalidArgument, "Invalid put quantity " + args[3])
+	}
+
+	// the holder may give the put date unadjusted
+	date, err := normalizeDate(args[2])
+	if err != nil {
+		return nil, err
+	}
+	date, err = rollInstrumentDate(stub, inst, date)
+	if err != nil {
+		return nil, err
+	}
+	var put *PutOption
+	for i := range inst.PutSchedule {
+		if inst.PutSchedule[i].Date == date {
+			put = &inst.PutSchedule[i]
+		}
+	}
+	if put == nil || put.Status != "Open" {
+		return nil, newError(errInvalidState, "No open put on " + date + " for " + inst.Symbol)
+	}
+	putDate, err := parseDate(put.Date)
+	if err != nil {
+		return nil, newError(errLedger, "Invalid put date " + put.Date)
+	}
+	now, err := txTime(stub)
+	if err != nil {
+		return nil, err
+	}
+	if now.Before(putDate.AddDate(0, 0, -put.NoticeDays)) || !now.Before(putDate) {
+		return nil, newError(errInvalidState, "Put notice window for " + put.Date + " is not open")
+	}
+
+	// the holder cannot put back more than it holds, counting notices already given
+	holdings, err := getHoldings(stub, inst)
+	if err != nil {
+		return nil, err
+	}
+	held := 0
+	for _, h := range holdings {
+		if h.EntityID == caller {
+			held = h.Quantity
+		}
+	}
+	for _, e := range inst.PutExercises {
+		if e.Holder == caller && e.Status == "Pending" {
+			held = held - e.Quantity
+		}
+	}
+	if quantity > held {
+		return nil, newError(errInvalidArgument, "Put quantity exceeds the position held by " + caller)
+	}
+
+	transactionID, err := nextTransactionID(stub)
+	if err != nil {
+		return nil, err
+	}
+	tr := Transaction{
+		TransactionID:   transactionID,
+		TransactionType: "Put Exercise",
+		FromUser:        caller,
+		ToUser:          inst.Issuer,
+		Symbol:          inst.Symbol,
+		Quantity:        quantity,
+		InstrumentPrice: put.Price,
+		Rate:            inst.Rate,
+		Status:          "Success",
+	}
+	err = recordTransaction(stub, tr)
+	if err != nil {
+		return nil, err
+	}
+	// the issuer's cash is earmarked for the put when it has it, a shortfall only counts on the put date
+	holdID := ""
+	amount := float64(quantity) * put.Price
+	issuer, err := getEntityState(stub, inst.Issuer)
+	if err != nil {
+		return nil, err
+	}
+	if issuer.Balance-issuer.HeldBalance >= amount {
+		holdID, err = placeHoldUntil(stub, inst.Issuer, amount, holdPutExercise, transactionID, putDate.AddDate(0, 0, 1))
+		if err != nil {
+			return nil, err
+		}
+	}
+	inst.PutExercises = append(inst.PutExercises, PutExercise{
+		ExerciseID: transactionID,
+		Holder:     caller,
+		PutDate:    put.Date,
+		Quantity:   quantity,
+		Price:      put.Price,
+		Status:     "Pending",
+		HoldID:     holdID,
+	})
+	err = putInstrumentState(stub, inst)
+	if err != nil {
+		return nil, err
+	}
+	return []byte(transactionID), nil
+}
+
+/*	settlePuts - pays out every pending exercise whose put date has arrived, returns the put transactions. The
+				 prices are on the instrument and the transactions rather than in the response.
+		args 0	:	Caller (Issuer of the instrument or the Settlement Agent)
+		args 1	:	Symbol
+*/
+func (t *SimpleChaincode) settlePuts(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	if len(args) != 2 {
+		return nil, newError(errArgumentCount, "Incorrect number of arguments")
+	}
+	inst, err := getInstrumentState(stub, args[1])
+	if err != nil {
+		return nil, err
+	}
+	caller, err := getEntityState(stub, args[0])
+	if err != nil {
+		return nil, err
+	}
+	if caller.EntityID != inst.Issuer && caller.EntityType != "SettlementAgent" {
+		return nil, newError(errNotAuthorized, "Only the Issuer or the Settlement Agent can settle puts")
+	}
+
+	if inst.Status == "Defaulted" {
+		return nil, newError(errInvalidState, "Instrument " + inst.Symbol + " has defaulted, puts are suspended")
+	}
+
+	now, err := txTime(stub)
+	if err != nil {
+		return nil, err
+	}
+	missed := 0.0
+	issuerBalance := 0.0
+	var transactions []string
+	for i := range inst.PutSchedule {
+		put := &inst.PutSchedule[i]
+		if put.Status != "Open" {
+			continue
+		}
+		putDate, err := parseDate(put.Date)
+		if err != nil {
+			return nil, newError(errLedger, "Invalid put date " + put.Date)
+		}
+		if putDate.After(now) {
+			continue
+		}
+		for j := range inst.PutExercises {
+			e := &inst.PutExercises[j]
+			if e.PutDate != put.Date || e.Status != "Pending" {
+				continue
+			}
+			amount := float64(e.Quantity) * e.Price
+			transactionID, err := nextTransactionID(stub)
+			if err != nil {
+				return nil, err
+			}
+			tr := Transaction{
+				TransactionID:   transactionID,
+				TransactionType: "Put Redemption",
+				FromUser:        inst.Issuer,
+				ToUser:          e.Holder,
+				Symbol:          inst.Symbol,
+				Quantity:        e.Quantity,
+				InstrumentPrice: e.Price,
+				Rate:            inst.Rate,
+				Status:          "Success",
+			}
+
+			held := false
+			if e.HoldID != "" {
+				hold, err := getHold(stub, e.HoldID)
+				if err != nil {
+					return nil, err
+				}
+				held = hold.Status == "Active"
+			}
+			issuer, err := getEntityState(stub, inst.Issuer)
+			if err != nil {
+				return nil, err
+			}
+			// cash held for open orders is not the issuer's to pay out
+			available := issuer.Balance - issuer.HeldBalance
+			if !held && available < amount {
+				// issuer cannot pay, the holder keeps the position
+				e.Status = "Failed"
+				e.Reason = "Inssufficient Balance for Entity" + inst.Issuer
+				tr.Status = "Failed"
+				missed = missed + amount
+				issuerBalance = available
+			} else {
+				if held {
+					err = consumeHold(stub, e.HoldID, e.Holder, "Put Redemption", transactionID)
+				} else {
+					_, err = postJournalEntry(stub, e.Holder, inst.Issuer, amount, "Put Redemption", transactionID)
+				}
+				if err != nil {
+					return nil, err
+				}
+				tr.Amount = amount
+				err = reducePosition(stub, e.Holder, inst.Symbol, e.Quantity)
+				if err != nil {
+					return nil, err
+				}
+				inst.Quantity = inst.Quantity - e.Quantity
+				e.Status = "Settled"
+			}
+			err = recordTransaction(stub, tr)
+			if err != nil {
+				return nil, err
+			}
+			e.SettlementID = transactionID
+			transactions = append(transactions, transactionID)
+			emitEvent(stub, MarketEvent{Type: eventPutSettled, Symbol: inst.Symbol, TransactionID: transactionID, Status: e.Status, Amount: tr.Amount, Parties: []string{inst.Issuer, e.Holder}})
+		}
+		put.Status = "Settled"
+	}
+	if missed > 0 {
+		_, err = markDefault(stub, inst, "Missed Put", missed, issuerBalance)
+		if err != nil {
+			return nil, err
+		}
+		b, err := json.Marshal(transactions)
+		if err != nil {
+			return nil, newError(errLedger, "Error while marshalling put transactions")
+		}
+		return b, nil
+	}
+	if inst.Quantity == 0 {
+		inst.Status = "Redeemed"
+		inst.Owner = inst.Issuer
+	}
+	err = putInstrumentState(stub, inst)
+	if err != nil {
+		return nil, err
+	}
+	b, err := json.Marshal(transactions)
+	if err != nil {
+		return nil, newError(errLedger, "Error while marshalling put transactions")
+	}
+	return b, nil
+}
+
+// byPutDate orders a put schedule by date, YYYY-MM-DD sorts as text
+type byPutDate []PutOption
+
+func (p byPutDate) Len() int           { return len(p) }
+func (p byPutDate) Swap(i, j int)      { p[i], p[j] = p[j], p[i] }
+func (p byPutDate) Less(i, j int) bool { return p[i].Date < p[j].Date }
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"testing"
+)
+
+// putFixture has a put on 2017-03-10 at 101 with entity7 and entity8 holding the issue, on 2017-03-01
+func putFixture(t *testing.T) *testStub {
+	s := newTestStub(t, "2017-03-01T10:00:00Z")
+	s.issue(t, testInstrument("INST2001"), map[string]int{entity7: 600, entity8: 400})
+	s.mustInvoke(t, entity1, "setPutSchedule", entity1, "INST2001", "10", "2017-03-10", "101")
+	return s
+}
+
+func TestExercisePut(t *testing.T) {
+	s := putFixture(t)
+	exerciseID := string(s.mustInvoke(t, entity7, "exercisePut", entity7, "INST2001", "2017-03-10", "500"))
+	// notices already given count against the position
+	_, err := s.invoke(entity7, "exercisePut", entity7, "INST2001", "2017-03-10", "200")
+	assertCode(t, err, errInvalidArgument)
+	inst := s.instrument(t, "INST2001")
+	if len(inst.PutExercises) != 1 || inst.PutExercises[0].ExerciseID != exerciseID || inst.PutExercises[0].Status != "Pending" {
+		t.Errorf("exercise not recorded: %+v", inst.PutExercises)
+	}
+	if len(inst.TradeID) != 0 {
+		t.Errorf("exercise added to the trade chain: %v", inst.TradeID)
+	}
+}
+
+func TestPutScheduleRules(t *testing.T) {
+	s := putFixture(t)
+	// a holder cannot give itself a put
+	_, err := s.invoke(entity7, "setPutSchedule", entity7, "INST2001", "10", "2017-03-20", "150")
+	assertCode(t, err, errNotAuthorized)
+	exerciseID := string(s.mustInvoke(t, entity7, "exercisePut", entity7, "INST2001", "03/10/2017", "100"))
+	_, err = s.invoke(entity1, "setPutSchedule", entity1, "INST2001", "10", "2017-03-10", "90")
+	assertCode(t, err, errInvalidState)
+
+	// a later put is added next to the exercised one
+	s.mustInvoke(t, entity1, "setPutSchedule", entity1, "INST2001", "5", "2017-06-12", "102")
+	inst := s.instrument(t, "INST2001")
+	if len(inst.PutSchedule) != 2 || inst.PutSchedule[0].Price != 101 || inst.PutSchedule[1].Date != "2017-06-12" {
+		t.Errorf("schedule not merged: %+v", inst.PutSchedule)
+	}
+	if len(inst.PutExercises) != 1 || inst.PutExercises[0].ExerciseID != exerciseID {
+		t.Errorf("exercise lost: %+v", inst.PutExercises)
+	}
+
+	s.setTime(t, "2017-03-10T10:00:00Z")
+	_, err = s.invoke(entity7, "settlePuts", entity7, "INST2001")
+	assertCode(t, err, errNotAuthorized)
+	s.mustInvoke(t, entity10, "settlePuts", entity10, "INST2001")
+	if s.instrument(t, "INST2001").PutExercises[0].Status != "Settled" {
+		t.Error("Settlement Agent could not settle the put")
+	}
+}
+
+func TestExercisePutOutsideWindow(t *testing.T) {
+	s := putFixture(t)
+	s.setTime(t, "2017-02-27T10:00:00Z")
+	_, err := s.invoke(entity7, "exercisePut", entity7, "INST2001", "2017-03-10", "100")
+	assertCode(t, err, errInvalidState)
+}
+
+func TestSettlePuts(t *testing.T) {
+	s := putFixture(t)
+	s.mustInvoke(t, entity7, "exercisePut", entity7, "INST2001", "2017-03-10", "100")
+	issuer := s.entity(t, entity1).Balance
+	investor := s.entity(t, entity7).Balance
+
+	s.setTime(t, "2017-03-10T10:00:00Z")
+	s.mustInvoke(t, entity1, "settlePuts", entity1, "INST2001")
+	assertBalance(t, s, entity7, investor+10100)
+	assertBalance(t, s, entity1, issuer-10100)
+	if s.position(t, entity7, "INST2001") != 500 {
+		t.Errorf("put units not taken from the holder")
+	}
+	inst := s.instrument(t, "INST2001")
+	e := inst.PutExercises[0]
+	if inst.Quantity != 900 || e.Status != "Settled" || e.SettlementID == "" || inst.PutSchedule[0].Status != "Settled" {
+		t.Errorf("put not settled: %+v", inst)
+	}
+	if len(inst.TradeID) != 0 {
+		t.Errorf("settlement added to the trade chain: %v", inst.TradeID)
+	}
+	s.assertJournalBalanced(t)
+}
+
+func TestExercisedPutIsEarmarked(t *testing.T) {
+	s := putFixture(t)
+	s.mustInvoke(t, entity7, "exercisePut", entity7, "INST2001", "2017-03-10", "100")
+	e := s.instrument(t, "INST2001").PutExercises[0]
+	if e.HoldID == "" || s.entity(t, entity1).HeldBalance != 10100 {
+		t.Fatalf("issuer cash not held for the put: %+v", e)
+	}
+	_, err := s.invoke(entity1, "cancelHold", entity1, e.HoldID)
+	assertCode(t, err, errInvalidState)
+
+	// spending the rest of its cash does not touch what is held for the put
+	s.hold(t, entity1, 0)
+	investor := s.entity(t, entity7).Balance
+	s.setTime(t, "2017-03-10T10:00:00Z")
+	s.mustInvoke(t, entity1, "settlePuts", entity1, "INST2001")
+	assertBalance(t, s, entity7, investor+10100)
+	if s.instrument(t, "INST2001").PutExercises[0].Status != "Settled" {
+		t.Error("earmarked put not settled")
+	}
+	s.assertJournalBalanced(t)
+}
+
+func TestSettlePutsHeldCashDefaults(t *testing.T) {
+	s := putFixture(t)
+	// the cash is held for an open order before the notice, so nothing is left to earmark for the put
+	s.hold(t, entity1, 10000)
+	s.mustInvoke(t, entity7, "exercisePut", entity7, "INST2001", "2017-03-10", "100")
+	investor := s.entity(t, entity7).Balance
+
+	s.setTime(t, "2017-03-10T10:00:00Z")
+	s.mustInvoke(t, entity1, "settlePuts", entity1, "INST2001")
+	inst := s.instrument(t, "INST2001")
+	if inst.Status != "Defaulted" || inst.PutExercises[0].Status != "Failed" {
+		t.Fatalf("put paid out of held cash: %+v", inst)
+	}
+	assertBalance(t, s, entity7, investor)
+	if s.position(t, entity7, "INST2001") != 600 {
+		t.Errorf("holder of a failed put should keep the position")
+	}
+	s.assertJournalBalanced(t)
+}
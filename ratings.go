@@ -0,0 +1,331 @@
+package main
+
+import (
+	"encoding/json"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+// Issuers and instruments carry a credit rating set by the RatingAgency, an instrument without its own rating takes
+// the rating of its issuer. Investors can carry a mandate restricting the issues they may be offered and may take up.
+var ratingScale = []string{
+	"AAA",
+	"AA+", "AA", "AA-",
+	"A+", "A", "A-",
+	"BBB+", "BBB", "BBB-",
+	"BB+", "BB", "BB-",
+	"B+", "B", "B-",
+	"CCC+", "CCC", "CCC-",
+	"CC", "C", "D",
+}
+
+// Mandate restricts the instruments an investor may hold, empty fields do not restrict
+type Mandate struct {
+	MinRating string // lowest acceptable rating, unrated instruments are not eligible when set
+	MaxTenor  string // longest remaining term, "90D", "6M" or "5Y"
+	Callable  string // "Yes" or "No"
+	UpdatedBy string
+	UpdatedAt string
+}
+
+// ratingRank is the position of the rating on the scale, lower is better
+func ratingRank(rating string) (int, bool) {
+	for i, r := range ratingScale {
+		if r == rating {
+			return i, true
+		}
+	}
+	return 0, false
+}
+
+// parseTenor reads a tenor of days, weeks, months or years
+func parseTenor(s string) (years int, months int, days int, err error) {
+	if len(s) < 2 {
+		return 0, 0, 0, newError(errInvalidArgument, "Invalid tenor "+s+", expecting a number followed by D, W, M or Y")
+	}
+	n, err := strconv.Atoi(s[:len(s)-1])
+	if err != nil || n <= 0 {
+		return 0, 0, 0, newError(errInvalidArgument, "Invalid tenor "+s+", expecting a number followed by D, W, M or Y")
+	}
+	switch strings.ToUpper(s[len(s)-1:]) {
+	case "D":
+		return 0, 0, n, nil
+	case "W":
+		return 0, 0, 7 * n, nil
+	case "M":
+		return 0, n, 0, nil
+	case "Y":
+		return n, 0, 0, nil
+	}
+	return 0, 0, 0, newError(errInvalidArgument, "Invalid tenor "+s+", expecting a number followed by D, W, M or Y")
+}
+
+// instrumentRating is the rating of the instrument, or of its issuer when the instrument is not rated
+func instrumentRating(stub shim.ChaincodeStubInterface, inst Instrument) (string, error) {
+	if inst.CreditRating != "" || inst.Issuer == "" {
+		return inst.CreditRating, nil
+	}
+	issuer, err := getEntityState(stub, inst.Issuer)
+	if err != nil {
+		return "", err
+	}
+	return issuer.CreditRating, nil
+}
+
+// mandateBreaches lists the rules of the investor's mandate the instrument does not meet, on the date given
+func mandateBreaches(stub shim.ChaincodeStubInterface, investor Entity, inst Instrument, on time.Time) ([]string, error) {
+	mandate := investor.Mandate
+	if mandate == nil {
+		return nil, nil
+	}
+	var breaches []string
+	if mandate.MinRating != "" {
+		rating, err := instrumentRating(stub, inst)
+		if err != nil {
+			return nil, err
+		}
+		rank, rated := ratingRank(rating)
+		min, _ := ratingRank(mandate.MinRating)
+		if !rated {
+			breaches = append(breaches, "unrated, mandate requires "+mandate.MinRating+" or better")
+		} else if rank > min {
+			breaches = append(breaches, "rated "+rating+", mandate requires "+mandate.MinRating+" or better")
+		}
+	}
+	if mandate.MaxTenor != "" {
+		years, months, days, err := parseTenor(mandate.MaxTenor)
+		if err != nil {
+			return nil, err
+		}
+		maturity, err := parseDate(inst.SettlementDate)
+		if err != nil {
+			return nil, newError(errInvalidState, "Instrument "+inst.Symbol+" has no valid maturity date", "symbol", inst.Symbol)
+		}
+		if maturity.After(on.AddDate(years, months, days)) {
+			breaches = append(breaches, "matures "+inst.SettlementDate+", mandate allows at most "+mandate.MaxTenor)
+		}
+	}
+	if mandate.Callable != "" && !strings.EqualFold(mandate.Callable, inst.Callable) {
+		breaches = append(breaches, "callable "+inst.Callable+", mandate requires callable "+mandate.Callable)
+	}
+	return breaches, nil
+}
+
+//==============================================================================================================================
+//	 checkMandate - Rejects offering the instrument to, or its take up by, an investor whose mandate it breaches.
+//					Other entities and investors without a mandate are not checked.
+//==============================================================================================================================
+func checkMandate(stub shim.ChaincodeStubInterface, entityID string, inst Instrument) error {
+	investor, err := getEntityState(stub, entityID)
+	if err != nil {
+		return err
+	}
+	if investor.EntityType != "Investor" {
+		return nil
+	}
+	on, err := txTime(stub)
+	if err != nil {
+		return err
+	}
+	breaches, err := mandateBreaches(stub, investor, inst, on)
+	if err != nil {
+		return err
+	}
+	if len(breaches) > 0 {
+		return newError(errMandateBreach, inst.Symbol+" is not eligible for "+entityID+": "+strings.Join(breaches, "; "),
+			"entity", entityID,
+			"symbol", inst.Symbol)
+	}
+	return nil
+}
+
+/*	setCreditRating - The RatingAgency rates an issuer or an instrument, an empty rating withdraws it
+		args 0	:	Caller (RatingAgency)
+		args 1	:	Issuer entity or instrument symbol
+		args 2	:	Rating, AAA to D (optional)
+*/
+func (t *SimpleChaincode) setCreditRating(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	if len(args) < 2 || len(args) > 3 {
+		return nil, newError(errArgumentCount, "Incorrect number of arguments")
+	}
+	caller, err := getEntityState(stub, args[0])
+	if err != nil {
+		return nil, err
+	}
+	if caller.EntityType != "RatingAgency" {
+		return nil, newError(errNotAuthorized, "Only the Rating Agency can set credit ratings")
+	}
+	rating := ""
+	if len(args) > 2 {
+		rating = strings.ToUpper(args[2])
+	}
+	if _, ok := ratingRank(rating); !ok && rating != "" {
+		return nil, newError(errInvalidArgument, "Unknown rating "+args[2], "rating", args[2])
+	}
+	// an instrument symbol can be read as an entity too, so the record says what was rated
+	b, err := stub.GetState(args[1])
+	if err != nil {
+		return nil, newError(errLedger, "Error while getting "+args[1]+" from ledger")
+	}
+	fields, ok := documentFields(b)
+	if !ok {
+		return nil, newError(errNotFound, "No issuer or instrument "+args[1], "id", args[1])
+	}
+	docType := documentType(fields)
+	if docType == docEntity {
+		issuer, err := getEntityState(stub, args[1])
+		if err != nil {
+			return nil, err
+		}
+		if issuer.EntityType != "Issuer" {
+			return nil, newError(errInvalidArgument, "Only issuers and instruments are rated", "entity", issuer.EntityID)
+		}
+		previous := issuer.CreditRating
+		issuer.CreditRating = rating
+		err = putEntityState(stub, issuer)
+		if err != nil {
+			return nil, err
+		}
+		emitEvent(stub, MarketEvent{Type: eventRatingChanged, Status: rating, PreviousStatus: previous, Parties: []string{issuer.EntityID}})
+		return nil, nil
+	}
+	if docType != docInstrument {
+		return nil, newError(errInvalidArgument, "Only issuers and instruments are rated", "id", args[1])
+	}
+	inst, err := getInstrumentState(stub, args[1])
+	if err != nil {
+		return nil, err
+	}
+	previous := inst.CreditRating
+	inst.CreditRating = rating
+	err = putInstrumentState(stub, inst)
+	if err != nil {
+		return nil, err
+	}
+	emitEvent(stub, MarketEvent{Type: eventRatingChanged, Symbol: inst.Symbol, Status: rating, PreviousStatus: previous, Parties: []string{inst.Issuer, inst.Owner}})
+	return nil, nil
+}
+
+/*	setMandate - Sets the investment mandate of an investor, all rules empty removes it
+		args 0	:	Caller (Admin)
+		args 1	:	Investor
+		args 2	:	Minimum rating (optional)
+		args 3	:	Maximum tenor, e.g. 5Y (optional)
+		args 4	:	Callable Yes/No (optional)
+*/
+func (t *SimpleChaincode) setMandate(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	if len(args) < 2 || len(args) > 5 {
+		return nil, newError(errArgumentCount, "Incorrect number of arguments")
+	}
+	caller, err := getEntityState(stub, args[0])
+	if err != nil {
+		return nil, err
+	}
+	// the mandate restricts the investor, so it is not the investor's to change
+	if caller.EntityType != "Admin" {
+		return nil, newError(errNotAuthorized, "Only the Admin can set a mandate")
+	}
+	investor, err := getEntityState(stub, args[1])
+	if err != nil {
+		return nil, err
+	}
+	if investor.EntityType != "Investor" {
+		return nil, newError(errInvalidArgument, "Mandates apply to investors only", "entity", investor.EntityID)
+	}
+	rule := func(i int) string {
+		if len(args) > i {
+			return args[i]
+		}
+		return ""
+	}
+	mandate := Mandate{MinRating: strings.ToUpper(rule(2)), MaxTenor: strings.ToUpper(rule(3)), Callable: rule(4)}
+	if _, ok := ratingRank(mandate.MinRating); !ok && mandate.MinRating != "" {
+		return nil, newError(errInvalidArgument, "Unknown rating "+rule(2), "rating", rule(2))
+	}
+	if mandate.MaxTenor != "" {
+		if _, _, _, err = parseTenor(mandate.MaxTenor); err != nil {
+			return nil, err
+		}
+	}
+	if mandate.Callable != "" && mandate.Callable != "Yes" && mandate.Callable != "No" {
+		return nil, newError(errInvalidArgument, "Callable should be Yes or No", "callable", mandate.Callable)
+	}
+	if mandate.MinRating == "" && mandate.MaxTenor == "" && mandate.Callable == "" {
+		investor.Mandate = nil
+		return nil, putEntityState(stub, investor)
+	}
+	mandate.UpdatedBy = caller.EntityID
+	mandate.UpdatedAt, err = txTimeStamp(stub)
+	if err != nil {
+		return nil, err
+	}
+	investor.Mandate = &mandate
+	return nil, putEntityState(stub, investor)
+}
+
+/*	getEligibleInvestors - The investors an instrument may be published to, with the reasons the others are not
+		args 0	:	Caller
+		args 1	:	Symbol
+		args 2	:	As of date (optional, the transaction date when omitted), remaining tenor is measured from it
+*/
+func (t *SimpleChaincode) getEligibleInvestors(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	if len(args) < 2 || len(args) > 3 {
+		return nil, newError(errArgumentCount, "Incorrect number of arguments")
+	}
+	_, err := getEntityState(stub, args[0])
+	if err != nil {
+		return nil, err
+	}
+	inst, err := getInstrumentState(stub, args[1])
+	if err != nil {
+		return nil, err
+	}
+	var on time.Time
+	if len(args) > 2 && args[2] != "" {
+		on, err = parseDate(args[2])
+		if err != nil {
+			return nil, newError(errInvalidArgument, "Invalid date "+args[2]+", expecting YYYY-MM-DD", "date", args[2])
+		}
+	} else {
+		on, err = txTime(stub)
+		if err != nil {
+			return nil, err
+		}
+	}
+	var allEntities []string
+	listByte, err := stub.GetState("entityList")
+	if err != nil {
+		return nil, newError(errLedger, "Error while getting entity list from ledger")
+	}
+	err = json.Unmarshal(listByte, &allEntities)
+	if err != nil {
+		return nil, newError(errLedger, "Error while unmarshalling entity list")
+	}
+	eligible := struct {
+		Symbol     string
+		Eligible   []string
+		Ineligible map[string][]string
+	}{Symbol: inst.Symbol, Eligible: []string{}, Ineligible: make(map[string][]string)}
+	for _, id := range allEntities {
+		investor, err := getEntityState(stub, id)
+		if err != nil {
+			return nil, err
+		}
+		if investor.EntityType != "Investor" {
+			continue
+		}
+		breaches, err := mandateBreaches(stub, investor, inst, on)
+		if err != nil {
+			return nil, err
+		}
+		if len(breaches) > 0 {
+			eligible.Ineligible[id] = breaches
+		} else {
+			eligible.Eligible = append(eligible.Eligible, id)
+		}
+	}
+	return marshalReport(eligible)
+}
@@ -0,0 +1,41 @@
+package main
+
+import "testing"
+
+func TestRateInstrument(t *testing.T) {
+	s := newTestStub(t, "2017-03-01T10:00:00Z")
+	s.issue(t, testInstrument("INST1001"), nil)
+	s.mustInvoke(t, entity13, "setCreditRating", entity13, "INST1001", "aa")
+	if rating := s.instrument(t, "INST1001").CreditRating; rating != "AA" {
+		t.Errorf("instrument rated %q, expected AA", rating)
+	}
+	s.mustInvoke(t, entity13, "setCreditRating", entity13, entity1, "BBB")
+	if rating := s.entity(t, entity1).CreditRating; rating != "BBB" {
+		t.Errorf("issuer rated %q, expected BBB", rating)
+	}
+	if rating := s.instrument(t, "INST1001").CreditRating; rating != "AA" {
+		t.Errorf("rating the issuer changed the instrument's rating to %q", rating)
+	}
+}
+
+func TestRatingRules(t *testing.T) {
+	s := newTestStub(t, "2017-03-01T10:00:00Z")
+	_, err := s.invoke(entity1, "setCreditRating", entity1, entity1, "AAA")
+	assertCode(t, err, errNotAuthorized)
+	_, err = s.invoke(entity13, "setCreditRating", entity13, entity7, "AAA")
+	assertCode(t, err, errInvalidArgument)
+	_, err = s.invoke(entity13, "setCreditRating", entity13, "INST9999", "AAA")
+	assertCode(t, err, errNotFound)
+	_, err = s.invoke(entity13, "setCreditRating", entity13, entity1, "ZZ")
+	assertCode(t, err, errInvalidArgument)
+}
+
+func TestMandateIsAdminOnly(t *testing.T) {
+	s := newTestStub(t, "2017-03-01T10:00:00Z")
+	_, err := s.invoke(entity7, "setMandate", entity7, entity7, "A")
+	assertCode(t, err, errNotAuthorized)
+	s.mustInvoke(t, entity11, "setMandate", entity11, entity7, "A")
+	if m := s.entity(t, entity7).Mandate; m == nil || m.MinRating != "A" {
+		t.Errorf("mandate not set: %+v", m)
+	}
+}
@@ -0,0 +1,485 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"math/rand"
+	"strconv"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+// holding is the position of a single holder in an instrument
+type holding struct {
+	EntityID string
+	Quantity int
+}
+
+// ==============================================================================================================================
+//
+//	 getHoldings - Collects every Bank/Investor position in the instrument from the entity portfolios. When nobody
+//				   carries the instrument in a portfolio the current Owner is treated as holding the full quantity
+//				   the issuer has not bought back, the same way payCoupon and issueCallout settle with the Owner.
+//
+// ==============================================================================================================================
+func getHoldings(stub shim.ChaincodeStubInterface, inst Instrument) ([]holding, error) {
+	var allEntities []string
+	listByte, err := stub.GetState("entityList")
+	if err != nil {
+		return nil, newError(errLedger, "Error while getting entity list from ledger")
+	}
+	err = json.Unmarshal(listByte, &allEntities)
+	if err != nil {
+		return nil, newError(errLedger, "Error while unmarshalling entity list")
+	}
+	var holdings []holding
+	for _, id := range allEntities {
+		if id == inst.Issuer {
+			continue
+		}
+		entity, err := getEntityState(stub, id)
+		if err != nil {
+			return nil, err
+		}
+		quantity := 0
+		for _, stock := range entity.Portfolio {
+			if stock.Symbol == inst.Symbol && stock.Quantity > 0 {
+				quantity = quantity + stock.Quantity
+			}
+		}
+		if quantity > 0 {
+			holdings = append(holdings, holding{EntityID: id, Quantity: quantity})
+		}
+	}
+	if len(holdings) == 0 && inst.Owner != "" && inst.Owner != inst.Issuer && inst.Quantity > inst.Repurchased {
+		holdings = append(holdings, holding{EntityID: inst.Owner, Quantity: inst.Quantity - inst.Repurchased})
+	}
+	return holdings, nil
+}
+
+// allocateProRata splits quantity across holders in proportion to their holdings. Units lost to rounding
+// go one at a time to the largest holders, earliest in the entity list first.
+func allocateProRata(holdings []holding, quantity int) []int {
+	alloc := make([]int, len(holdings))
+	total := 0
+	for _, h := range holdings {
+		total = total + h.Quantity
+	}
+	if total == 0 {
+		return alloc
+	}
+	if quantity > total {
+		quantity = total
+	}
+	allocated := 0
+	for i, h := range holdings {
+		alloc[i] = int(int64(quantity) * int64(h.Quantity) / int64(total))
+		allocated = allocated + alloc[i]
+	}
+	for allocated < quantity {
+		best := -1
+		for i, h := range holdings {
+			if alloc[i] >= h.Quantity {
+				continue
+			}
+			if best == -1 || h.Quantity > holdings[best].Quantity {
+				best = i
+			}
+		}
+		if best == -1 {
+			break
+		}
+		alloc[best]++
+		allocated++
+	}
+	return alloc
+}
+
+// allocateLottery draws quantity units one at a time, each outstanding unit having the same chance of being
+// drawn. The draw is seeded from the seed string so that every peer selects the same units.
+func allocateLottery(holdings []holding, quantity int, seed string) []int {
+	alloc := make([]int, len(holdings))
+	remaining := 0
+	for _, h := range holdings {
+		remaining = remaining + h.Quantity
+	}
+	sum := sha256.Sum256([]byte(seed))
+	r := rand.New(rand.NewSource(int64(binary.BigEndian.Uint64(sum[:8]))))
+	for n := 0; n < quantity && remaining > 0; n++ {
+		pick := r.Intn(remaining)
+		for i, h := range holdings {
+			left := h.Quantity - alloc[i]
+			if pick < left {
+				alloc[i]++
+				break
+			}
+			pick = pick - left
+		}
+		remaining--
+	}
+	return alloc
+}
+
+// reducePosition removes quantity units of symbol from the entity's portfolio
+func reducePosition(stub shim.ChaincodeStubInterface, entityID string, symbol string, quantity int) error {
+	entity, err := getEntityState(stub, entityID)
+	if err != nil {
+		return err
+	}
+	for i := 0; i < len(entity.Portfolio) && quantity > 0; i++ {
+		if entity.Portfolio[i].Symbol != symbol || entity.Portfolio[i].Quantity <= 0 {
+			continue
+		}
+		q := entity.Portfolio[i].Quantity
+		if q > quantity {
+			q = quantity
+		}
+		entity.Portfolio[i].Quantity = entity.Portfolio[i].Quantity - q
+		quantity = quantity - q
+	}
+	return putEntityState(stub, entity)
+}
+
+/*	setRedemptionSchedule
+		args 0	:	Caller (Issuer of the instrument)
+		args 1	:	Symbol
+		args 2	:	Redemption type Amortizing/SinkingFund
+		args 3	:	Allocation method ProRata/Lottery
+		args 4	:	Redemption date (YYYY-MM-DD)
+		args 5	:	Quantity redeemed on that date
+		...		:	further date / quantity pairs
+*/
+func (t *SimpleChaincode) setRedemptionSchedule(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	if len(args) < 6 || len(args)%2 != 0 {
+		return nil, newError(errArgumentCount, "Incorrect number of arguments")
+	}
+	caller := args[0]
+	inst, err := getInstrumentState(stub, args[1])
+	if err != nil {
+		return nil, err
+	}
+	// the schedule is what the holders are owed, so a holder cannot rewrite it
+	if caller != inst.Issuer {
+		return nil, newError(errNotAuthorized, "Only the Issuer can set the redemption schedule")
+	}
+	if args[2] != "Amortizing" && args[2] != "SinkingFund" {
+		return nil, newError(errInvalidArgument, "Redemption type should be Amortizing or SinkingFund")
+	}
+	if args[3] != "ProRata" && args[3] != "Lottery" {
+		return nil, newError(errInvalidArgument, "Redemption method should be ProRata or Lottery")
+	}
+	for _, r := range inst.RedemptionSchedule {
+		if r.Status == "Redeemed" {
+			return nil, newError(errInvalidState, "Redemption schedule cannot be changed after a redemption")
+		}
+	}
+
+	var schedule []Redemption
+	total := 0
+	for i := 4; i < len(args); i = i + 2 {
+		unadjusted, err := normalizeDate(args[i])
+		if err != nil {
+			return nil, err
+		}
+		date, err := rollInstrumentDate(stub, inst, unadjusted)
+		if err != nil {
+			return nil, err
+		}
+		q, err := strconv.Atoi(args[i+1])
+		if err != nil || q <= 0 {
+			return nil, newError(errInvalidArgument, "Invalid redemption quantity " + args[i+1])
+		}
+		total = total + q
+		schedule = append(schedule, Redemption{Date: date, UnadjustedDate: unadjusted, Quantity: q, Status: "Scheduled"})
+	}
+	if total > inst.Quantity {
+		return nil, newError(errInvalidArgument, "Scheduled redemptions exceed the outstanding quantity of " + inst.Symbol)
+	}
+
+	inst.Redemption = args[2]
+	inst.RedemptionMethod = args[3]
+	inst.RedemptionSchedule = schedule
+	err = putInstrumentState(stub, inst)
+	if err != nil {
+		return nil, err
+	}
+	return nil, nil
+}
+
+/*	redeemPrincipal - repays every scheduled redemption that has fallen due. A sinking fund first retires the units
+					  the issuer bought back, only the rest is drawn from the holders and paid.
+		args 0	:	Caller (Issuer of the instrument)
+		args 1	:	Symbol
+*/
+func (t *SimpleChaincode) redeemPrincipal(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	if len(args) != 2 {
+		return nil, newError(errArgumentCount, "Incorrect number of arguments")
+	}
+	inst, err := getInstrumentState(stub, args[1])
+	if err != nil {
+		return nil, err
+	}
+	if args[0] != inst.Issuer {
+		return nil, newError(errNotAuthorized, "Only the Issuer can redeem principal")
+	}
+	if inst.Status == "Expired" || inst.Status == "Redeemed" || inst.Status == "Defaulted" {
+		return nil, newError(errInvalidState, "Instrument " + inst.Symbol + " is no longer outstanding")
+	}
+
+	now, err := txTime(stub)
+	if err != nil {
+		return nil, err
+	}
+	var transactions []string
+	for i := range inst.RedemptionSchedule {
+		r := &inst.RedemptionSchedule[i]
+		if r.Status != "Scheduled" {
+			continue
+		}
+		due, err := parseDate(r.Date)
+		if err != nil {
+			return nil, newError(errLedger, "Invalid redemption date " + r.Date)
+		}
+		if due.After(now) {
+			continue
+		}
+		quantity := r.Quantity
+		if quantity > inst.Quantity {
+			quantity = inst.Quantity
+		}
+		retired := 0
+		if inst.Redemption == "SinkingFund" {
+			retired = inst.Repurchased
+			if retired > quantity {
+				retired = quantity
+			}
+			quantity = quantity - retired
+		}
+
+		holdings, err := getHoldings(stub, inst)
+		if err != nil {
+			return nil, err
+		}
+		var alloc []int
+		if inst.RedemptionMethod == "Lottery" {
+			alloc = allocateLottery(holdings, quantity, inst.Symbol+"/"+r.Date+"/"+strconv.Itoa(inst.Quantity))
+		} else {
+			alloc = allocateProRata(holdings, quantity)
+		}
+
+		redeemed := 0
+		for j := range holdings {
+			redeemed = redeemed + alloc[j]
+		}
+		amountDue := float64(redeemed) * inst.InstrumentPrice
+		issuer, err := getEntityState(stub, inst.Issuer)
+		if err != nil {
+			return nil, err
+		}
+		// cash held for open orders is not the issuer's to pay out
+		available := issuer.Balance - issuer.HeldBalance
+		if available < amountDue {
+			// redemptions already paid in this call stay paid, the missed one defaults the issue
+			eventID, err := markDefault(stub, inst, "Missed Redemption", amountDue, available)
+			if err != nil {
+				return nil, err
+			}
+			return []byte(eventID), nil
+		}
+
+		for j, h := range holdings {
+			if alloc[j] == 0 {
+				continue
+			}
+			amount := float64(alloc[j]) * inst.InstrumentPrice
+			transactionID, err := nextTransactionID(stub)
+			if err != nil {
+				return nil, err
+			}
+			_, err = postJournalEntry(stub, h.EntityID, inst.Issuer, amount, "Scheduled Redemption", transactionID)
+			if err != nil {
+				return nil, err
+			}
+			err = reducePosition(stub, h.EntityID, inst.Symbol, alloc[j])
+			if err != nil {
+				return nil, err
+			}
+			tr := Transaction{
+				TransactionID:   transactionID,
+				TransactionType: "Redemption",
+				FromUser:        inst.Issuer,
+				ToUser:          h.EntityID,
+				Symbol:          inst.Symbol,
+				Quantity:        alloc[j],
+				InstrumentPrice: inst.InstrumentPrice,
+				Rate:            inst.Rate,
+				Amount:          amount,
+				Status:          "Success",
+			}
+			err = recordTransaction(stub, tr)
+			if err != nil {
+				return nil, err
+			}
+			r.TransactionID = append(r.TransactionID, transactionID)
+			transactions = append(transactions, transactionID)
+			emitEvent(stub, MarketEvent{Type: eventPrincipalRedeemed, Symbol: inst.Symbol, TransactionID: transactionID, Amount: amount, Parties: []string{inst.Issuer, h.EntityID}})
+		}
+		inst.Quantity = inst.Quantity - redeemed - retired
+		inst.Repurchased = inst.Repurchased - retired
+		r.Retired = retired
+		r.Status = "Redeemed"
+	}
+	if inst.Quantity == 0 {
+		inst.Status = "Redeemed"
+		inst.Owner = inst.Issuer
+	}
+	err = putInstrumentState(stub, inst)
+	if err != nil {
+		return nil, err
+	}
+	b, err := json.Marshal(transactions)
+	if err != nil {
+		return nil, newError(errLedger, "Error while marshalling redemption transactions")
+	}
+	return b, nil
+}
+
+/*	offerRepurchase - Issuer of a sinking fund offers to buy units back at a price, the units tendered are held apart
+					  and retired on the next redemption dates. The cash for the units wanted is held, the offer lapses
+					  when the hold does.
+		args 0	:	Caller (Issuer of the instrument)
+		args 1	:	Symbol
+		args 2	:	Price per unit
+		args 3	:	Quantity wanted, 0 withdraws the offer
+*/
+func (t *SimpleChaincode) offerRepurchase(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	if len(args) != 4 {
+		return nil, newError(errArgumentCount, "Incorrect number of arguments")
+	}
+	inst, err := getInstrumentState(stub, args[1])
+	if err != nil {
+		return nil, err
+	}
+	if args[0] != inst.Issuer {
+		return nil, newError(errNotAuthorized, "Only the Issuer can offer to repurchase units")
+	}
+	if inst.Redemption != "SinkingFund" {
+		return nil, newError(errInvalidState, "Instrument " + inst.Symbol + " has no sinking fund")
+	}
+	if inst.Status == "Expired" || inst.Status == "Redeemed" || inst.Status == "Defaulted" {
+		return nil, newError(errInvalidState, "Instrument " + inst.Symbol + " is no longer outstanding")
+	}
+	price, err := strconv.ParseFloat(args[2], 64)
+	if err != nil || price <= 0 {
+		return nil, newError(errInvalidArgument, "Invalid repurchase price " + args[2])
+	}
+	quantity, err := strconv.Atoi(args[3])
+	if err != nil || quantity < 0 {
+		return nil, newError(errInvalidArgument, "Invalid repurchase quantity " + args[3])
+	}
+	if quantity > inst.Quantity - inst.Repurchased {
+		return nil, newError(errInvalidArgument, "Repurchase exceeds the units held of " + inst.Symbol)
+	}
+
+	// a new offer replaces the old one and the cash held for it
+	var tenders []string
+	if inst.RepurchaseOffer != nil {
+		tenders = inst.RepurchaseOffer.TransactionID
+		hold, err := getHold(stub, inst.RepurchaseOffer.HoldID)
+		if err != nil {
+			return nil, err
+		}
+		if hold.Status == "Active" {
+			err = releaseHold(stub, hold.HoldID, "Released")
+			if err != nil {
+				return nil, err
+			}
+		}
+		inst.RepurchaseOffer = nil
+	}
+	if quantity > 0 {
+		holdID, err := placeHold(stub, inst.Issuer, float64(quantity)*price, holdRepurchase, inst.Symbol)
+		if err != nil {
+			return nil, err
+		}
+		inst.RepurchaseOffer = &RepurchaseOffer{Price: price, Quantity: quantity, HoldID: holdID, TransactionID: tenders}
+	}
+	err = putInstrumentState(stub, inst)
+	if err != nil {
+		return nil, err
+	}
+	return nil, nil
+}
+
+/*	tenderUnits - Holder sells units into the issuer's repurchase offer, paid at the offered price
+		args 0	:	Caller (holder)
+		args 1	:	Symbol
+		args 2	:	Quantity
+*/
+func (t *SimpleChaincode) tenderUnits(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	if len(args) != 3 {
+		return nil, newError(errArgumentCount, "Incorrect number of arguments")
+	}
+	caller := args[0]
+	inst, err := getInstrumentState(stub, args[1])
+	if err != nil {
+		return nil, err
+	}
+	offer := inst.RepurchaseOffer
+	if offer == nil || inst.Status == "Expired" || inst.Status == "Redeemed" || inst.Status == "Defaulted" {
+		return nil, newError(errInvalidState, "No repurchase offer open on " + inst.Symbol)
+	}
+	quantity, err := strconv.Atoi(args[2])
+	if err != nil || quantity <= 0 {
+		return nil, newError(errInvalidArgument, "Invalid tender quantity " + args[2])
+	}
+	if quantity > offer.Quantity {
+		return nil, newError(errInvalidArgument, "Only " + strconv.Itoa(offer.Quantity) + " units of " + inst.Symbol + " are wanted")
+	}
+	holder, err := getEntityState(stub, caller)
+	if err != nil {
+		return nil, err
+	}
+	if positions(holder.Portfolio)[inst.Symbol] < quantity {
+		return nil, newError(errInvalidArgument, caller + " holds fewer than " + args[2] + " units of " + inst.Symbol)
+	}
+	amount := float64(quantity) * offer.Price
+
+	// the tender is paid out of the cash held for the offer
+	transactionID, err := nextTransactionID(stub)
+	if err != nil {
+		return nil, err
+	}
+	err = drawOnHold(stub, offer.HoldID, amount, caller, "Sinking Fund Repurchase", transactionID)
+	if err != nil {
+		return nil, err
+	}
+	err = reducePosition(stub, caller, inst.Symbol, quantity)
+	if err != nil {
+		return nil, err
+	}
+	err = recordTransaction(stub, Transaction{
+		TransactionID:   transactionID,
+		TransactionType: "Repurchase",
+		FromUser:        inst.Issuer,
+		ToUser:          caller,
+		Symbol:          inst.Symbol,
+		Quantity:        quantity,
+		InstrumentPrice: offer.Price,
+		Amount:          amount,
+		Status:          "Success",
+	})
+	if err != nil {
+		return nil, err
+	}
+	inst.Repurchased = inst.Repurchased + quantity
+	offer.Quantity = offer.Quantity - quantity
+	offer.TransactionID = append(offer.TransactionID, transactionID)
+	err = putInstrumentState(stub, inst)
+	if err != nil {
+		return nil, err
+	}
+	emitEvent(stub, MarketEvent{Type: eventUnitsRepurchased, Symbol: inst.Symbol, TransactionID: transactionID, Amount: amount, Parties: []string{inst.Issuer, caller}})
+	return []byte(transactionID), nil
+}
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestAllocateProRata(t *testing.T) {
+	holdings := []holding{{"a", 5}, {"b", 3}, {"c", 2}}
+	alloc := allocateProRata(holdings, 7)
+	// 3.5, 2.1 and 1.4 round down to 6, the unit left over goes to the largest holder
+	want := []int{4, 2, 1}
+	for i := range want {
+		if alloc[i] != want[i] {
+			t.Fatalf("allocated %v, expected %v", alloc, want)
+		}
+	}
+	alloc = allocateProRata(holdings, 20)
+	for i, h := range holdings {
+		if alloc[i] != h.Quantity {
+			t.Fatalf("allocating more than is held should redeem every holding, got %v", alloc)
+		}
+	}
+}
+
+func TestAllocateLottery(t *testing.T) {
+	holdings := []holding{{"a", 50}, {"b", 30}, {"c", 20}}
+	alloc := allocateLottery(holdings, 40, "INST1001/2017-03-01/100")
+	total := 0
+	for i, h := range holdings {
+		if alloc[i] > h.Quantity {
+			t.Fatalf("%s drawn %d of %d units", h.EntityID, alloc[i], h.Quantity)
+		}
+		total = total + alloc[i]
+	}
+	if total != 40 {
+		t.Fatalf("drew %d units, expected 40", total)
+	}
+	again := allocateLottery(holdings, 40, "INST1001/2017-03-01/100")
+	for i := range alloc {
+		if again[i] != alloc[i] {
+			t.Fatalf("draw is not reproducible: %v and %v", alloc, again)
+		}
+	}
+}
+
+func TestRedeemPrincipal(t *testing.T) {
+	s := newTestStub(t, "2017-03-01T10:00:00Z")
+	s.issue(t, testInstrument("INST2001"), map[string]int{entity7: 600, entity8: 400})
+	s.mustInvoke(t, entity1, "setRedemptionSchedule", entity1, "INST2001", "Amortizing", "ProRata", "2017-03-01", "300", "2017-09-01", "300")
+	issuer := s.entity(t, entity1).Balance
+	investor1 := s.entity(t, entity7).Balance
+	investor2 := s.entity(t, entity8).Balance
+
+	b := s.mustInvoke(t, entity1, "redeemPrincipal", entity1, "INST2001")
+	var transactions []string
+	if err := json.Unmarshal(b, &transactions); err != nil || len(transactions) != 2 {
+		t.Fatalf("expected a payment per holder, got %s", b)
+	}
+	assertBalance(t, s, entity7, investor1+18000)
+	assertBalance(t, s, entity8, investor2+12000)
+	assertBalance(t, s, entity1, issuer-30000)
+	if s.position(t, entity7, "INST2001") != 420 || s.position(t, entity8, "INST2001") != 280 {
+		t.Errorf("positions not reduced pro rata")
+	}
+	inst := s.instrument(t, "INST2001")
+	if inst.Quantity != 700 || inst.RedemptionSchedule[0].Status != "Redeemed" || inst.RedemptionSchedule[1].Status != "Scheduled" {
+		t.Errorf("instrument not updated: %+v", inst)
+	}
+	if len(inst.RedemptionSchedule[0].TransactionID) != 2 || len(inst.TradeID) != 0 {
+		t.Errorf("payments belong to the redemption, not the trade chain: %v %v", inst.RedemptionSchedule[0].TransactionID, inst.TradeID)
+	}
+	s.assertJournalBalanced(t)
+}
+
+func TestRedeemPrincipalIssuerOnly(t *testing.T) {
+	s := newTestStub(t, "2017-03-01T10:00:00Z")
+	s.issue(t, testInstrument("INST2001"), map[string]int{entity7: 1000})
+	// the owner holds the issue, it cannot rewrite what it is owed
+	_, err := s.invoke(entity3, "setRedemptionSchedule", entity3, "INST2001", "Amortizing", "ProRata", "2017-03-01", "300")
+	assertCode(t, err, errNotAuthorized)
+	s.mustInvoke(t, entity1, "setRedemptionSchedule", entity1, "INST2001", "Amortizing", "ProRata", "2017-03-01", "300")
+	_, err = s.invoke(entity7, "redeemPrincipal", entity7, "INST2001")
+	assertCode(t, err, errNotAuthorized)
+}
+
+func TestSinkingFund(t *testing.T) {
+	s := newTestStub(t, "2017-02-01T10:00:00Z")
+	s.issue(t, testInstrument("INST2001"), map[string]int{entity7: 600, entity8: 400})
+	s.mustInvoke(t, entity1, "setRedemptionSchedule", entity1, "INST2001", "SinkingFund", "ProRata", "2017-03-01", "300", "2017-09-01", "300")
+	_, err := s.invoke(entity7, "offerRepurchase", entity7, "INST2001", "95", "200")
+	assertCode(t, err, errNotAuthorized)
+	s.mustInvoke(t, entity1, "offerRepurchase", entity1, "INST2001", "95", "200")
+	issuer := s.entity(t, entity1).Balance
+	investor1 := s.entity(t, entity7).Balance
+	investor2 := s.entity(t, entity8).Balance
+
+	s.mustInvoke(t, entity7, "tenderUnits", entity7, "INST2001", "150")
+	assertBalance(t, s, entity7, investor1+14250)
+	assertBalance(t, s, entity1, issuer-14250)
+	_, err = s.invoke(entity8, "tenderUnits", entity8, "INST2001", "100")
+	assertCode(t, err, errInvalidArgument)
+	inst := s.instrument(t, "INST2001")
+	if inst.Repurchased != 150 || inst.RepurchaseOffer.Quantity != 50 || s.position(t, entity7, "INST2001") != 450 {
+		t.Fatalf("tender not recorded: %+v", inst)
+	}
+
+	// the 150 bought back are retired first, the other 150 are drawn from the holders at par
+	s.setTime(t, "2017-03-01T10:00:00Z")
+	s.mustInvoke(t, entity1, "redeemPrincipal", entity1, "INST2001")
+	assertBalance(t, s, entity7, investor1+14250+8000)
+	assertBalance(t, s, entity8, investor2+7000)
+	if s.position(t, entity7, "INST2001") != 370 || s.position(t, entity8, "INST2001") != 330 {
+		t.Errorf("draw not pro rata over the units still held")
+	}
+	inst = s.instrument(t, "INST2001")
+	if inst.Quantity != 700 || inst.Repurchased != 0 || inst.RedemptionSchedule[0].Retired != 150 {
+		t.Errorf("repurchased units not retired: %+v", inst)
+	}
+	s.assertJournalBalanced(t)
+}
+
+func TestRepurchaseNeedsSinkingFund(t *testing.T) {
+	s := newTestStub(t, "2017-02-01T10:00:00Z")
+	s.issue(t, testInstrument("INST2001"), map[string]int{entity7: 1000})
+	s.mustInvoke(t, entity1, "setRedemptionSchedule", entity1, "INST2001", "Amortizing", "ProRata", "2017-03-01", "300")
+	_, err := s.invoke(entity1, "offerRepurchase", entity1, "INST2001", "95", "200")
+	assertCode(t, err, errInvalidState)
+	_, err = s.invoke(entity7, "tenderUnits", entity7, "INST2001", "100")
+	assertCode(t, err, errInvalidState)
+}
+
+func TestRedeemPrincipalHeldCashDefaults(t *testing.T) {
+	s := newTestStub(t, "2017-03-01T10:00:00Z")
+	s.issue(t, testInstrument("INST2001"), map[string]int{entity7: 1000})
+	s.mustInvoke(t, entity1, "setRedemptionSchedule", entity1, "INST2001", "Amortizing", "ProRata", "2017-03-01", "300")
+	// the balance covers the 30000 due, but all except 10000 of it is held for an open order
+	s.hold(t, entity1, 10000)
+
+	eventID := s.mustInvoke(t, entity1, "redeemPrincipal", entity1, "INST2001")
+	if s.instrument(t, "INST2001").Status != "Defaulted" {
+		t.Fatal("redemption paid out of held cash")
+	}
+	var event CreditEvent
+	b, _ := newUnitOfWork(s).GetState(string(eventID))
+	if err := json.Unmarshal(b, &event); err != nil {
+		t.Fatal(err)
+	}
+	if event.EventType != "Missed Redemption" || event.AmountDue != 30000 || event.IssuerBalance != 10000 {
+		t.Errorf("credit event should report the available balance: %+v", event)
+	}
+	s.assertJournalBalanced(t)
+}
@@ -0,0 +1,431 @@
+package main
+
+import (
+	"encoding/json"
+	"sort"
+	"unicode/utf8"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+// Reports for the Regulatory Body. Each takes the caller and an optional from and to date (YYYY-MM-DD, inclusive),
+// an open side of the range is unbounded. Trades with a sealed price are counted but not valued, the Regulatory Body
+// opens them with unsealRecord, see sealing.go.
+
+// share of the trade value the arranging bank keeps, the rate createIssue and respondToIssue apply
+const commissionRate = .001
+
+// IssuanceLine is the outstanding issuance of one issuer
+type IssuanceLine struct {
+	Issuer      string
+	Instruments int
+	Quantity    int
+	Notional    float64
+}
+
+// HoldingLine is the position of an investor in an instrument at the start and end of the period
+type HoldingLine struct {
+	Investor string
+	Symbol   string
+	Opening  int
+	Closing  int
+}
+
+// VolumeLine is the trading of one symbol on one day
+type VolumeLine struct {
+	Date     string
+	Symbol   string
+	Trades   int
+	Quantity int
+	Value    float64
+	Sealed   int `json:",omitempty"` // trades whose price is sealed and not in Value
+}
+
+// CommissionLine is the commission one bank earned on the trades and issues it arranged
+type CommissionLine struct {
+	Bank       string
+	Trades     int
+	Value      float64
+	Commission float64
+	Sealed     int `json:",omitempty"` // trades whose price is sealed and not in Value or Commission
+}
+
+// ExceptionReport lists the requests that failed and the transactions that failed or were cancelled
+type ExceptionReport struct {
+	FailedRequests []RequestStatus
+	Transactions   []Transaction
+}
+
+// regulatorReport checks the caller is the Regulatory Body and reads the period from args 1 and 2
+func regulatorReport(stub shim.ChaincodeStubInterface, args []string) (dateRange, error) {
+	if len(args) < 1 || len(args) > 3 {
+		return dateRange{}, newError(errArgumentCount, "Incorrect number of arguments")
+	}
+	caller, err := getEntityState(stub, args[0])
+	if err != nil {
+		return dateRange{}, err
+	}
+	if caller.EntityType != "RegBody" {
+		return dateRange{}, newError(errNotAuthorized, "Only the Regulatory Body can run reports")
+	}
+	return parseDateRange(args, 1)
+}
+
+// reportTransactions returns the transactions booked within the period, oldest first
+func reportTransactions(stub shim.ChaincodeStubInterface, period dateRange) ([]Transaction, error) {
+	ids, err := counterIDs(stub, "currentTransactionNum", "trans")
+	if err != nil {
+		return nil, err
+	}
+	var transactions []Transaction
+	for _, id := range ids {
+		b, err := stub.GetState(id)
+		if err != nil {
+			return nil, newError(errLedger, "Error while getting Transaction info from ledger")
+		}
+		if len(b) == 0 {
+			continue
+		}
+		var tr Transaction
+		err = json.Unmarshal(b, &tr)
+		if err != nil {
+			return nil, newError(errLedger, "Error while unmarshalling trades")
+		}
+		if period.containsTimeStamp(tr.TimeStamp) {
+			transactions = append(transactions, tr)
+		}
+	}
+	return transactions, nil
+}
+
+/*	reportIssuance - Instruments issued in the period that are still outstanding, totalled by issuer
+		args 0	:	Caller (RegBody)
+		args 1	:	Issued from (optional)
+		args 2	:	Issued to (optional)
+*/
+func (t *SimpleChaincode) reportIssuance(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	period, err := regulatorReport(stub, args)
+	if err != nil {
+		return nil, err
+	}
+	symbols, err := counterIDs(stub, "currentInstrumentNum", "INST")
+	if err != nil {
+		return nil, err
+	}
+	lines := make(map[string]*IssuanceLine)
+	var issuers []string
+	for _, symbol := range symbols {
+		b, err := stub.GetState(symbol)
+		if err != nil {
+			return nil, newError(errLedger, "Error while getting Instrument info from ledger")
+		}
+		if len(b) == 0 {
+			continue
+		}
+		var inst Instrument
+		err = json.Unmarshal(b, &inst)
+		if err != nil {
+			return nil, newError(errLedger, "Error while unmarshalling Instrument data")
+		}
+		if inst.Status == "Expired" || inst.Status == "Redeemed" || !period.containsDate(inst.IssueDate) {
+			continue
+		}
+		line, ok := lines[inst.Issuer]
+		if !ok {
+			line = &IssuanceLine{Issuer: inst.Issuer}
+			lines[inst.Issuer] = line
+			issuers = append(issuers, inst.Issuer)
+		}
+		line.Instruments++
+		line.Quantity = line.Quantity + inst.Quantity
+		line.Notional = line.Notional + float64(inst.Quantity)*inst.InstrumentPrice
+	}
+	sort.Strings(issuers)
+	report := []IssuanceLine{}
+	for _, issuer := range issuers {
+		report = append(report, *lines[issuer])
+	}
+	return marshalReport(report)
+}
+
+/*	reportHoldings - Positions of every investor per instrument at the start and end of the period, read from the
+					 entity history. Entities not changed since the history began report their current positions.
+		args 0	:	Caller (RegBody)
+		args 1	:	From (optional, opening positions are zero without it)
+		args 2	:	To (optional, closing positions are the current ones without it)
+*/
+func (t *SimpleChaincode) reportHoldings(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	period, err := regulatorReport(stub, args)
+	if err != nil {
+		return nil, err
+	}
+	var allEntities []string
+	listByte, err := stub.GetState("entityList")
+	if err != nil {
+		return nil, newError(errLedger, "Error while getting entity list from ledger")
+	}
+	err = json.Unmarshal(listByte, &allEntities)
+	if err != nil {
+		return nil, newError(errLedger, "Error while unmarshalling entity list")
+	}
+	report := []HoldingLine{}
+	for _, id := range allEntities {
+		entity, err := getEntityState(stub, id)
+		if err != nil {
+			return nil, err
+		}
+		if entity.EntityType != "Investor" {
+			continue
+		}
+		versions, err := getKeyHistory(stub, id)
+		if err != nil {
+			return nil, err
+		}
+		opening := make(map[string]int)
+		if !period.From.IsZero() {
+			// positions at the end of the day before the period
+			opening, err = positionsAt(versions, entity, formatDate(period.From.AddDate(0, 0, -1)))
+			if err != nil {
+				return nil, err
+			}
+		}
+		closing := positions(entity.Portfolio)
+		if !period.To.IsZero() {
+			closing, err = positionsAt(versions, entity, formatDate(period.To))
+			if err != nil {
+				return nil, err
+			}
+		}
+		var symbols []string
+		for symbol := range closing {
+			symbols = append(symbols, symbol)
+		}
+		for symbol := range opening {
+			if _, ok := closing[symbol]; !ok {
+				symbols = append(symbols, symbol)
+			}
+		}
+		sort.Strings(symbols)
+		for _, symbol := range symbols {
+			report = append(report, HoldingLine{Investor: id, Symbol: symbol, Opening: opening[symbol], Closing: closing[symbol]})
+		}
+	}
+	return marshalReport(report)
+}
+
+// positions sums a portfolio by symbol
+func positions(portfolio []Stock) map[string]int {
+	held := make(map[string]int)
+	for _, stock := range portfolio {
+		held[stock.Symbol] = held[stock.Symbol] + stock.Quantity
+	}
+	return held
+}
+
+// positionsAt is the portfolio as it stood at the end of the date, from the last version written on or before it.
+// Before the first version the portfolio is what that version replaced.
+func positionsAt(versions []KeyVersion, current Entity, date string) (map[string]int, error) {
+	if len(versions) == 0 {
+		return positions(current.Portfolio), nil
+	}
+	for i := len(versions) - 1; i >= 0; i-- {
+		if dateOf(versions[i].TimeStamp) > date {
+			continue
+		}
+		if versions[i].Deleted {
+			return map[string]int{}, nil
+		}
+		var entity Entity
+		err := json.Unmarshal(versions[i].Value, &entity)
+		if err != nil {
+			return nil, newError(errLedger, "Error while unmarshalling entity history")
+		}
+		return positions(entity.Portfolio), nil
+	}
+	for _, c := range versions[0].Changes {
+		if c.Field == "Portfolio" {
+			if len(c.Old) == 0 {
+				return map[string]int{}, nil
+			}
+			var stocks []Stock
+			err := json.Unmarshal(c.Old, &stocks)
+			if err != nil {
+				return nil, newError(errLedger, "Error while unmarshalling entity history")
+			}
+			return positions(stocks), nil
+		}
+	}
+	var entity Entity
+	err := json.Unmarshal(versions[0].Value, &entity)
+	if err != nil {
+		return nil, newError(errLedger, "Error while unmarshalling entity history")
+	}
+	return positions(entity.Portfolio), nil
+}
+
+/*	reportVolume - Executed trades per day and symbol
+		args 0	:	Caller (RegBody)
+		args 1	:	From (optional)
+		args 2	:	To (optional)
+*/
+func (t *SimpleChaincode) reportVolume(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	period, err := regulatorReport(stub, args)
+	if err != nil {
+		return nil, err
+	}
+	transactions, err := reportTransactions(stub, period)
+	if err != nil {
+		return nil, err
+	}
+	lines := make(map[string]*VolumeLine)
+	var keys []string
+	for _, tr := range transactions {
+		if tr.TransactionType != "Final" || tr.Status != "Success" {
+			continue
+		}
+		date := dateOf(tr.TimeStamp)
+		key := date + compositeKeySeparator + tr.Symbol
+		line, ok := lines[key]
+		if !ok {
+			line = &VolumeLine{Date: date, Symbol: tr.Symbol}
+			lines[key] = line
+			keys = append(keys, key)
+		}
+		line.Trades++
+		line.Quantity = line.Quantity + tr.Quantity
+		if tr.Sealed != "" {
+			line.Sealed++
+			continue
+		}
+		line.Value = line.Value + float64(tr.Quantity)*tr.InstrumentPrice
+	}
+	sort.Strings(keys)
+	report := []VolumeLine{}
+	for _, key := range keys {
+		report = append(report, *lines[key])
+	}
+	return marshalReport(report)
+}
+
+/*	reportCommissions - Commission earned per bank on the trades executed and instruments issued in the period
+		args 0	:	Caller (RegBody)
+		args 1	:	From (optional)
+		args 2	:	To (optional)
+*/
+func (t *SimpleChaincode) reportCommissions(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	period, err := regulatorReport(stub, args)
+	if err != nil {
+		return nil, err
+	}
+	transactions, err := reportTransactions(stub, period)
+	if err != nil {
+		return nil, err
+	}
+	entityTypes := make(map[string]string)
+	isBank := func(id string) (bool, error) {
+		if _, ok := entityTypes[id]; !ok {
+			entity, err := getEntityState(stub, id)
+			if err != nil {
+				return false, err
+			}
+			entityTypes[id] = entity.EntityType
+		}
+		return entityTypes[id] == "Bank", nil
+	}
+	lines := make(map[string]*CommissionLine)
+	var banks []string
+	for _, tr := range transactions {
+		if (tr.TransactionType != "Final" && tr.TransactionType != "Create Instrument") || tr.Status != "Success" {
+			continue
+		}
+		for _, party := range []string{tr.FromUser, tr.ToUser} {
+			bank, err := isBank(party)
+			if err != nil {
+				return nil, err
+			}
+			if !bank {
+				continue
+			}
+			line, ok := lines[party]
+			if !ok {
+				line = &CommissionLine{Bank: party}
+				lines[party] = line
+				banks = append(banks, party)
+			}
+			line.Trades++
+			if tr.Sealed != "" {
+				line.Sealed++
+				break
+			}
+			value := float64(tr.Quantity) * tr.InstrumentPrice
+			line.Value = line.Value + value
+			line.Commission = line.Commission + value*commissionRate
+			break
+		}
+	}
+	sort.Strings(banks)
+	report := []CommissionLine{}
+	for _, bank := range banks {
+		report = append(report, *lines[bank])
+	}
+	return marshalReport(report)
+}
+
+/*	reportExceptions - Requests recorded as failed and transactions that failed or were cancelled in the period
+		args 0	:	Caller (RegBody)
+		args 1	:	From (optional)
+		args 2	:	To (optional)
+*/
+func (t *SimpleChaincode) reportExceptions(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	period, err := regulatorReport(stub, args)
+	if err != nil {
+		return nil, err
+	}
+	report := ExceptionReport{FailedRequests: []RequestStatus{}, Transactions: []Transaction{}}
+	startKey := createCompositeKey(indexRequestStatus, nil)
+	iter, err := stub.RangeQueryState(startKey, startKey+string(utf8.MaxRune))
+	if err != nil {
+		return nil, newError(errLedger, "Error while scanning request statuses")
+	}
+	defer iter.Close()
+	for iter.HasNext() {
+		_, b, err := iter.Next()
+		if err != nil {
+			return nil, newError(errLedger, "Error while scanning request statuses")
+		}
+		var status RequestStatus
+		err = json.Unmarshal(b, &status)
+		if err != nil {
+			return nil, newError(errLedger, "Error while unmarshalling request status")
+		}
+		if status.Status == statusFailed && period.containsTimeStamp(status.TimeStamp) {
+			report.FailedRequests = append(report.FailedRequests, status)
+		}
+	}
+	// statuses are keyed by request ID, list them in the order they were recorded
+	sort.Stable(byRequestTime(report.FailedRequests))
+	transactions, err := reportTransactions(stub, period)
+	if err != nil {
+		return nil, err
+	}
+	for _, tr := range transactions {
+		if tr.Status == "Failed" || tr.Status == "Cancelled" {
+			report.Transactions = append(report.Transactions, tr)
+		}
+	}
+	return marshalReport(report)
+}
+
+type byRequestTime []RequestStatus
+
+func (s byRequestTime) Len() int           { return len(s) }
+func (s byRequestTime) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+func (s byRequestTime) Less(i, j int) bool { return s[i].TimeStamp < s[j].TimeStamp }
+
+func marshalReport(report interface{}) ([]byte, error) {
+	b, err := json.Marshal(report)
+	if err != nil {
+		return nil, newError(errLedger, "Error while marshalling report")
+	}
+	return b, nil
+}
@@ -0,0 +1,283 @@
+package main
+
+import (
+	"encoding/json"
+	"strconv"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+// Business documents carry the type and schema version they were written in. Writes through the unit of work stamp
+// them, reads through it upgrade older documents to the current version before the handler decodes them, so
+// handlers only ever see the current schema. Documents written before versioning are version 0. The migrate invoke
+// rewrites every document in the current version at once.
+const (
+	docEntity       = "Entity"
+	docInstrument   = "Instrument"
+	docTransaction  = "Transaction"
+	docIoi          = "Ioi"
+	docTrade        = "Trade"
+	docJournalEntry = "JournalEntry"
+	docHold         = "Hold"
+	docCreditEvent  = "CreditEvent"
+)
+
+// migrations[docType][n] upgrades a document of that type from version n to n+1, the current version of a type is
+// its number of migrations. Migrations are only ever appended.
+var migrations = map[string][]func([]byte) ([]byte, error){
+	docEntity:       {sameDocument},
+	docInstrument:   {migrateInstrumentV1},
+	docTransaction:  {migrateTransactionV1},
+	docIoi:          {sameDocument},
+	docTrade:        {sameDocument},
+	docJournalEntry: {migrateJournalEntryV1},
+	docHold:         {migrateHoldV1},
+	docCreditEvent:  {migrateCreditEventV1},
+}
+
+// sameDocument is the migration of a version that only adds the stamp
+func sameDocument(b []byte) ([]byte, error) {
+	return b, nil
+}
+
+// version 1 stores dates as ISO-8601
+func migrateInstrumentV1(b []byte) ([]byte, error) {
+	var inst Instrument
+	err := json.Unmarshal(b, &inst)
+	if err != nil {
+		return nil, err
+	}
+	upgradeInstrumentDates(&inst)
+	return json.Marshal(inst)
+}
+
+func migrateTransactionV1(b []byte) ([]byte, error) {
+	var tr Transaction
+	err := json.Unmarshal(b, &tr)
+	if err != nil {
+		return nil, err
+	}
+	upgradeTransactionDates(&tr)
+	return json.Marshal(tr)
+}
+
+func migrateJournalEntryV1(b []byte) ([]byte, error) {
+	var entry JournalEntry
+	err := json.Unmarshal(b, &entry)
+	if err != nil {
+		return nil, err
+	}
+	entry.TimeStamp = upgradeTimeStamp(entry.TimeStamp)
+	return json.Marshal(entry)
+}
+
+func migrateHoldV1(b []byte) ([]byte, error) {
+	var hold Hold
+	err := json.Unmarshal(b, &hold)
+	if err != nil {
+		return nil, err
+	}
+	hold.CreatedAt = upgradeTimeStamp(hold.CreatedAt)
+	hold.ExpiresAt = upgradeTimeStamp(hold.ExpiresAt)
+	return json.Marshal(hold)
+}
+
+func migrateCreditEventV1(b []byte) ([]byte, error) {
+	var event CreditEvent
+	err := json.Unmarshal(b, &event)
+	if err != nil {
+		return nil, err
+	}
+	event.TimeStamp = upgradeTimeStamp(event.TimeStamp)
+	return json.Marshal(event)
+}
+
+func schemaVersion(docType string) int {
+	return len(migrations[docType])
+}
+
+// documentFields reads the top level fields of a JSON object, ok is false for anything else
+func documentFields(b []byte) (map[string]json.RawMessage, bool) {
+	if len(b) == 0 || b[0] != '{' {
+		return nil, false
+	}
+	var fields map[string]json.RawMessage
+	if json.Unmarshal(b, &fields) != nil {
+		return nil, false
+	}
+	return fields, true
+}
+
+// documentType is the stamped type, or for unversioned documents the type recognised by a field only it has
+func documentType(fields map[string]json.RawMessage) string {
+	var docType string
+	if json.Unmarshal(fields["DocType"], &docType) == nil && docType != "" {
+		return docType
+	}
+	has := func(f string) bool {
+		_, ok := fields[f]
+		return ok
+	}
+	switch {
+	case has("EntityType"):
+		return docEntity
+	case has("IoiId"):
+		return docIoi
+	case has("TransactionType"):
+		return docTransaction
+	case has("TradeType"):
+		return docTrade
+	case has("Coupon") && has("InstrumentPrice"):
+		return docInstrument
+	case has("EntryID") && has("DebitAccount"):
+		return docJournalEntry
+	case has("HoldID") && has("ExpiresAt"):
+		return docHold
+	case has("EventID") && has("AmountDue"):
+		return docCreditEvent
+	}
+	return ""
+}
+
+func documentVersion(fields map[string]json.RawMessage) int {
+	var version int
+	json.Unmarshal(fields["SchemaVersion"], &version)
+	return version
+}
+
+// stampDocument adds the type and current schema version to a business document, other values are kept as they are
+func stampDocument(b []byte) []byte {
+	fields, ok := documentFields(b)
+	if !ok {
+		return b
+	}
+	docType := documentType(fields)
+	if docType == "" {
+		return b
+	}
+	version := schemaVersion(docType)
+	_, typed := fields["DocType"]
+	_, versioned := fields["SchemaVersion"]
+	if !typed && !versioned {
+		// the structs have no stamp fields, so a freshly marshalled document gets them in front
+		stamp := `{"DocType":"` + docType + `","SchemaVersion":` + strconv.Itoa(version)
+		if len(fields) == 0 {
+			return []byte(stamp + "}")
+		}
+		return append([]byte(stamp+","), b[1:]...)
+	}
+	if documentType(fields) == docType && documentVersion(fields) == version {
+		return b
+	}
+	fields["DocType"], _ = json.Marshal(docType)
+	fields["SchemaVersion"], _ = json.Marshal(version)
+	stamped, err := json.Marshal(fields)
+	if err != nil {
+		return b
+	}
+	return stamped
+}
+
+// upgradeDocument migrates a stored document to the current version of its type. Values that are not business
+// documents, and documents written by a newer version, are returned unchanged.
+func upgradeDocument(b []byte) ([]byte, bool, error) {
+	fields, ok := documentFields(b)
+	if !ok {
+		return b, false, nil
+	}
+	docType := documentType(fields)
+	if docType == "" {
+		return b, false, nil
+	}
+	version := documentVersion(fields)
+	if version >= schemaVersion(docType) {
+		return b, false, nil
+	}
+	var err error
+	for v := version; v < schemaVersion(docType); v++ {
+		b, err = migrations[docType][v](b)
+		if err != nil {
+			return nil, false, newError(errLedger, "Error while migrating "+docType+" to version "+strconv.Itoa(v+1))
+		}
+	}
+	return stampDocument(b), true, nil
+}
+
+// ledgerState reads the value as stored, before any upgrade on read
+func ledgerState(stub shim.ChaincodeStubInterface, key string) ([]byte, error) {
+	if u, ok := stub.(*unitOfWork); ok {
+		return u.ChaincodeStubInterface.GetState(key)
+	}
+	return stub.GetState(key)
+}
+
+/*	migrate - Rewrites every business document stored in an older schema version in the current one. Documents
+			  already current are left alone, so running it twice is harmless. Entity lists are moved into indexes by
+			  upgradeIndexes.
+		args 0	:	Caller (Admin)
+*/
+func (t *SimpleChaincode) migrate(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	if len(args) != 1 {
+		return nil, newError(errArgumentCount, "Incorrect number of arguments")
+	}
+	caller, err := getEntityState(stub, args[0])
+	if err != nil {
+		return nil, err
+	}
+	if caller.EntityType != "Admin" {
+		return nil, newError(errNotAuthorized, "Only the Admin can upgrade the ledger")
+	}
+	var allEntities []string
+	listByte, err := stub.GetState("entityList")
+	if err != nil {
+		return nil, newError(errLedger, "Error while getting entity list from ledger")
+	}
+	err = json.Unmarshal(listByte, &allEntities)
+	if err != nil {
+		return nil, newError(errLedger, "Error while unmarshalling entity list")
+	}
+	keys := map[string][]string{docEntity: allEntities}
+	counters := []struct{ docType, counter, prefix string }{
+		{docInstrument, "currentInstrumentNum", "INST"},
+		{docTransaction, "currentTransactionNum", "trans"},
+		{docIoi, "currentIoiNum", "IOI"},
+		{docTrade, "currentTradeNum", "trade"},
+		{docJournalEntry, "currentJournalNum", "JE"},
+		{docHold, "currentHoldNum", "HOLD"},
+		{docCreditEvent, "currentCreditEventNum", "CE"},
+	}
+	for _, c := range counters {
+		keys[c.docType], err = counterIDs(stub, c.counter, c.prefix)
+		if err != nil {
+			return nil, err
+		}
+	}
+	migrated := make(map[string]int)
+	for _, docType := range []string{docEntity, docInstrument, docTransaction, docIoi, docTrade, docJournalEntry, docHold, docCreditEvent} {
+		migrated[docType] = 0
+		for _, key := range keys[docType] {
+			b, err := ledgerState(stub, key)
+			if err != nil {
+				return nil, newError(errLedger, "Error while getting "+key+" from ledger")
+			}
+			// sealed values are migrated as stored, the unit of work keeps them sealed when it writes
+			upgraded, changed, err := upgradeDocument(b)
+			if err != nil {
+				return nil, err
+			}
+			if !changed {
+				continue
+			}
+			err = stub.PutState(key, upgraded)
+			if err != nil {
+				return nil, newError(errLedger, "Error while writing "+key+" to ledger")
+			}
+			migrated[docType]++
+		}
+	}
+	b, err := json.Marshal(migrated)
+	if err != nil {
+		return nil, newError(errLedger, "Error while marshalling migration summary")
+	}
+	return b, nil
+}
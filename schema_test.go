@@ -0,0 +1,100 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+// putLegacyInstrument stores an instrument the way it was written before documents were versioned
+func putLegacyInstrument(t *testing.T, s *testStub) {
+	inst := testInstrument("INST1001")
+	inst.SettlementDate = "03/11/2017"
+	inst.IssueDate = "12/14/2016"
+	b, err := json.Marshal(inst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.MockTransactionStart("legacy")
+	s.MockStub.PutState(inst.Symbol, b)
+	s.MockStub.PutState("currentInstrumentNum", []byte("1001"))
+	s.MockTransactionEnd("legacy")
+}
+
+func TestUpgradeOnRead(t *testing.T) {
+	s := newTestStub(t, "2017-03-01T10:00:00Z")
+	putLegacyInstrument(t, s)
+	inst := s.instrument(t, "INST1001")
+	if inst.SettlementDate != "2017-03-11" || inst.IssueDate != "2016-12-14" {
+		t.Errorf("dates not upgraded on read: %s %s", inst.SettlementDate, inst.IssueDate)
+	}
+	fields, _ := documentFields(s.State["INST1001"])
+	if documentVersion(fields) != 0 {
+		t.Error("a read rewrote the stored document")
+	}
+}
+
+func TestStampDocument(t *testing.T) {
+	b, _ := json.Marshal(Hold{HoldID: "HOLD1001", EntityID: entity7, ExpiresAt: "2017-03-03T10:00:00Z"})
+	fields, ok := documentFields(stampDocument(b))
+	if !ok || documentType(fields) != docHold || documentVersion(fields) != schemaVersion(docHold) {
+		t.Errorf("document not stamped: %s", stampDocument(b))
+	}
+	if string(stampDocument([]byte("1001"))) != "1001" {
+		t.Error("values that are not documents are stamped")
+	}
+}
+
+func TestMigrate(t *testing.T) {
+	s := newTestStub(t, "2017-03-01T10:00:00Z")
+	putLegacyInstrument(t, s)
+	_, err := s.invoke(entity9, "migrate", entity9)
+	assertCode(t, err, errNotAuthorized)
+
+	b := s.mustInvoke(t, entity11, "migrate", entity11)
+	var migrated map[string]int
+	json.Unmarshal(b, &migrated)
+	if migrated[docInstrument] != 1 {
+		t.Errorf("expected the instrument to be migrated: %s", b)
+	}
+	fields, _ := documentFields(s.State["INST1001"])
+	var maturity string
+	json.Unmarshal(fields["SettlementDate"], &maturity)
+	if documentType(fields) != docInstrument || documentVersion(fields) != schemaVersion(docInstrument) || maturity != "2017-03-11" {
+		t.Errorf("stored document not migrated: %s", s.State["INST1001"])
+	}
+	b = s.mustInvoke(t, entity11, "migrate", entity11)
+	json.Unmarshal(b, &migrated)
+	if migrated[docInstrument] != 0 {
+		t.Errorf("second run migrated again: %s", b)
+	}
+}
+
+func TestUpgradesAreForTheAdmin(t *testing.T) {
+	s := newTestStub(t, "2017-03-01T10:00:00Z")
+	for _, function := range []string{"migrate", "upgradeIndexes"} {
+		_, err := s.invoke(entity9, function, entity9)
+		assertCode(t, err, errNotAuthorized)
+		s.mustInvoke(t, entity11, function, entity11)
+	}
+}
+
+func TestMigrateTimeStamps(t *testing.T) {
+	s := newTestStub(t, "2017-03-01T10:00:00Z")
+	b, _ := json.Marshal(Hold{HoldID: "HOLD1001", EntityID: entity7, Amount: 100, Status: "Active", CreatedAt: "2017-03-01 10:00:00", ExpiresAt: "2017-03-02 10:00:00"})
+	s.MockTransactionStart("legacy")
+	s.MockStub.PutState("HOLD1001", b)
+	s.MockStub.PutState("currentHoldNum", []byte("1001"))
+	s.MockTransactionEnd("legacy")
+
+	b = s.mustInvoke(t, entity11, "migrate", entity11)
+	var migrated map[string]int
+	json.Unmarshal(b, &migrated)
+	if migrated[docHold] != 1 {
+		t.Errorf("expected the hold to be migrated: %s", b)
+	}
+	var hold Hold
+	json.Unmarshal(s.State["HOLD1001"], &hold)
+	if hold.CreatedAt != "2017-03-01T10:00:00Z" || hold.ExpiresAt != "2017-03-02T10:00:00Z" {
+		t.Errorf("timestamps not migrated: %s", s.State["HOLD1001"])
+	}
+}
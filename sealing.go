@@ -0,0 +1,263 @@
+package main
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+// Quote prices and rates of Transactions and IOI notionals can be sealed. The client passes a field key in the
+// transaction metadata of an invocation, the key the counterparties of the quote agreed off chain and share with the
+// Regulatory Body. The Transactions and IOIs the invocation writes store those values encrypted with AES-GCM, next
+// to a keyed hash of them, and not in the clear. An invocation reads a sealed record opened when its key opens it and
+// as stored otherwise, and refuses to copy a quote it could not open. Queries never open sealed values; unsealRecord
+// decrypts a record for a counterparty or the Regulatory Body with the key they pass. The metadata travels with the
+// transaction, so a network that seals should run with transaction confidentiality (security.privacy) enabled.
+//
+// Fabric v0.6 has no private data collections, every validating peer holds the whole world state. Sealing is what
+// this chaincode offers in their place: the quote details stay on the shared ledger, readable only with the key of
+// the counterparties, with the digest public for integrity checks. Entity balances are not sealed. Every cash
+// movement, hold and journal entry that makes them up is on the shared ledger, so they stay public until the
+// network moves to a Fabric release with private data.
+
+// CallerMetadata is the JSON the client passes as transaction metadata
+type CallerMetadata struct {
+	FieldKey string // base64 AES-128, AES-192 or AES-256 key
+}
+
+// sealedFields are the top level fields of each document type that are sealed
+var sealedFields = map[string][]string{
+	docTransaction: {"InstrumentPrice", "Rate"},
+	docIoi:         {"Notional"},
+}
+
+// fieldKeyFromMetadata returns the field key of the invocation, nil when it has none
+func fieldKeyFromMetadata(stub shim.ChaincodeStubInterface) ([]byte, error) {
+	b, err := stub.GetCallerMetadata()
+	if err != nil || len(b) == 0 {
+		return nil, nil
+	}
+	var metadata CallerMetadata
+	err = json.Unmarshal(b, &metadata)
+	if err != nil {
+		return nil, newError(errInvalidArgument, "Transaction metadata should be a JSON object")
+	}
+	if metadata.FieldKey == "" {
+		return nil, nil
+	}
+	return parseFieldKey(metadata.FieldKey)
+}
+
+func parseFieldKey(s string) ([]byte, error) {
+	key, err := base64.StdEncoding.DecodeString(s)
+	if err != nil || (len(key) != 16 && len(key) != 24 && len(key) != 32) {
+		return nil, newError(errInvalidArgument, "Field key should be a base64 AES key of 16, 24 or 32 bytes")
+	}
+	return key, nil
+}
+
+func sealedDigest(key []byte, plain []byte) string {
+	mac := hmac.New(sha256.New, key)
+	mac.Write(plain)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// sealValues encrypts the values. Every endorser has to produce the same ciphertext, so the nonce is derived from
+// the transaction and the record rather than drawn at random; the unit of work seals a record once, when it flushes.
+func sealValues(key []byte, txID string, recordKey string, plain []byte) (string, string, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return "", "", newError(errInvalidArgument, "Invalid field key")
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return "", "", newError(errInternal, "Error while preparing encryption")
+	}
+	seed := sha256.Sum256([]byte(txID + compositeKeySeparator + recordKey))
+	nonce := seed[:gcm.NonceSize()]
+	sealed := gcm.Seal(append([]byte{}, nonce...), nonce, plain, []byte(recordKey))
+	return base64.StdEncoding.EncodeToString(sealed), sealedDigest(key, plain), nil
+}
+
+// openValues decrypts sealed values and checks them against the digest kept in the clear
+func openValues(key []byte, recordKey string, sealed string, digest string) (map[string]json.RawMessage, error) {
+	b, err := base64.StdEncoding.DecodeString(sealed)
+	if err != nil {
+		return nil, newError(errLedger, "Sealed values of "+recordKey+" are corrupt")
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, newError(errInvalidArgument, "Invalid field key")
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil || len(b) < gcm.NonceSize() {
+		return nil, newError(errLedger, "Sealed values of "+recordKey+" are corrupt")
+	}
+	plain, err := gcm.Open(nil, b[:gcm.NonceSize()], b[gcm.NonceSize():], []byte(recordKey))
+	if err != nil {
+		return nil, newError(errNotAuthorized, "Field key does not open the sealed values of "+recordKey)
+	}
+	if !hmac.Equal([]byte(sealedDigest(key, plain)), []byte(digest)) {
+		return nil, newError(errInvalidState, "Sealed values of "+recordKey+" do not match their digest")
+	}
+	var values map[string]json.RawMessage
+	err = json.Unmarshal(plain, &values)
+	if err != nil {
+		return nil, newError(errLedger, "Error while unmarshalling sealed values")
+	}
+	return values, nil
+}
+
+// isSealed is true for a document stored with sealed values
+func isSealed(b []byte) bool {
+	fields, ok := documentFields(b)
+	if !ok {
+		return false
+	}
+	var sealed string
+	return json.Unmarshal(fields["Sealed"], &sealed) == nil && sealed != ""
+}
+
+// requireOpened fails for a record whose sealed values the invocation's key did not open, a handler copying them
+// would otherwise copy zeros
+func requireOpened(recordKey string, sealed string) error {
+	if sealed == "" {
+		return nil
+	}
+	return newError(errNotAuthorized, "Values of "+recordKey+" are sealed, pass their field key in the metadata", "id", recordKey)
+}
+
+// sealDocument moves the sealed fields of a document into its Sealed value, so they are not stored in the clear at
+// all. A document that was read sealed and not opened keeps its sealed values, the zeros it was decoded with are
+// dropped. Without a key, other values, and documents with none of the fields set, are returned unchanged.
+func sealDocument(key []byte, txID string, recordKey string, b []byte) ([]byte, error) {
+	fields, ok := documentFields(b)
+	if !ok {
+		return b, nil
+	}
+	if isSealed(b) {
+		for _, f := range sealedFields[documentType(fields)] {
+			delete(fields, f)
+		}
+		return json.Marshal(fields)
+	}
+	if key == nil {
+		return b, nil
+	}
+	values := make(map[string]json.RawMessage)
+	for _, f := range sealedFields[documentType(fields)] {
+		if v, ok := fields[f]; ok {
+			values[f] = v
+			delete(fields, f)
+		}
+	}
+	if len(values) == 0 {
+		return b, nil
+	}
+	plain, err := json.Marshal(values)
+	if err != nil {
+		return nil, newError(errLedger, "Error while marshalling sealed values")
+	}
+	sealed, digest, err := sealValues(key, txID, recordKey, plain)
+	if err != nil {
+		return nil, err
+	}
+	fields["Sealed"], _ = json.Marshal(sealed)
+	fields["SealedDigest"], _ = json.Marshal(digest)
+	return json.Marshal(fields)
+}
+
+// unsealDocument puts the sealed values of a document back in place, values that are not sealed are returned
+// unchanged. A sealed document cannot be read without the key.
+func unsealDocument(key []byte, recordKey string, b []byte) ([]byte, error) {
+	fields, ok := documentFields(b)
+	if !ok {
+		return b, nil
+	}
+	var sealed, digest string
+	if json.Unmarshal(fields["Sealed"], &sealed) != nil || sealed == "" {
+		return b, nil
+	}
+	if key == nil {
+		return nil, newError(errNotAuthorized, "Values of "+recordKey+" are sealed, a field key is needed to open them", "key", recordKey)
+	}
+	json.Unmarshal(fields["SealedDigest"], &digest)
+	values, err := openValues(key, recordKey, sealed, digest)
+	if err != nil {
+		return nil, err
+	}
+	delete(fields, "Sealed")
+	delete(fields, "SealedDigest")
+	for f, v := range values {
+		fields[f] = v
+	}
+	return json.Marshal(fields)
+}
+
+/*	unsealRecord - A Transaction or IOI with its sealed values decrypted with the caller's key and checked against
+				   their digest
+		args 0	:	Caller (a counterparty of the record or the Regulatory Body)
+		args 1	:	Transaction or IOI ID
+		args 2	:	Field key (base64)
+*/
+func (t *SimpleChaincode) unsealRecord(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	if len(args) != 3 {
+		return nil, newError(errArgumentCount, "Incorrect number of arguments")
+	}
+	// the caller's type is not sealed, so a peer without a key can check it too
+	b, err := ledgerState(stub, args[0])
+	if err != nil {
+		return nil, newError(errLedger, "Error while getting "+args[0]+" from ledger")
+	}
+	var caller Entity
+	if json.Unmarshal(b, &caller) != nil || caller.EntityID == "" {
+		return nil, newError(errNotFound, "Entity Not Found", "entity", args[0])
+	}
+	// the record as stored, the key given has to open it on its own
+	b, err = ledgerState(stub, args[1])
+	if err != nil {
+		return nil, newError(errLedger, "Error while getting "+args[1]+" from ledger")
+	}
+	fields, ok := documentFields(b)
+	if !ok {
+		return nil, newError(errNotFound, "Record not found", "id", args[1])
+	}
+	var parties []string
+	switch documentType(fields) {
+	case docTransaction:
+		var tr Transaction
+		json.Unmarshal(b, &tr)
+		parties = []string{tr.FromUser, tr.ToUser}
+	case docIoi:
+		var ioi Ioi
+		json.Unmarshal(b, &ioi)
+		parties = []string{ioi.Owner, ioi.Bank}
+	default:
+		return nil, newError(errInvalidArgument, args[1]+" is not a Transaction or IOI", "id", args[1])
+	}
+	allowed := caller.EntityType == "RegBody"
+	for _, p := range parties {
+		if p == caller.EntityID {
+			allowed = true
+		}
+	}
+	if !allowed {
+		return nil, newError(errNotAuthorized, "Only the counterparties and the Regulatory Body can unseal "+args[1])
+	}
+	key, err := parseFieldKey(args[2])
+	if err != nil {
+		return nil, err
+	}
+	b, err = unsealDocument(key, args[1], b)
+	if err != nil {
+		return nil, err
+	}
+	b, _, err = upgradeDocument(b)
+	return b, err
+}
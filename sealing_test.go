@@ -0,0 +1,110 @@
+package main
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+const testFieldKey = "MDEyMzQ1Njc4OWFiY2RlZg==" // base64 of a 16 byte key
+
+// placeSealedResponse records the response of placeTestResponse quoted at 101.25 and 5.25%, sealed with the key
+func placeSealedResponse(t *testing.T, s *testStub) {
+	key, err := parseFieldKey(testFieldKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.setup(t, func(u *unitOfWork) error {
+		u.fieldKey = key
+		return recordTransaction(u, Transaction{TransactionID: "trans2", TradeID: "trans1", TransactionType: "Response", FromUser: entity7, ToUser: entity3, Symbol: "INST2001", Quantity: 10, InstrumentPrice: 101.25, Rate: 5.25, Status: "Success"})
+	})
+}
+
+func TestSealedQuoteIsNotStoredInTheClear(t *testing.T) {
+	s := newTestStub(t, "2017-03-01T10:00:00Z")
+	placeSealedResponse(t, s)
+
+	stored := string(s.State["trans2"])
+	if !isSealed(s.State["trans2"]) || strings.Contains(stored, "InstrumentPrice") || strings.Contains(stored, "101.25") {
+		t.Errorf("quote stored in the clear: %s", stored)
+	}
+	// queries return it as stored
+	b, err := s.query("readTransaction", "trans2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var tr Transaction
+	json.Unmarshal(b, &tr)
+	if tr.Sealed == "" || tr.InstrumentPrice != 0 || tr.Rate != 0 {
+		t.Errorf("query opened the sealed quote: %s", b)
+	}
+	b, err = s.query("getHistory", entity9, "trans2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(b), "101.25") {
+		t.Errorf("history shows the sealed quote: %s", b)
+	}
+}
+
+func TestUnsealRecord(t *testing.T) {
+	s := newTestStub(t, "2017-03-01T10:00:00Z")
+	placeSealedResponse(t, s)
+
+	b, err := s.query("unsealRecord", entity7, "trans2", testFieldKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var tr Transaction
+	json.Unmarshal(b, &tr)
+	if tr.InstrumentPrice != 101.25 || tr.Rate != 5.25 {
+		t.Errorf("unsealed quote is %s", b)
+	}
+	_, err = s.query("unsealRecord", entity9, "trans2", testFieldKey)
+	if err != nil {
+		t.Errorf("Regulatory Body cannot unseal: %s", err)
+	}
+	other := base64.StdEncoding.EncodeToString([]byte("fedcba9876543210"))
+	_, err = s.query("unsealRecord", entity7, "trans2", other)
+	assertCode(t, err, errNotAuthorized)
+	_, err = s.query("unsealRecord", entity8, "trans2", testFieldKey)
+	assertCode(t, err, errNotAuthorized)
+}
+
+func TestSealedQuoteNeedsTheCallersKey(t *testing.T) {
+	s := newTestStub(t, "2017-03-01T10:00:00Z")
+	s.issue(t, testInstrument("INST2001"), nil)
+	placeSealedResponse(t, s)
+
+	// without the key the quote cannot be copied into the execution
+	_, err := s.invoke(entity3, "tradeExec", entity3, "trans1", "trans2", "no")
+	assertCode(t, err, errNotAuthorized)
+
+	s.fieldKey = "not a key"
+	_, err = s.invoke(entity3, "tradeExec", entity3, "trans1", "trans2", "no")
+	assertCode(t, err, errInvalidArgument)
+
+	// with it the cancellation copies the quote and seals its own copy
+	s.fieldKey = testFieldKey
+	s.mustInvoke(t, entity3, "tradeExec", entity3, "trans1", "trans2", "no")
+	s.fieldKey = ""
+	cancelID := lastTransactionID(t, s)
+	if !isSealed(s.State[cancelID]) || strings.Contains(string(s.State[cancelID]), "101.25") {
+		t.Errorf("cancellation stored the quote in the clear: %s", s.State[cancelID])
+	}
+	b, err := s.query("unsealRecord", entity7, cancelID, testFieldKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var tr Transaction
+	json.Unmarshal(b, &tr)
+	if tr.InstrumentPrice != 101.25 {
+		t.Errorf("cancellation quote is %s", b)
+	}
+}
+
+// lastTransactionID is the transaction numbered by currentTransactionNum
+func lastTransactionID(t *testing.T, s *testStub) string {
+	return "trans" + string(s.State["currentTransactionNum"])
+}
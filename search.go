@@ -0,0 +1,173 @@
+package main
+
+import (
+	"encoding/json"
+	"strconv"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+// The searches take a CouchDB style selector (see selector.go). The state database of this chaincode API has no
+// rich queries, so the selector is evaluated here over the records numbered by the counters. The index definitions
+// under META-INF/statedb/couchdb/indexes cover the fields searched most, for when the selector can be handed to
+// the state database instead.
+
+// counterIDs lists the keys prefix1001 up to the current value of the counter, oldest first
+func counterIDs(stub shim.ChaincodeStubInterface, counter string, prefix string) ([]string, error) {
+	ctidByte, err := stub.GetState(counter)
+	if err != nil {
+		return nil, newError(errLedger, "Error while getting "+counter+" from ledger")
+	}
+	if len(ctidByte) == 0 {
+		return nil, nil
+	}
+	last, err := strconv.Atoi(string(ctidByte))
+	if err != nil {
+		return nil, newError(errLedger, "Error while converting ctidByte to integer")
+	}
+	var ids []string
+	for num := 1001; num <= last; num++ {
+		ids = append(ids, prefix+strconv.Itoa(num))
+	}
+	return ids, nil
+}
+
+// seesAll is true for the entities that may search every record
+func seesAll(entity Entity) bool {
+	return entity.EntityType == "RegBody" || entity.EntityType == "Admin"
+}
+
+/*	searchInstruments - Instruments matching the selector that the caller is entitled to see: every instrument for
+						the Regulatory Body and the Admin, otherwise those the caller issued, owns, arranged or is
+						linked to
+		args 0	:	Caller
+		args 1	:	Selector (JSON), e.g. {"Rate": {"$gt": 5}, "SettlementDate": {"$gte": "2027-01-01"}}
+		args 2	:	List options (JSON, optional)
+*/
+func (t *SimpleChaincode) searchInstruments(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	if len(args) < 2 || len(args) > 3 {
+		return nil, newError(errArgumentCount, "Incorrect number of arguments")
+	}
+	caller, err := getEntityState(stub, args[0])
+	if err != nil {
+		return nil, err
+	}
+	sel, err := compileSelector(args[1], instrumentFields)
+	if err != nil {
+		return nil, err
+	}
+	opts, err := parseListOptions(args, 2)
+	if err != nil {
+		return nil, err
+	}
+	linked := make(map[string]bool)
+	if !seesAll(caller) {
+		symbols, err := getIndexIDs(stub, indexEntityInstrument, caller.EntityID)
+		if err != nil {
+			return nil, err
+		}
+		for _, s := range symbols {
+			linked[s] = true
+		}
+	}
+	ids, err := counterIDs(stub, "currentInstrumentNum", "INST")
+	if err != nil {
+		return nil, err
+	}
+	instrumentArray := []Instrument{}
+	bookmark, err := collectPage(ids, opts, func(symbol string) (bool, error) {
+		b, err := stub.GetState(symbol)
+		if err != nil {
+			return false, newError(errLedger, "Error while getting Instrument info from ledger")
+		}
+		if len(b) == 0 {
+			return false, nil
+		}
+		var inst Instrument
+		err = json.Unmarshal(b, &inst)
+		if err != nil {
+			return false, newError(errLedger, "Error while unmarshalling Instrument data")
+		}
+		if !seesAll(caller) && !linked[symbol] && caller.EntityID != inst.Issuer && caller.EntityID != inst.Owner && caller.EntityID != inst.Bank {
+			return false, nil
+		}
+		if !opts.matchStatus(inst.Status) || !opts.matchSymbol(inst.Symbol) || !opts.matchCounterparty(inst.Issuer, inst.Owner, inst.Bank) {
+			return false, nil
+		}
+		record, err := recordFields(b)
+		if err != nil {
+			return false, err
+		}
+		if !sel.matches(record) {
+			return false, nil
+		}
+		instrumentArray = append(instrumentArray, inst)
+		return true, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return listResponse(instrumentArray, len(instrumentArray), bookmark, opts)
+}
+
+/*	searchTransactions - Transactions matching the selector that the caller is entitled to see: every transaction
+						 for the Regulatory Body and the Admin, otherwise those the caller is a party to
+		args 0	:	Caller
+		args 1	:	Selector (JSON), e.g. {"TransactionType": "Trade", "Amount": {"$gte": 1000000}}
+		args 2	:	List options (JSON, optional)
+*/
+func (t *SimpleChaincode) searchTransactions(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	if len(args) < 2 || len(args) > 3 {
+		return nil, newError(errArgumentCount, "Incorrect number of arguments")
+	}
+	caller, err := getEntityState(stub, args[0])
+	if err != nil {
+		return nil, err
+	}
+	sel, err := compileSelector(args[1], transactionFields)
+	if err != nil {
+		return nil, err
+	}
+	opts, err := parseListOptions(args, 2)
+	if err != nil {
+		return nil, err
+	}
+	ids, err := counterIDs(stub, "currentTransactionNum", "trans")
+	if err != nil {
+		return nil, err
+	}
+	tradesArray := []Transaction{}
+	bookmark, err := collectPage(ids, opts, func(transactionID string) (bool, error) {
+		b, err := stub.GetState(transactionID)
+		if err != nil {
+			return false, newError(errLedger, "Error while getting Transaction info from ledger")
+		}
+		if len(b) == 0 {
+			return false, nil
+		}
+		var tr Transaction
+		err = json.Unmarshal(b, &tr)
+		if err != nil {
+			return false, newError(errLedger, "Error while unmarshalling trades")
+		}
+		if !seesAll(caller) && tr.FromUser != caller.EntityID && tr.ToUser != caller.EntityID {
+			return false, nil
+		}
+		if !opts.period.containsTimeStamp(tr.TimeStamp) || !opts.matchStatus(tr.Status) || !opts.matchSymbol(tr.Symbol) || !opts.matchCounterparty(tr.FromUser, tr.ToUser) {
+			return false, nil
+		}
+		record, err := recordFields(b)
+		if err != nil {
+			return false, err
+		}
+		if !sel.matches(record) {
+			return false, nil
+		}
+		tradesArray = append(tradesArray, tr)
+		return true, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return listResponse(tradesArray, len(tradesArray), bookmark, opts)
+}
@@ -0,0 +1,265 @@
+package main
+
+import (
+	"encoding/json"
+	"strconv"
+	"strings"
+)
+
+// A selector is the subset of the CouchDB query selector the searches accept, for example
+//	{"Rate": {"$gt": 5}, "SettlementDate": {"$gte": "2027-01-01", "$lte": "2027-12-31"}}
+// Fields are matched by equality when given a plain value. "$and" and "$or" take a list of selectors.
+
+// field types, dates compare as YYYY-MM-DD strings
+const (
+	fieldString = "string"
+	fieldNumber = "number"
+	fieldDate   = "date"
+)
+
+// most conditions one selector may hold, keeps a search from becoming an expensive program
+const maxSelectorConditions = 20
+
+var selectorOperators = []string{"$eq", "$ne", "$gt", "$gte", "$lt", "$lte", "$in"}
+
+var instrumentFields = map[string]string{
+	"Symbol":                fieldString,
+	"Coupon":                fieldString,
+	"Quantity":              fieldNumber,
+	"InstrumentPrice":       fieldNumber,
+	"Rate":                  fieldNumber,
+	"SettlementDate":        fieldDate,
+	"IssueDate":             fieldDate,
+	"Callable":              fieldString,
+	"Status":                fieldString,
+	"Owner":                 fieldString,
+	"Bank":                  fieldString,
+	"Issuer":                fieldString,
+	"Redemption":            fieldString,
+	"Calendar":              fieldString,
+	"BusinessDayConvention": fieldString,
+}
+
+var transactionFields = map[string]string{
+	"TransactionID":   fieldString,
+	"TradeID":         fieldString,
+	"TransactionType": fieldString,
+	"FromUser":        fieldString,
+	"ToUser":          fieldString,
+	"Symbol":          fieldString,
+	"Quantity":        fieldNumber,
+	"InstrumentPrice": fieldNumber,
+	"Rate":            fieldNumber,
+	"Amount":          fieldNumber,
+	"SettlementDate":  fieldDate,
+	"Status":          fieldString,
+	"TimeStamp":       fieldDate,
+}
+
+type condition struct {
+	Field   string
+	Type    string
+	Op      string
+	Operand []interface{} // a single value, or the list of $in
+}
+
+// selector matches when every condition, every And selector and at least one Or selector (if any) match
+type selector struct {
+	Conditions []condition
+	And        []selector
+	Or         []selector
+}
+
+// compileSelector parses and checks a selector against the searchable fields of a record type
+func compileSelector(raw string, fields map[string]string) (selector, error) {
+	var doc map[string]interface{}
+	err := json.Unmarshal([]byte(raw), &doc)
+	if err != nil {
+		return selector{}, newError(errInvalidArgument, "Selector should be a JSON object", "selector", raw)
+	}
+	count := 0
+	return compileSelectorObject(doc, fields, &count)
+}
+
+func compileSelectorObject(doc map[string]interface{}, fields map[string]string, count *int) (selector, error) {
+	var s selector
+	for key, value := range doc {
+		if key == "$and" || key == "$or" {
+			list, ok := value.([]interface{})
+			if !ok || len(list) == 0 {
+				return s, newError(errInvalidArgument, key+" takes a non empty list of selectors", "field", key)
+			}
+			for _, item := range list {
+				obj, ok := item.(map[string]interface{})
+				if !ok {
+					return s, newError(errInvalidArgument, key+" takes a non empty list of selectors", "field", key)
+				}
+				sub, err := compileSelectorObject(obj, fields, count)
+				if err != nil {
+					return s, err
+				}
+				if key == "$and" {
+					s.And = append(s.And, sub)
+				} else {
+					s.Or = append(s.Or, sub)
+				}
+			}
+			continue
+		}
+		fieldType, ok := fields[key]
+		if !ok {
+			return s, newError(errInvalidArgument, "Field "+key+" cannot be searched", "field", key)
+		}
+		ops, ok := value.(map[string]interface{})
+		if !ok {
+			ops = map[string]interface{}{"$eq": value}
+		}
+		for op, operand := range ops {
+			c, err := compileCondition(key, fieldType, op, operand)
+			if err != nil {
+				return s, err
+			}
+			*count = *count + 1
+			if *count > maxSelectorConditions {
+				return s, newError(errInvalidArgument, "Selector has more than "+strconv.Itoa(maxSelectorConditions)+" conditions")
+			}
+			s.Conditions = append(s.Conditions, c)
+		}
+	}
+	return s, nil
+}
+
+func compileCondition(field string, fieldType string, op string, operand interface{}) (condition, error) {
+	known := false
+	for _, o := range selectorOperators {
+		if o == op {
+			known = true
+		}
+	}
+	if !known {
+		return condition{}, newError(errInvalidArgument, "Unsupported operator "+op+", expecting one of "+strings.Join(selectorOperators, " "), "field", field)
+	}
+	values := []interface{}{operand}
+	if op == "$in" {
+		list, ok := operand.([]interface{})
+		if !ok || len(list) == 0 {
+			return condition{}, newError(errInvalidArgument, "$in takes a non empty list", "field", field)
+		}
+		values = list
+	}
+	for i, v := range values {
+		switch fieldType {
+		case fieldNumber:
+			if _, ok := v.(float64); !ok {
+				return condition{}, newError(errInvalidArgument, "Field "+field+" compares with numbers", "field", field)
+			}
+		case fieldDate:
+			str, ok := v.(string)
+			if !ok {
+				return condition{}, newError(errInvalidArgument, "Field "+field+" compares with YYYY-MM-DD dates", "field", field)
+			}
+			date, err := normalizeDate(str)
+			if err != nil {
+				return condition{}, newError(errInvalidArgument, "Field "+field+" compares with YYYY-MM-DD dates", "field", field)
+			}
+			values[i] = date
+		default:
+			if _, ok := v.(string); !ok {
+				return condition{}, newError(errInvalidArgument, "Field "+field+" compares with strings", "field", field)
+			}
+		}
+	}
+	return condition{Field: field, Type: fieldType, Op: op, Operand: values}, nil
+}
+
+// matches evaluates the selector on a record given as its generic JSON form
+func (s selector) matches(record map[string]interface{}) bool {
+	for _, c := range s.Conditions {
+		if !c.matches(record[c.Field]) {
+			return false
+		}
+	}
+	for _, sub := range s.And {
+		if !sub.matches(record) {
+			return false
+		}
+	}
+	if len(s.Or) == 0 {
+		return true
+	}
+	for _, sub := range s.Or {
+		if sub.matches(record) {
+			return true
+		}
+	}
+	return false
+}
+
+func (c condition) matches(value interface{}) bool {
+	if c.Type == fieldDate {
+		// timestamps compare on their date
+		str, _ := value.(string)
+		value = dateOf(str)
+		if value == "" {
+			value = upgradeDate(str)
+		}
+	}
+	if c.Op == "$in" {
+		for _, operand := range c.Operand {
+			if compareValues(value, operand) == 0 {
+				return true
+			}
+		}
+		return false
+	}
+	cmp := compareValues(value, c.Operand[0])
+	switch c.Op {
+	case "$eq":
+		return cmp == 0
+	case "$ne":
+		return cmp != 0
+	case "$gt":
+		return cmp == 1
+	case "$gte":
+		return cmp == 1 || cmp == 0
+	case "$lt":
+		return cmp == -1
+	case "$lte":
+		return cmp == -1 || cmp == 0
+	}
+	return false
+}
+
+// compareValues returns -1, 0 or 1, or 2 when the values cannot be compared
+func compareValues(a interface{}, b interface{}) int {
+	switch av := a.(type) {
+	case float64:
+		bv, ok := b.(float64)
+		if !ok {
+			return 2
+		}
+		if av < bv {
+			return -1
+		} else if av > bv {
+			return 1
+		}
+		return 0
+	case string:
+		bv, ok := b.(string)
+		if !ok {
+			return 2
+		}
+		return strings.Compare(av, bv)
+	}
+	return 2
+}
+
+// recordFields is the generic JSON form of a record the selector is evaluated on
+func recordFields(b []byte) (map[string]interface{}, error) {
+	var record map[string]interface{}
+	err := json.Unmarshal(b, &record)
+	if err != nil {
+		return nil, newError(errLedger, "Error while unmarshalling record")
+	}
+	return record, nil
+}
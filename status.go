@@ -0,0 +1,163 @@
+package main
+
+import (
+	"encoding/json"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+// Every successful invocation leaves a status record under its transaction ID, the request ID the client got back
+// when it submitted. A failed invocation fails its transaction with the catalogued error, so nothing it writes
+// survives, its status record included. The client that got the rejection records it with recordFailure, which
+// replays the request in a transaction of its own and commits the Failed status with the error the replay fails
+// with, so the code and reason come from the chaincode and not from the client.
+const indexRequestStatus = "request~status"
+
+const (
+	statusSucceeded = "Succeeded"
+	statusFailed    = "Failed"
+	statusNotFound  = "NotFound" // not committed (yet), never submitted, or failed and not recorded
+)
+
+// RequestStatus is the outcome of one invocation
+type RequestStatus struct {
+	RequestID  string
+	Status     string
+	Function   string            `json:",omitempty"`
+	Caller     string            `json:",omitempty"`
+	TimeStamp  string            `json:",omitempty"` // when it succeeded, or when the failure was recorded
+	Result     string            `json:",omitempty"` // response of a successful invocation, e.g. the ID it created
+	Code       string            `json:",omitempty"` // error of a failed invocation
+	Category   string            `json:",omitempty"`
+	Reason     string            `json:",omitempty"`
+	Details    map[string]string `json:",omitempty"`
+	RecordedIn string            `json:",omitempty"` // transaction of the recordFailure that replayed it
+}
+
+func putRequestStatus(stub shim.ChaincodeStubInterface, status RequestStatus) error {
+	b, err := json.Marshal(status)
+	if err != nil {
+		return newError(errLedger, "Error while marshalling request status")
+	}
+	err = stub.PutState(createCompositeKey(indexRequestStatus, []string{status.RequestID}), b)
+	if err != nil {
+		return newError(errLedger, "Error while writing request status to ledger")
+	}
+	return nil
+}
+
+// newRequestStatus starts the status record of the invocation the unit of work belongs to
+func newRequestStatus(u *unitOfWork, status string) (RequestStatus, error) {
+	timeStamp, err := txTimeStamp(u)
+	if err != nil {
+		return RequestStatus{}, err
+	}
+	return RequestStatus{RequestID: u.GetTxID(), Status: status, Function: u.function, Caller: u.caller, TimeStamp: timeStamp}, nil
+}
+
+// recordSuccess adds the status record to the invocation's writes
+func recordSuccess(u *unitOfWork, result []byte) error {
+	status, err := newRequestStatus(u, statusSucceeded)
+	if err != nil {
+		return err
+	}
+	status.Result = string(result)
+	return putRequestStatus(u, status)
+}
+
+func getRequestStatus(stub shim.ChaincodeStubInterface, requestID string) (RequestStatus, bool, error) {
+	status := RequestStatus{RequestID: requestID, Status: statusNotFound}
+	b, err := stub.GetState(createCompositeKey(indexRequestStatus, []string{requestID}))
+	if err != nil {
+		return status, false, newError(errLedger, "Error while getting request status from ledger")
+	}
+	if len(b) == 0 {
+		return status, false, nil
+	}
+	err = json.Unmarshal(b, &status)
+	if err != nil {
+		return status, false, newError(errLedger, "Error while unmarshalling request status")
+	}
+	return status, true, nil
+}
+
+/*	recordFailure - Records the Failed status of a rejected request. The request is replayed as the caller of
+					recordFailure against the current ledger, in a unit of work that is thrown away, and the error it
+					fails with is recorded. A request that would succeed now is not recorded, submit it again instead.
+		args 0	:	Request ID, the transaction ID of the rejected request
+		args 1	:	Function of the rejected request
+		args 2..:	Arguments of the rejected request
+*/
+func (t *SimpleChaincode) recordFailure(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	if len(args) < 2 {
+		return nil, newError(errArgumentCount, "Incorrect number of arguments")
+	}
+	requestID, function := args[0], args[1]
+	if function == "recordFailure" {
+		return nil, newError(errInvalidArgument, "A recordFailure request cannot be recorded", "function", function)
+	}
+	u, ok := stub.(*unitOfWork)
+	if !ok {
+		return nil, newError(errInternal, "recordFailure runs through a unit of work")
+	}
+	if requestID == u.GetTxID() {
+		return nil, newError(errInvalidArgument, "Request "+requestID+" is this request", "requestID", requestID)
+	}
+	status, found, err := getRequestStatus(u, requestID)
+	if err != nil {
+		return nil, err
+	}
+	if found {
+		return nil, newError(errAlreadyExists, "Request "+requestID+" already has status "+status.Status, "requestID", requestID)
+	}
+	// the replay reads the ledger through a unit of work of its own that is never flushed, so neither its writes
+	// nor its events are kept
+	replay := newUnitOfWork(u.ChaincodeStubInterface)
+	replay.caller = u.caller
+	replay.function = function
+	replay.fieldKey = u.fieldKey
+	replayArgs, _ := splitIdempotencyKey(args[2:])
+	_, err = t.invokeFunction(replay, function, replayArgs)
+	if err == nil {
+		return nil, newError(errInvalidState, "Request "+requestID+" does not fail now, submit it again", "requestID", requestID)
+	}
+	ce := toChaincodeError(err).(*ChaincodeError)
+	status, err = newRequestStatus(u, statusFailed)
+	if err != nil {
+		return nil, err
+	}
+	status.RequestID = requestID
+	status.Function = function
+	status.Code = ce.Code
+	status.Category = ce.Category
+	status.Reason = ce.Message
+	status.Details = ce.Details
+	status.RecordedIn = u.GetTxID()
+	err = putRequestStatus(u, status)
+	if err != nil {
+		return nil, err
+	}
+	b, err := json.Marshal(status)
+	if err != nil {
+		return nil, newError(errLedger, "Error while marshalling request status")
+	}
+	return b, nil
+}
+
+/*	getTransactionStatus - Whether a request succeeded, failed with a code and reason, or is not found
+		args 0	:	Request ID, the transaction ID returned when the request was submitted
+*/
+func (t *SimpleChaincode) getTransactionStatus(stub shim.ChaincodeStubInterface, args []string) ([]byte, error) {
+	if len(args) != 1 {
+		return nil, newError(errArgumentCount, "Incorrect number of arguments")
+	}
+	status, _, err := getRequestStatus(stub, args[0])
+	if err != nil {
+		return nil, err
+	}
+	b, err := json.Marshal(status)
+	if err != nil {
+		return nil, newError(errLedger, "Error while marshalling request status")
+	}
+	return b, nil
+}
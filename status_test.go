@@ -0,0 +1,71 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func (s *testStub) transactionStatus(t *testing.T, requestID string) RequestStatus {
+	b, err := s.query("getTransactionStatus", requestID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var status RequestStatus
+	json.Unmarshal(b, &status)
+	return status
+}
+
+func TestTransactionStatus(t *testing.T) {
+	s := newTestStub(t, "2017-03-01T10:00:00Z")
+	s.mustInvoke(t, entity10, "depositCash", entity10, entity7, "1000", "REF1")
+	if status := s.transactionStatus(t, s.lastTxID()); status.Status != statusSucceeded || status.Function != "depositCash" {
+		t.Errorf("deposit status is %+v", status)
+	}
+
+	// a failed invoke fails its transaction and leaves nothing behind
+	balance := s.entity(t, entity7).Balance
+	_, err := s.invoke(entity10, "withdrawCash", entity10, entity7, "1000000000", "REF2")
+	assertCode(t, err, errInsufficientFunds)
+	if status := s.transactionStatus(t, s.lastTxID()); status.Status != statusNotFound {
+		t.Errorf("failed withdrawal status is %+v", status)
+	}
+	assertBalance(t, s, entity7, balance)
+	failed := s.lastTxID()
+
+	// the client records the rejection, the chaincode replays it for the code and reason
+	s.mustInvoke(t, entity10, "recordFailure", failed, "withdrawCash", entity10, entity7, "1000000000", "REF2")
+	status := s.transactionStatus(t, failed)
+	if status.Status != statusFailed || status.Code != errInsufficientFunds || status.Function != "withdrawCash" || status.Reason == "" {
+		t.Errorf("recorded failure status is %+v", status)
+	}
+	assertBalance(t, s, entity7, balance)
+	_, err = s.invoke(entity10, "recordFailure", failed, "withdrawCash", entity10, entity7, "1000000000", "REF2")
+	assertCode(t, err, errAlreadyExists)
+
+	b, err := s.query("reportExceptions", entity9)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var report ExceptionReport
+	json.Unmarshal(b, &report)
+	if len(report.FailedRequests) != 1 || report.FailedRequests[0].RequestID != failed {
+		t.Errorf("failed request not reported: %s", b)
+	}
+}
+
+func TestRecordFailureReplays(t *testing.T) {
+	s := newTestStub(t, "2017-03-01T10:00:00Z")
+	s.mustInvoke(t, entity10, "depositCash", entity10, entity7, "1000", "REF1")
+	succeeded := s.lastTxID()
+	_, err := s.invoke(entity10, "recordFailure", succeeded, "depositCash", entity10, entity7, "1000", "REF1")
+	assertCode(t, err, errAlreadyExists)
+
+	// a request that would go through now is not recorded as failed, and the replay writes nothing
+	balance := s.entity(t, entity7).Balance
+	_, err = s.invoke(entity10, "recordFailure", "tx999", "depositCash", entity10, entity7, "1000", "REF3")
+	assertCode(t, err, errInvalidState)
+	assertBalance(t, s, entity7, balance)
+	if status := s.transactionStatus(t, "tx999"); status.Status != statusNotFound {
+		t.Errorf("status of a request that would succeed is %+v", status)
+	}
+}
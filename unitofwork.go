@@ -0,0 +1,177 @@
+package main
+
+import (
+	"sort"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+//==============================================================================================================================
+//	 unitOfWork - Buffers the state changes of one invocation. Reads see the buffered writes, and the buffer is only
+//				  written to the ledger by flush, so a handler that fails part way leaves no partial updates behind.
+//==============================================================================================================================
+type unitOfWork struct {
+	shim.ChaincodeStubInterface
+	writes   map[string][]byte
+	deletes  map[string]bool
+	events   []MarketEvent
+	caller   string // enrollment ID of the invoker and the function invoked, recorded in the key history
+	function string
+	fieldKey []byte // the caller's key, seals the records written and opens the records read, see sealing.go
+}
+
+func newUnitOfWork(stub shim.ChaincodeStubInterface) *unitOfWork {
+	return &unitOfWork{
+		ChaincodeStubInterface: stub,
+		writes:                 make(map[string][]byte),
+		deletes:                make(map[string]bool),
+	}
+}
+
+func (u *unitOfWork) GetState(key string) ([]byte, error) {
+	if u.deletes[key] {
+		return nil, nil
+	}
+	// buffered writes are kept in the clear, they are only sealed when flushed
+	if value, ok := u.writes[key]; ok {
+		return value, nil
+	}
+	value, err := u.ChaincodeStubInterface.GetState(key)
+	if err != nil {
+		return nil, err
+	}
+	return u.open(key, value)
+}
+
+// open unseals a stored value the caller's key opens and upgrades it, handlers decode the current schema so
+// documents stored in an older one are upgraded as they are read. Sealed values the invocation has no key for are
+// read as stored.
+func (u *unitOfWork) open(key string, value []byte) ([]byte, error) {
+	if u.fieldKey != nil && isSealed(value) {
+		opened, err := unsealDocument(u.fieldKey, key, value)
+		if err == nil {
+			value = opened
+		} else if ce, ok := err.(*ChaincodeError); !ok || ce.Code != errNotAuthorized {
+			return nil, err
+		}
+	}
+	value, _, err := upgradeDocument(value)
+	if err != nil {
+		return nil, err
+	}
+	return value, nil
+}
+
+// seal is the value as it is stored on the ledger
+func (u *unitOfWork) seal(key string, value []byte) ([]byte, error) {
+	return sealDocument(u.fieldKey, u.GetTxID(), key, value)
+}
+
+func (u *unitOfWork) PutState(key string, value []byte) error {
+	if key == "" {
+		return newError(errLedger, "Key must not be empty")
+	}
+	delete(u.deletes, key)
+	u.writes[key] = stampDocument(value)
+	return nil
+}
+
+func (u *unitOfWork) DelState(key string) error {
+	delete(u.writes, key)
+	u.deletes[key] = true
+	return nil
+}
+
+// RangeQueryState merges the buffered writes into the ledger range, in key order, opening the values read
+func (u *unitOfWork) RangeQueryState(startKey, endKey string) (shim.StateRangeQueryIteratorInterface, error) {
+	iter, err := u.ChaincodeStubInterface.RangeQueryState(startKey, endKey)
+	if err != nil {
+		return nil, err
+	}
+	defer iter.Close()
+	merged := make(map[string][]byte)
+	for iter.HasNext() {
+		key, value, err := iter.Next()
+		if err != nil {
+			return nil, err
+		}
+		merged[key], err = u.open(key, value)
+		if err != nil {
+			return nil, err
+		}
+	}
+	for key, value := range u.writes {
+		if key >= startKey && (endKey == "" || key < endKey) {
+			merged[key] = value
+		}
+	}
+	for key := range u.deletes {
+		delete(merged, key)
+	}
+	keys := make([]string, 0, len(merged))
+	for key := range merged {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return &bufferedIterator{keys: keys, values: merged}, nil
+}
+
+// flush writes the buffered changes to the ledger in key order so every peer produces the same write set
+func (u *unitOfWork) flush() error {
+	err := u.recordHistory()
+	if err != nil {
+		return err
+	}
+	keys := make([]string, 0, len(u.writes))
+	for key := range u.writes {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		value, err := u.seal(key, u.writes[key])
+		if err != nil {
+			return err
+		}
+		err = u.ChaincodeStubInterface.PutState(key, value)
+		if err != nil {
+			return newError(errLedger, "Error while writing " + key + " to ledger")
+		}
+	}
+	keys = keys[:0]
+	for key := range u.deletes {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		err := u.ChaincodeStubInterface.DelState(key)
+		if err != nil {
+			return newError(errLedger, "Error while deleting " + key + " from ledger")
+		}
+	}
+	u.writes = make(map[string][]byte)
+	u.deletes = make(map[string]bool)
+	return nil
+}
+
+type bufferedIterator struct {
+	keys   []string
+	values map[string][]byte
+	next   int
+}
+
+func (it *bufferedIterator) HasNext() bool {
+	return it.next < len(it.keys)
+}
+
+func (it *bufferedIterator) Next() (string, []byte, error) {
+	if it.next >= len(it.keys) {
+		return "", nil, newError(errLedger, "No more keys in range")
+	}
+	key := it.keys[it.next]
+	it.next++
+	return key, it.values[key], nil
+}
+
+func (it *bufferedIterator) Close() error {
+	return nil
+}
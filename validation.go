@@ -0,0 +1,592 @@
+package main
+
+import (
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/hyperledger/fabric/core/chaincode/shim"
+)
+
+// argument kinds
+const (
+	argText       = "text"       // any non empty string
+	argEntity     = "entity"     // ID of an entity on the ledger
+	argInstrument = "instrument" // symbol of an instrument on the ledger
+	argAmount     = "amount"     // number greater than zero
+	argRate       = "rate"       // number zero or greater
+	argCount      = "count"      // whole number greater than zero
+	argDays       = "days"       // whole number zero or greater
+	argDate       = "date"       // YYYY-MM-DD, MM/DD/YYYY is still accepted
+	argEnum       = "enum"       // one of Values
+	argOptions    = "options"    // ListOptions as JSON
+)
+
+// argSpec describes one positional argument
+type argSpec struct {
+	Name       string
+	Kind       string
+	Values     []string // argEnum only
+	IgnoreCase bool     // argEnum only
+	Optional   bool     // only trailing arguments can be optional
+}
+
+// crossCheck is a rule over several arguments. It is only run when every argument it reads parsed on its own,
+// so Valid can ignore parse errors.
+type crossCheck struct {
+	Args    []int
+	Message string
+	Valid   func(args []string) bool
+}
+
+// funcSpec describes the arguments of one function. Repeat, when set, is a group of arguments that follows Args
+// one or more times, like the date/quantity pairs of a schedule, or any number of times when OptionalRepeat is set.
+type funcSpec struct {
+	Args           []argSpec
+	Repeat         []argSpec
+	OptionalRepeat bool
+	Checks         []crossCheck
+}
+
+var yesNo = []string{"yes", "no"}
+
+var invokeSpecs = map[string]funcSpec{
+	"createIssue": {
+		Args: []argSpec{
+			{Name: "caller", Kind: argEntity},
+			{Name: "ioi", Kind: argText},
+			{Name: "coupon", Kind: argText},
+			{Name: "rate", Kind: argRate},
+			{Name: "price", Kind: argAmount},
+			{Name: "maturityDate", Kind: argDate},
+			{Name: "issueDate", Kind: argDate},
+			{Name: "callable", Kind: argEnum, Values: []string{"Yes", "No"}, IgnoreCase: true},
+			{Name: "calendar", Kind: argText, Optional: true},
+			{Name: "convention", Kind: argEnum, Values: conventions, Optional: true},
+			{Name: "settlementDays", Kind: argDays, Optional: true},
+		},
+		Checks: []crossCheck{
+			{Args: []int{5, 6}, Message: "Maturity date should be after the issue date", Valid: func(args []string) bool {
+				return dateAfter(args[5], args[6])
+			}},
+		},
+	},
+	"requestForIssue": {
+		Args: []argSpec{
+			{Name: "caller", Kind: argEntity},
+			{Name: "symbol", Kind: argInstrument},
+			{Name: "counterparty", Kind: argEntity},
+			{Name: "status", Kind: argText},
+		},
+		Checks: []crossCheck{
+			{Args: []int{0, 2}, Message: "Counterparty should differ from the caller", Valid: func(args []string) bool {
+				return args[0] != args[2]
+			}},
+		},
+	},
+	"respondToIssue": {
+		Args: []argSpec{
+			{Name: "caller", Kind: argEntity},
+			{Name: "symbol", Kind: argInstrument},
+			{Name: "response", Kind: argEnum, Values: yesNo},
+			{Name: "status", Kind: argText},
+		},
+	},
+	"tradeExec": {
+		Args: []argSpec{
+			{Name: "caller", Kind: argEntity},
+			{Name: "tradeID", Kind: argText},
+			{Name: "quoteID", Kind: argText},
+			{Name: "response", Kind: argEnum, Values: yesNo, IgnoreCase: true},
+		},
+	},
+	"payCoupon": {
+		Args: []argSpec{
+			{Name: "caller", Kind: argEntity},
+			{Name: "symbol", Kind: argInstrument},
+		},
+	},
+	"issueCallout": {
+		Args: []argSpec{
+			{Name: "caller", Kind: argEntity},
+			{Name: "symbol", Kind: argInstrument},
+		},
+	},
+	"setCouponSchedule": {
+		Args: []argSpec{
+			{Name: "caller", Kind: argEntity},
+			{Name: "symbol", Kind: argInstrument},
+		},
+		Repeat: []argSpec{
+			{Name: "date", Kind: argDate},
+		},
+	},
+	"requestForInstrument": {
+		Args: []argSpec{
+			{Name: "caller", Kind: argEntity},
+			{Name: "bank", Kind: argEntity},
+			{Name: "notional", Kind: argAmount},
+			{Name: "tenor", Kind: argText},
+		},
+	},
+	"setRedemptionSchedule": {
+		Args: []argSpec{
+			{Name: "caller", Kind: argEntity},
+			{Name: "symbol", Kind: argInstrument},
+			{Name: "redemption", Kind: argEnum, Values: []string{"Amortizing", "SinkingFund"}},
+			{Name: "method", Kind: argEnum, Values: []string{"ProRata", "Lottery"}},
+		},
+		Repeat: []argSpec{
+			{Name: "date", Kind: argDate},
+			{Name: "quantity", Kind: argCount},
+		},
+	},
+	"redeemPrincipal": {
+		Args: []argSpec{
+			{Name: "caller", Kind: argEntity},
+			{Name: "symbol", Kind: argInstrument},
+		},
+	},
+	"offerRepurchase": {
+		Args: []argSpec{
+			{Name: "caller", Kind: argEntity},
+			{Name: "symbol", Kind: argInstrument},
+			{Name: "price", Kind: argAmount},
+			{Name: "quantity", Kind: argDays},
+		},
+	},
+	"tenderUnits": {
+		Args: []argSpec{
+			{Name: "caller", Kind: argEntity},
+			{Name: "symbol", Kind: argInstrument},
+			{Name: "quantity", Kind: argCount},
+		},
+	},
+	"setPutSchedule": {
+		Args: []argSpec{
+			{Name: "caller", Kind: argEntity},
+			{Name: "symbol", Kind: argInstrument},
+			{Name: "noticeDays", Kind: argDays},
+		},
+		Repeat: []argSpec{
+			{Name: "date", Kind: argDate},
+			{Name: "price", Kind: argAmount},
+		},
+	},
+	"exercisePut": {
+		Args: []argSpec{
+			{Name: "caller", Kind: argEntity},
+			{Name: "symbol", Kind: argInstrument},
+			{Name: "putDate", Kind: argDate},
+			{Name: "quantity", Kind: argCount},
+		},
+	},
+	"settlePuts": {
+		Args: []argSpec{
+			{Name: "caller", Kind: argEntity},
+			{Name: "symbol", Kind: argInstrument},
+		},
+	},
+	"distributeRecovery": {
+		Args: []argSpec{
+			{Name: "caller", Kind: argEntity},
+			{Name: "symbol", Kind: argInstrument},
+			{Name: "amount", Kind: argAmount},
+		},
+	},
+	"depositCash": {
+		Args: []argSpec{
+			{Name: "caller", Kind: argEntity},
+			{Name: "entity", Kind: argEntity},
+			{Name: "amount", Kind: argAmount},
+			{Name: "externalRef", Kind: argText},
+		},
+	},
+	"withdrawCash": {
+		Args: []argSpec{
+			{Name: "caller", Kind: argEntity},
+			{Name: "entity", Kind: argEntity},
+			{Name: "amount", Kind: argAmount},
+			{Name: "externalRef", Kind: argText},
+		},
+	},
+	"cancelHold": {
+		Args: []argSpec{
+			{Name: "caller", Kind: argEntity},
+			{Name: "holdID", Kind: argText},
+		},
+	},
+	"releaseExpiredHolds": {
+		Args: []argSpec{
+			{Name: "caller", Kind: argEntity},
+		},
+	},
+	"setCalendar": {
+		Args: []argSpec{
+			{Name: "caller", Kind: argEntity},
+			{Name: "market", Kind: argText},
+		},
+		Repeat: []argSpec{
+			{Name: "holiday", Kind: argDate},
+		},
+		OptionalRepeat: true,
+	},
+	"setInstrumentCalendar": {
+		Args: []argSpec{
+			{Name: "caller", Kind: argEntity},
+			{Name: "symbol", Kind: argInstrument},
+			{Name: "calendar", Kind: argText},
+			{Name: "convention", Kind: argEnum, Values: conventions},
+			{Name: "settlementDays", Kind: argDays},
+		},
+	},
+	"migrate": {
+		Args: []argSpec{
+			{Name: "caller", Kind: argEntity},
+		},
+	},
+	"setCreditRating": {
+		Args: []argSpec{
+			{Name: "caller", Kind: argEntity},
+			{Name: "subject", Kind: argText},
+			{Name: "rating", Kind: argText, Optional: true},
+		},
+	},
+	"setMandate": {
+		Args: []argSpec{
+			{Name: "caller", Kind: argEntity},
+			{Name: "investor", Kind: argEntity},
+			{Name: "minRating", Kind: argText, Optional: true},
+			{Name: "maxTenor", Kind: argText, Optional: true},
+			{Name: "callable", Kind: argText, Optional: true},
+		},
+	},
+	"setExposureLimits": {
+		Args: []argSpec{
+			{Name: "caller", Kind: argEntity},
+			{Name: "entity", Kind: argEntity},
+			{Name: "maxIssuer", Kind: argRate},
+			{Name: "maxInstrument", Kind: argRate},
+			{Name: "maxConcentration", Kind: argRate},
+			{Name: "maxGross", Kind: argRate},
+		},
+	},
+	"upgradeIndexes": {
+		Args: []argSpec{
+			{Name: "caller", Kind: argEntity},
+		},
+	},
+	"recordFailure": {
+		Args: []argSpec{
+			{Name: "requestID", Kind: argText},
+			{Name: "function", Kind: argText},
+		},
+		Repeat:         []argSpec{{Name: "arg", Kind: argText}},
+		OptionalRepeat: true,
+	},
+}
+
+var querySpecs = map[string]funcSpec{
+	"readEntity":         {Args: []argSpec{{Name: "entity", Kind: argEntity}}},
+	"readTransaction":    {Args: []argSpec{{Name: "transactionID", Kind: argText}}},
+	"getValue":           {Args: []argSpec{{Name: "key", Kind: argText}}},
+	"readTradeIDsOfUser": {Args: []argSpec{{Name: "entity", Kind: argEntity}}},
+	"readTrades": {Args: []argSpec{
+		{Name: "entity", Kind: argEntity},
+		{Name: "options", Kind: argOptions, Optional: true},
+	}},
+	"getEntities":          {Args: []argSpec{{Name: "options", Kind: argOptions, Optional: true}}},
+	"getAllTrades":         {Args: []argSpec{{Name: "entity", Kind: argEntity}}},
+	"getTransactionStatus": {Args: []argSpec{{Name: "requestID", Kind: argText}}},
+	"getInstrument":        {Args: []argSpec{{Name: "symbol", Kind: argInstrument}}},
+	"getAllInstruments": {
+		Args: []argSpec{
+			{Name: "entity", Kind: argEntity},
+			{Name: "status", Kind: argText},
+			{Name: "issuedFrom", Kind: argDate, Optional: true},
+			{Name: "issuedTo", Kind: argDate, Optional: true},
+			{Name: "options", Kind: argOptions, Optional: true},
+		},
+		Checks: []crossCheck{dateOrder(2, 3)},
+	},
+	"getAllInstrumentTrades": {
+		Args: []argSpec{
+			{Name: "entity", Kind: argEntity},
+			{Name: "symbol", Kind: argInstrument},
+			{Name: "from", Kind: argDate, Optional: true},
+			{Name: "to", Kind: argDate, Optional: true},
+			{Name: "options", Kind: argOptions, Optional: true},
+		},
+		Checks: []crossCheck{dateOrder(2, 3)},
+	},
+	"getAllIoi": {Args: []argSpec{
+		{Name: "entity", Kind: argEntity},
+		{Name: "options", Kind: argOptions, Optional: true},
+	}},
+	"getDefaultedIssues": {Args: []argSpec{{Name: "caller", Kind: argEntity}}},
+	"getDefaultExposure": {Args: []argSpec{
+		{Name: "caller", Kind: argEntity},
+		{Name: "symbol", Kind: argInstrument, Optional: true},
+	}},
+	"getCashLedger": {Args: []argSpec{
+		{Name: "caller", Kind: argEntity},
+		{Name: "entity", Kind: argEntity, Optional: true},
+	}},
+	"getStatement": {
+		Args: []argSpec{
+			{Name: "caller", Kind: argEntity},
+			{Name: "entity", Kind: argEntity},
+			{Name: "from", Kind: argDate},
+			{Name: "to", Kind: argDate},
+		},
+		Checks: []crossCheck{dateOrder(2, 3)},
+	},
+	"getJournalBalance": {Args: []argSpec{
+		{Name: "caller", Kind: argEntity},
+		{Name: "entity", Kind: argEntity, Optional: true},
+	}},
+	"getHolds": {Args: []argSpec{
+		{Name: "caller", Kind: argEntity},
+		{Name: "entity", Kind: argEntity, Optional: true},
+	}},
+	"getCalendar": {Args: []argSpec{{Name: "market", Kind: argText, Optional: true}}},
+	"searchInstruments": {Args: []argSpec{
+		{Name: "caller", Kind: argEntity},
+		{Name: "selector", Kind: argText},
+		{Name: "options", Kind: argOptions, Optional: true},
+	}},
+	"searchTransactions": {Args: []argSpec{
+		{Name: "caller", Kind: argEntity},
+		{Name: "selector", Kind: argText},
+		{Name: "options", Kind: argOptions, Optional: true},
+	}},
+	"getHistory": {Args: []argSpec{
+		{Name: "caller", Kind: argEntity},
+		{Name: "key", Kind: argText},
+	}},
+	"unsealRecord": {Args: []argSpec{
+		{Name: "caller", Kind: argEntity},
+		{Name: "id", Kind: argText},
+		{Name: "fieldKey", Kind: argText},
+	}},
+	"reportIssuance": {
+		Args: []argSpec{
+			{Name: "caller", Kind: argEntity},
+			{Name: "from", Kind: argDate, Optional: true},
+			{Name: "to", Kind: argDate, Optional: true},
+		},
+		Checks: []crossCheck{dateOrder(1, 2)},
+	},
+	"reportHoldings": {
+		Args: []argSpec{
+			{Name: "caller", Kind: argEntity},
+			{Name: "from", Kind: argDate, Optional: true},
+			{Name: "to", Kind: argDate, Optional: true},
+		},
+		Checks: []crossCheck{dateOrder(1, 2)},
+	},
+	"reportVolume": {
+		Args: []argSpec{
+			{Name: "caller", Kind: argEntity},
+			{Name: "from", Kind: argDate, Optional: true},
+			{Name: "to", Kind: argDate, Optional: true},
+		},
+		Checks: []crossCheck{dateOrder(1, 2)},
+	},
+	"reportCommissions": {
+		Args: []argSpec{
+			{Name: "caller", Kind: argEntity},
+			{Name: "from", Kind: argDate, Optional: true},
+			{Name: "to", Kind: argDate, Optional: true},
+		},
+		Checks: []crossCheck{dateOrder(1, 2)},
+	},
+	"reportExceptions": {
+		Args: []argSpec{
+			{Name: "caller", Kind: argEntity},
+			{Name: "from", Kind: argDate, Optional: true},
+			{Name: "to", Kind: argDate, Optional: true},
+		},
+		Checks: []crossCheck{dateOrder(1, 2)},
+	},
+	"getEligibleInvestors": {Args: []argSpec{
+		{Name: "caller", Kind: argEntity},
+		{Name: "symbol", Kind: argInstrument},
+		{Name: "date", Kind: argDate, Optional: true},
+	}},
+	"getExposure": {Args: []argSpec{
+		{Name: "caller", Kind: argEntity},
+		{Name: "entity", Kind: argEntity, Optional: true},
+	}},
+	"getIdempotencyKeys": {Args: []argSpec{
+		{Name: "caller", Kind: argEntity},
+		{Name: "entity", Kind: argEntity, Optional: true},
+		{Name: "options", Kind: argOptions, Optional: true},
+	}},
+}
+
+// dateOrder checks that the from date at args[from] is not after the to date at args[to]
+func dateOrder(from int, to int) crossCheck {
+	return crossCheck{Args: []int{from, to}, Message: "From date should not be after the to date", Valid: func(args []string) bool {
+		return !dateAfter(args[from], args[to])
+	}}
+}
+
+// dateAfter reports whether date a is strictly after date b, both business dates
+func dateAfter(a string, b string) bool {
+	ta, err := parseDate(a)
+	if err != nil {
+		return false
+	}
+	tb, err := parseDate(b)
+	if err != nil {
+		return false
+	}
+	return ta.After(tb)
+}
+
+//==============================================================================================================================
+//	 validateArgs - Checks args against the spec of the function before it is dispatched. Every violation is reported
+//					in a single INVALID_ARGUMENT error, keyed by argument position and name. Functions without a spec
+//					are not checked.
+//==============================================================================================================================
+func validateArgs(stub shim.ChaincodeStubInterface, specs map[string]funcSpec, function string, args []string) error {
+	spec, ok := specs[function]
+	if !ok {
+		return nil
+	}
+	required := 0
+	for _, a := range spec.Args {
+		if !a.Optional {
+			required++
+		}
+	}
+	positional := spec.Args
+	if len(spec.Repeat) > 0 {
+		extra := len(args) - len(spec.Args)
+		minimum := len(spec.Repeat)
+		if spec.OptionalRepeat {
+			minimum = 0
+		}
+		if extra < minimum || extra%len(spec.Repeat) != 0 {
+			return newError(errArgumentCount, "Incorrect number of arguments for "+function,
+				"expected", strconv.Itoa(len(spec.Args))+" followed by groups of "+strconv.Itoa(len(spec.Repeat)),
+				"received", strconv.Itoa(len(args)))
+		}
+		for len(positional) < len(args) {
+			positional = append(positional, spec.Repeat...)
+		}
+	} else if len(args) < required || len(args) > len(spec.Args) {
+		expected := strconv.Itoa(len(spec.Args))
+		if required != len(spec.Args) {
+			expected = strconv.Itoa(required) + " to " + expected
+		}
+		return newError(errArgumentCount, "Incorrect number of arguments for "+function,
+			"expected", expected,
+			"received", strconv.Itoa(len(args)))
+	}
+
+	var details []string
+	failed := make(map[int]bool)
+	for i, arg := range args {
+		if positional[i].Optional && arg == "" {
+			continue
+		}
+		problem, err := checkArg(stub, positional[i], arg)
+		if err != nil {
+			return err
+		}
+		if problem != "" {
+			failed[i] = true
+			details = append(details, "args["+strconv.Itoa(i)+"] "+positional[i].Name, problem)
+		}
+	}
+	for _, c := range spec.Checks {
+		runnable := true
+		for _, i := range c.Args {
+			if i >= len(args) || failed[i] {
+				runnable = false
+			}
+		}
+		if runnable && !c.Valid(args) {
+			names := make([]string, len(c.Args))
+			for j, i := range c.Args {
+				names[j] = positional[i].Name
+			}
+			details = append(details, strings.Join(names, ","), c.Message)
+		}
+	}
+	if len(details) > 0 {
+		return newError(errInvalidArgument, "Invalid arguments for "+function, details...)
+	}
+	return nil
+}
+
+// checkArg returns what is wrong with the argument, or an error when the ledger could not be read
+func checkArg(stub shim.ChaincodeStubInterface, spec argSpec, arg string) (string, error) {
+	if strings.TrimSpace(arg) == "" {
+		return "is required", nil
+	}
+	switch spec.Kind {
+	case argEntity, argInstrument:
+		// only whether it exists and what it is, the record is opened by the handler
+		b, err := ledgerState(stub, arg)
+		if err != nil {
+			return "", newError(errLedger, "Error while getting "+arg+" from ledger")
+		}
+		if len(b) == 0 {
+			return "unknown " + spec.Kind + " " + arg, nil
+		}
+		want := docEntity
+		if spec.Kind == argInstrument {
+			want = docInstrument
+		}
+		fields, ok := documentFields(b)
+		if !ok || documentType(fields) != want {
+			return "not an " + spec.Kind + ": " + arg, nil
+		}
+	case argAmount, argRate:
+		f, err := strconv.ParseFloat(arg, 64)
+		if err != nil || math.IsNaN(f) || math.IsInf(f, 0) {
+			return "not a number: " + arg, nil
+		}
+		if spec.Kind == argAmount && f <= 0 {
+			return "should be greater than zero", nil
+		}
+		if f < 0 {
+			return "should not be negative", nil
+		}
+	case argCount, argDays:
+		n, err := strconv.Atoi(arg)
+		if err != nil {
+			return "not a whole number: " + arg, nil
+		}
+		if spec.Kind == argCount && n <= 0 {
+			return "should be greater than zero", nil
+		}
+		if n < 0 {
+			return "should not be negative", nil
+		}
+	case argDate:
+		_, err := parseDate(arg)
+		if err != nil {
+			return "not a date: " + arg + ", expecting YYYY-MM-DD", nil
+		}
+	case argOptions:
+		_, err := parseListOptions([]string{arg}, 0)
+		if err != nil {
+			if e, ok := err.(*ChaincodeError); ok {
+				return e.Message, nil
+			}
+			return err.Error(), nil
+		}
+	case argEnum:
+		for _, v := range spec.Values {
+			if arg == v || (spec.IgnoreCase && strings.EqualFold(arg, v)) {
+				return "", nil
+			}
+		}
+		return "should be one of " + strings.Join(spec.Values, "/"), nil
+	}
+	return "", nil
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"testing"
+)
+
+func TestValidateArgs(t *testing.T) {
+	s := newTestStub(t, "2017-03-01T10:00:00Z")
+	s.issue(t, testInstrument("BOND1"), nil)
+	u := newUnitOfWork(s)
+	cases := []struct {
+		function string
+		args     []string
+		code     string
+		detail   string // the problem reported for the argument, empty when the count is wrong
+		key      string
+	}{
+		{"depositCash", []string{entity10, entity7, "1000", "REF1"}, "", "", ""},
+		{"depositCash", []string{entity10, entity7, "1000"}, errArgumentCount, "", ""},
+		{"depositCash", []string{entity10, "nobody", "1000", "REF1"}, errInvalidArgument, "unknown entity nobody", "args[1] entity"},
+		{"depositCash", []string{entity10, "BOND1", "1000", "REF1"}, errInvalidArgument, "not an entity: BOND1", "args[1] entity"},
+		{"payCoupon", []string{entity1, entity7}, errInvalidArgument, "not an instrument: " + entity7, "args[1] symbol"},
+		{"depositCash", []string{entity10, entity7, "-5", "REF1"}, errInvalidArgument, "should be greater than zero", "args[2] amount"},
+		{"getStatement", []string{entity7, entity7, "2017-13-01", "2017-03-01"}, errInvalidArgument, "not a date: 2017-13-01, expecting YYYY-MM-DD", "args[2] from"},
+		{"getStatement", []string{entity7, entity7, "2017-03-02", "2017-03-01"}, errInvalidArgument, "From date should not be after the to date", "from,to"},
+		{"getStatement", []string{entity7, entity7, "01/02/2017", "2017-03-01"}, "", "", ""},
+		{"setRedemptionSchedule", []string{entity1, "nothing", "Amortizing", "ProRata", "2017-03-01"}, errArgumentCount, "", ""},
+		{"noSuchFunction", []string{"anything"}, "", "", ""},
+	}
+	for _, c := range cases {
+		specs := invokeSpecs
+		if _, ok := querySpecs[c.function]; ok {
+			specs = querySpecs
+		}
+		err := validateArgs(u, specs, c.function, c.args)
+		if c.code == "" {
+			if err != nil {
+				t.Errorf("%s %v: unexpected %s", c.function, c.args, err)
+			}
+			continue
+		}
+		if errorCode(err) != c.code {
+			t.Errorf("%s %v: expected %s, got %v", c.function, c.args, c.code, err)
+			continue
+		}
+		if c.key != "" && err.(*ChaincodeError).Details[c.key] != c.detail {
+			t.Errorf("%s %v: %s reported as %q, expected %q", c.function, c.args, c.key, err.(*ChaincodeError).Details[c.key], c.detail)
+		}
+	}
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// Every endorser has to compute the same writes, so the chaincode takes the time from the transaction and never from
+// the clock of the peer it runs on
+func TestNoWallClock(t *testing.T) {
+	files, err := filepath.Glob("*.go")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fset := token.NewFileSet()
+	for _, file := range files {
+		if strings.HasSuffix(file, "_test.go") {
+			continue
+		}
+		f, err := parser.ParseFile(fset, file, nil, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		timePackage := ""
+		for _, imp := range f.Imports {
+			if imp.Path.Value == `"time"` {
+				timePackage = "time"
+				if imp.Name != nil {
+					timePackage = imp.Name.Name
+				}
+			}
+		}
+		if timePackage == "" {
+			continue
+		}
+		ast.Inspect(f, func(n ast.Node) bool {
+			sel, ok := n.(*ast.SelectorExpr)
+			if !ok {
+				return true
+			}
+			pkg, ok := sel.X.(*ast.Ident)
+			if !ok || pkg.Name != timePackage {
+				return true
+			}
+			switch sel.Sel.Name {
+			case "Now", "Since", "Until", "Tick", "After", "AfterFunc", "NewTimer", "NewTicker", "Sleep":
+				t.Errorf("%s: time.%s reads the peer's clock, use txTime", fset.Position(sel.Pos()), sel.Sel.Name)
+			}
+			return true
+		})
+	}
+}